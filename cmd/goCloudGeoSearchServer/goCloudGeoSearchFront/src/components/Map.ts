@@ -25,15 +25,19 @@ import OlCircle from "ol/style/Circle"
 import OlFill from "ol/style/Fill"
 import { register } from "ol/proj/proj4"
 // import LayerSwitcher from "ol-layerswitcher"
-import { getLog } from "../config"
+import { getConfig, getLog } from "../config"
 import { isNullOrUndefined } from "../tools/utils"
 import { Coordinate } from "ol/coordinate"
 
 const log = getLog("Map", 4, 2)
 
-const urlLausanneMN95 = "https://tilesmn95.lausanne.ch/tiles/1.0.0/LausanneWMTS.xml"
-const MaxExtent = [2532500, 1149000, 2545625, 1161000]
 const lausanneGare = [2537968.5, 1152088.0]
+// baseMapTitles are the titles of the known base maps, the others are shown with their layer name
+const baseMapTitles: Record<string, string> = {
+  orthophotos_ortho_lidar_2016: "Orthophoto 2016 (Lausanne)",
+  fonds_geo_osm_bdcad_gris: "Fond cadastral (Lausanne)",
+  fonds_geo_osm_bdcad_couleur: "Plan ville (Lausanne)",
+}
 
 export interface mapFeatureInfo {
   id: string
@@ -58,11 +62,6 @@ proj4.defs(
 )
 
 register(proj4)
-const swissProjection = new OlProjection({
-  code: "EPSG:2056",
-  extent: MaxExtent,
-  units: "m",
-})
 const parser = new OlFormatWMTSCapabilities()
 
 export interface IMarkerFeature {
@@ -110,30 +109,16 @@ async function getWmtsBaseLayers(url: string, defaultBaseLayer: string) {
 
     const WMTSCapabilitiesParsed = parser.read(WMTSCapabilities)
     // console.log(`## in getWmtsBaseLayers(${url} : WMTSCapabilitiesParsed : \n`, WMTSCapabilitiesParsed)
-    arrWmtsLayers.push(
-      createBaseOlLayerTile(
-        WMTSCapabilitiesParsed,
-        "Orthophoto 2016 (Lausanne)",
-        "orthophotos_ortho_lidar_2016",
-        defaultBaseLayer === "orthophotos_ortho_lidar_2016"
-      )
-    )
-    arrWmtsLayers.push(
-      createBaseOlLayerTile(
-        WMTSCapabilitiesParsed,
-        "Fond cadastral (Lausanne)",
-        "fonds_geo_osm_bdcad_gris",
-        defaultBaseLayer === "fonds_geo_osm_bdcad_gris"
-      )
-    )
-    arrWmtsLayers.push(
-      createBaseOlLayerTile(
-        WMTSCapabilitiesParsed,
-        "Plan ville (Lausanne)",
-        "fonds_geo_osm_bdcad_couleur",
-        defaultBaseLayer === "fonds_geo_osm_bdcad_couleur"
+    getConfig().map.baseMaps.forEach((layerName) => {
+      arrWmtsLayers.push(
+        createBaseOlLayerTile(
+          WMTSCapabilitiesParsed,
+          baseMapTitles[layerName] ?? layerName,
+          layerName,
+          defaultBaseLayer === layerName
+        )
       )
-    )
+    })
     return arrWmtsLayers
   } catch (err) {
     const message = `###!### ERROR in getWmtsBaseLayers occured with url:${url}: error is: ${err}`
@@ -328,7 +313,7 @@ export const addGeoJsonLayer = (olMap: OlMap , layerName: string, geoJsonData: o
  * @param divOfMap the id of the div you want to draw a map
  * @param centerOfMap the position where you want to center map in MN95 Coordinates [x,y] array
  * @param zoomLevel
- * @param baseLayer one of orthophotos_ortho_lidar_2016 fonds_geo_osm_bdcad_(gris|couleur)
+ * @param baseLayer one of the base maps of the runtime configuration
  * @returns an instance of an OpenLayer Map
  */
 export async function createLausanneMap(
@@ -338,7 +323,8 @@ export async function createLausanneMap(
   baseLayer = "fonds_geo_osm_bdcad_couleur"
 ) {
   log.t(`createLausanneMap(x,y: [${centerOfMap[0]},${centerOfMap[1]}]  zoom:${zoomLevel})`)
-  const arrBaseLayers = await getWmtsBaseLayers(urlLausanneMN95, baseLayer)
+  const { srsId, extent, wmtsUrl } = getConfig().map
+  const arrBaseLayers = await getWmtsBaseLayers(wmtsUrl, baseLayer)
   if (arrBaseLayers === null || arrBaseLayers.length < 1) {
     log.w("arrBaseLayers cannot be null or empty to be able to see a nice map !")
     return null
@@ -347,7 +333,11 @@ export async function createLausanneMap(
     target: divOfMap,
     layers: arrBaseLayers,
     view: new OlView({
-      projection: swissProjection,
+      projection: new OlProjection({
+        code: `EPSG:${srsId}`,
+        extent,
+        units: "m",
+      }),
       center: centerOfMap,
       zoom: zoomLevel,
     }),
@@ -1,16 +1,80 @@
 import { levelLog, Log } from "./log"
 
-export const APP = "goCloudK8sThing"
-export const APP_TITLE = "Goéland-Thing"
-export const VERSION = "0.0.7"
-export const BUILD_DATE = "2024-02-22"
+export const APP_TITLE = "Goéland-GeoSearch"
 // eslint-disable-next-line no-undef
 export const DEV = process.env.NODE_ENV === "development"
 export const HOME = DEV ? "http://localhost:3000/" : "/"
 // eslint-disable-next-line no-restricted-globals
 const url = new URL(location.toString())
-export const BACKEND_URL = DEV ? "http://localhost:9191" : url.origin
+const DEV_BACKEND_URL = "http://localhost:9191"
 export const getLog = (ModuleName: string, verbosityDev: levelLog, verbosityProd: levelLog) =>
   DEV ? new Log(ModuleName, verbosityDev) : new Log(ModuleName, verbosityProd)
 
 export const defaultAxiosTimeout = 10000 // 10 sec
+
+export interface MapConfig {
+  srsId: number
+  center: number[]
+  zoom: number
+  extent: number[]
+  wmtsUrl: string
+  baseMaps: string[]
+  baseMap: string
+  layers: string[]
+}
+
+// RuntimeConfig is served by the backend on /config.json, so the same build can be deployed in any environment
+export interface RuntimeConfig {
+  app: string
+  version: string
+  revision: string
+  buildStamp: string
+  repository: string
+  backendUrl: string
+  map: MapConfig
+}
+
+// defaultConfig is only used when /config.json cannot be loaded
+const defaultConfig: RuntimeConfig = {
+  app: "goCloudGeoSearch",
+  version: "unknown",
+  revision: "unknown",
+  buildStamp: "unknown",
+  repository: "",
+  backendUrl: "",
+  map: {
+    srsId: 2056,
+    center: [2538202, 1152364],
+    zoom: 8,
+    extent: [2532500, 1149000, 2545625, 1161000],
+    wmtsUrl: "https://tilesmn95.lausanne.ch/tiles/1.0.0/LausanneWMTS.xml",
+    baseMaps: ["orthophotos_ortho_lidar_2016", "fonds_geo_osm_bdcad_gris", "fonds_geo_osm_bdcad_couleur"],
+    baseMap: "fonds_geo_osm_bdcad_couleur",
+    layers: ["adresses", "communes"],
+  },
+}
+
+let runtimeConfig: RuntimeConfig = defaultConfig
+export let BACKEND_URL = DEV ? DEV_BACKEND_URL : url.origin
+
+/**
+ * loadConfig fetches the runtime configuration of the backend, it must be awaited before mounting the application
+ */
+export async function loadConfig(): Promise<RuntimeConfig> {
+  try {
+    const response = await fetch(`${BACKEND_URL}/config.json`, { cache: "no-cache" })
+    if (!response.ok) {
+      throw new Error(`http status: ${response.status}`)
+    }
+    const loaded = (await response.json()) as RuntimeConfig
+    runtimeConfig = { ...defaultConfig, ...loaded, map: { ...defaultConfig.map, ...loaded.map } }
+    if (runtimeConfig.backendUrl !== "") {
+      BACKEND_URL = runtimeConfig.backendUrl
+    }
+  } catch (err) {
+    console.warn(`###!### ERROR in loadConfig, using the default configuration : ${err}`)
+  }
+  return runtimeConfig
+}
+
+export const getConfig = (): RuntimeConfig => runtimeConfig
@@ -1,5 +1,6 @@
 import { createApp } from 'vue'
 import './style.css'
 import App from './App.vue'
+import { loadConfig } from './config'
 
-createApp(App).mount('#app')
+loadConfig().then(() => createApp(App).mount('#app'))
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/config"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/auth"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/features"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/frontconfig"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/go-http-server"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/metrics"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/tiles"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/version"
+	"io/fs"
 	"log"
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
 )
 
 const (
@@ -31,10 +45,152 @@ func main() {
 	if err != nil {
 		l.Fatal("💥💥 error doing config.GetPortFromEnv got error: %v'\n", err)
 	}
+	dbConfig, err := database.GetConfigFromEnv(runtime.NumCPU())
+	if err != nil {
+		l.Fatal("💥💥 error doing database.GetConfigFromEnv got error: %v'\n", err)
+	}
+	l.Info("'Will connect to database : %s'", dbConfig)
+	db, err := database.GetInstanceFromConfig(dbConfig, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing database.GetInstanceFromConfig got error: %v'\n", err)
+	}
+	tilesCacheMaxBytes, err := tiles.GetCacheMaxBytesFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing tiles.GetCacheMaxBytesFromEnv got error: %v'\n", err)
+	}
+	tileServer, err := tiles.NewServer(context.Background(), db, tiles.DefaultLayers, tilesCacheMaxBytes, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing tiles.NewServer got error: %v'\n", err)
+	}
+
+	frontConfig, err := frontconfig.GetConfigFromEnv(tileServer.LayerNames())
+	if err != nil {
+		l.Fatal("💥💥 error doing frontconfig.GetConfigFromEnv got error: %v'\n", err)
+	}
+	dist, err := fs.Sub(content, "goCloudGeoSearchFront/dist")
+	if err != nil {
+		l.Fatal("💥💥 error doing fs.Sub(content, dist) got error: %v'\n", err)
+	}
+	staticFiles, err := go_http_server.NewStaticFiles(dist, l)
+	if err != nil {
+		l.Fatal("💥💥 error doing go_http_server.NewStaticFiles got error: %v'\n", err)
+	}
+
+	authConfig, err := auth.GetConfigFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing auth.GetConfigFromEnv got error: %v'\n", err)
+	}
+	trustedProxies, err := go_http_server.GetTrustedProxiesFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing go_http_server.GetTrustedProxiesFromEnv got error: %v'\n", err)
+	}
+	corsConfig, err := go_http_server.GetCorsConfigFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing go_http_server.GetCorsConfigFromEnv got error: %v'\n", err)
+	}
+	shutdownConfig, err := go_http_server.GetShutdownConfigFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing go_http_server.GetShutdownConfigFromEnv got error: %v'\n", err)
+	}
+	tlsConfig, err := go_http_server.GetTLSConfigFromEnv()
+	if err != nil {
+		l.Fatal("💥💥 error doing go_http_server.GetTLSConfigFromEnv got error: %v'\n", err)
+	}
+	rateLimits := map[string]go_http_server.RateLimit{
+		"api":   {Rate: 20, Burst: 100},     // search, ogc features and spatial queries hitting the database
+		"tiles": {Rate: 100, Burst: 400},    // a map view loads dozens of tiles at once, most of them cached
+		"login": {Rate: 1.0 / 60, Burst: 5}, // against password guessing
+	}
+	apiKeys := go_http_server.GetAPIKeysFromEnv()
+	limiters := map[string]go_http_server.Middleware{}
+	for name, defaultLimit := range rateLimits {
+		limit, err := go_http_server.GetRateLimitFromEnv(name, defaultLimit)
+		if err != nil {
+			l.Fatal("💥💥 error doing go_http_server.GetRateLimitFromEnv got error: %v'\n", err)
+		}
+		if limit.Enabled() && len(trustedProxies) == 0 {
+			// behind an ingress every request comes from its ip, all the clients would share one bucket
+			l.Warn("rate limit %s is enabled without TRUSTED_PROXIES, the clients behind a reverse proxy share the quota of its ip", name)
+		}
+		limiters[name] = go_http_server.NewRateLimiter(name, limit, apiKeys).Middleware()
+	}
+
 	l.Info("'Will start HTTP server listening on port %s'", listenAddr)
 	server := go_http_server.NewHttpServer(listenAddr, l)
-	err = server.StartServer()
+	server.SetShutdownConfig(shutdownConfig)
+	// the hooks run in the reverse order, the logs are flushed last
+	server.OnShutdown("logs", flushLogs(l))
+	server.OnShutdown("database", func(context.Context) error {
+		db.Close()
+		return nil
+	})
+	if tlsConfig != nil {
+		if err := server.EnableTLS(tlsConfig); err != nil {
+			l.Fatal("💥💥 error doing server.EnableTLS got error: %v'\n", err)
+		}
+	}
+	server.Use(go_http_server.RequestTracing(l, trustedProxies), go_http_server.Metrics(), go_http_server.Cors(corsConfig))
+	metrics.Default.AddCollector(database.CollectMetrics(db))
+	metrics.Default.AddCollector(tileServer.CollectMetrics)
+	server.Handle("GET /metrics", metrics.Default.Handler()).Describe(metricsOperation)
+	jwtAuth := auth.NewService(authConfig, version.APP, l)
+	requireToken := go_http_server.Middleware(jwtAuth.Middleware())
+	server.ProtectInfo(requireToken)
+	server.AddChecker("database", database.GetDbCheck(db))
+	server.AddChecker("search_index", database.GetSearchIndexCheck(db))
+	server.SetOpenAPIDescription("Search and vector tiles of the geographic datasets, OGC API - Features under /ogc")
+	server.AddSecurityScheme(bearerAuth, bearerSecurity)
+	ogc := features.NewService(db, "/ogc", version.APP, "OGC API - Features of the geo search datasets", l)
+	ogc.ServiceDesc, ogc.ServiceDoc = go_http_server.OpenAPIPath, go_http_server.OpenAPIViewPath
+	server.Handle("POST /login", jwtAuth.GetLoginHandler(), limiters["login"]).Describe(loginOperation)
+	api := server.Group("/api", limiters["api"])
+	api.Handle("POST /auth/refresh", jwtAuth.GetRefreshHandler(), requireToken).Describe(refreshOperation)
+	api.Handle("GET /auth/me", jwtAuth.GetMeHandler(), requireToken).Describe(meOperation)
+	api.Handle("GET /datasets", dataset.GetDatasetsHandler(db, l)).Describe(datasetsOperation)
+	api.Handle("GET /datasets/{id}", dataset.GetDatasetsHandler(db, l)).Describe(datasetOperation)
+	api.Handle("POST /spatial-query", ogc.PostSpatialQueryHandler(features.DefaultSpatialQueryLayers)).Describe(spatialQueryOperation)
+	api.Handle("GET /nearest/{id}", ogc.GetNearestHandler()).Describe(nearestOperation)
+	server.Handle("GET /ogc", ogc.GetLandingPageHandler(), limiters["api"]).
+		Describe(ogcOperation("getLandingPage", "The landing page of the OGC API - Features"))
+	ogcRoutes := server.Group("/ogc", limiters["api"])
+	ogcRoutes.Handle("GET /conformance", ogc.GetConformanceHandler()).
+		Describe(ogcOperation("getConformance", "The OGC conformance classes implemented by the server"))
+	ogcRoutes.Handle("GET /collections", ogc.GetCollectionsHandler()).
+		Describe(ogcOperation("getCollections", "The feature collections"))
+	ogcRoutes.Handle("GET /collections/{id}", ogc.GetCollectionHandler()).
+		Describe(ogcOperation("getCollection", "One feature collection", collectionParameter))
+	ogcRoutes.Handle("GET /collections/{id}/queryables", ogc.GetQueryablesHandler()).Describe(queryablesOperation)
+	ogcRoutes.Handle("GET /collections/{id}/items", ogc.GetItemsHandler()).Describe(itemsOperation)
+	ogcRoutes.Handle("GET /collections/{id}/items/{featureId}", ogc.GetItemHandler()).Describe(itemOperation)
+	server.Handle("GET /tiles", tileServer.GetTilesInfoHandler(), limiters["tiles"]).Describe(tilesInfoOperation)
+	tilesRoutes := server.Group("/tiles", limiters["tiles"])
+	tilesRoutes.Handle("GET /{layer}/{z}/{x}/{y}", tileServer.GetTileHandler()).
+		Describe(tileOperation("getTile", "A vector tile of the LV95 tile matrix set"))
+	tilesRoutes.Handle("GET /{tms}/{layer}/{z}/{x}/{y}", tileServer.GetTileHandler()).
+		Describe(tileOperation("getTileOfMatrixSet", "A vector tile of a tile matrix set",
+			parameter{Name: "tms", In: "path", Required: true, Description: "the id of the tile matrix set", Schema: stringSchema}))
+	server.Handle("GET /config.json", frontconfig.GetConfigHandler(frontConfig, l)).Describe(frontConfigOperation)
+	server.Handle("GET /", staticFiles).Describe(staticFilesOperation)
+	// wait for SIGINT (interrupt) 	: ctrl + C keypress, or SIGTERM sent by kubernetes before deleting the pod
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err = server.Run(ctx)
 	if err != nil {
-		l.Fatal("💥💥 error doing server.StartServer() got error: %v'\n", err)
+		l.Fatal("💥💥 error doing server.Run() got error: %v'\n", err)
+	}
+}
+
+// flushLogs writes the buffered entries of the loggers having a Sync method, like the zap one,
+// the sync of a terminal stderr is not supported by linux and is ignored
+func flushLogs(l golog.MyLogger) go_http_server.ShutdownHook {
+	return func(context.Context) error {
+		syncer, ok := l.(interface{ Sync() error })
+		if !ok {
+			return nil
+		}
+		if err := syncer.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) && !errors.Is(err, syscall.ENOTTY) {
+			return err
+		}
+		return nil
 	}
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"fmt"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/features"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/go-http-server"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/tiles"
+)
+
+// the descriptions of the routes published in /openapi.json, their query parameters are checked against them
+
+const (
+	bearerAuth = "bearerAuth"
+	mimeJSON   = "application/json"
+	mimeText   = "text/plain"
+)
+
+type (
+	operation = go_http_server.Operation
+	parameter = go_http_server.Parameter
+	schema    = go_http_server.Schema
+	response  = go_http_server.Response
+	mediaType = go_http_server.MediaType
+)
+
+var (
+	stringSchema    = &schema{Type: "string"}
+	objectSchema    = &schema{Type: "object"}
+	exceptionSchema = &schema{Ref: "#/components/schemas/Exception"}
+	requireBearer   = []map[string][]string{{bearerAuth: {}}}
+)
+
+// bearerSecurity is the scheme of the tokens returned by POST /login
+var bearerSecurity = go_http_server.SecurityScheme{
+	Type: "http", Scheme: "bearer", BearerFormat: "JWT",
+	Description: "the token returned by POST /login for the admin user",
+}
+
+func jsonResponse(description string, s *schema) response {
+	return response{Description: description, Content: map[string]mediaType{mimeJSON: {Schema: s}}}
+}
+
+func errorResponse(description string) response {
+	return jsonResponse(description, exceptionSchema)
+}
+
+func queryParameter(name, description string, s *schema) parameter {
+	return parameter{Name: name, In: "query", Description: description, Schema: s}
+}
+
+var (
+	jsonFormat    = go_http_server.FormatParameter(go_http_server.JSONFormats...)
+	geoJSONFormat = go_http_server.FormatParameter(go_http_server.GeoJSONFormats...)
+)
+
+// jsonContent and geoJSONContent are the bodies of the routes rendered in the format negotiated with the client
+func jsonContent(s *schema) map[string]mediaType {
+	return go_http_server.RenderedContent(s, go_http_server.JSONFormats...)
+}
+
+func geoJSONContent(s *schema) map[string]mediaType {
+	return go_http_server.RenderedContent(s, go_http_server.GeoJSONFormats...)
+}
+
+// crsParameter is the crs parameter of the OGC API features part 2
+var crsParameter = queryParameter("crs", "the reference system of the returned geometries, "+features.CrsCRS84+" by default", stringSchema)
+
+var tokenSchema = &schema{
+	Type:     "object",
+	Required: []string{"token", "token_type", "expires_in", "expires_at"},
+	Properties: map[string]*schema{
+		"token":      {Type: "string"},
+		"token_type": {Type: "string", Enum: []string{"Bearer"}},
+		"expires_in": {Type: "integer", Description: "seconds"},
+		"expires_at": {Type: "string", Format: "date-time"},
+	},
+}
+
+var credentialsSchema = &schema{
+	Type:     "object",
+	Required: []string{"username", "password"},
+	Properties: map[string]*schema{
+		"username": {Type: "string"},
+		"password": {Type: "string", Format: "password"},
+	},
+}
+
+var loginOperation = operation{
+	Tags: []string{"auth"}, OperationID: "login",
+	Summary: "Get a token for the admin user",
+	RequestBody: &go_http_server.RequestBody{Required: true, Content: map[string]mediaType{
+		mimeJSON:                            {Schema: credentialsSchema},
+		"application/x-www-form-urlencoded": {Schema: credentialsSchema},
+	}},
+	Responses: map[string]response{
+		"200": jsonResponse("a token to send in the Authorization header", tokenSchema),
+		"400": errorResponse("the username or the password is missing"),
+		"401": errorResponse("invalid credentials"),
+		"429": {Description: "too many login attempts"},
+	},
+}
+
+var refreshOperation = operation{
+	Tags: []string{"auth"}, OperationID: "refreshToken", Security: requireBearer,
+	Summary: "Get a new token in exchange for a still valid one, until JWT_MAX_SESSION_HOURS after the login",
+	Responses: map[string]response{
+		"200": jsonResponse("a new token, with the auth_time of the login", tokenSchema),
+		"401": errorResponse("missing, invalid or expired token, or session older than JWT_MAX_SESSION_HOURS"),
+	},
+}
+
+var meOperation = operation{
+	Tags: []string{"auth"}, OperationID: "getMe", Security: requireBearer,
+	Summary:   "The claims of the token of the request",
+	Responses: map[string]response{"200": jsonResponse("the claims of the token", objectSchema), "401": errorResponse("missing, invalid or expired token")},
+}
+
+var datasetFormatParameter = queryParameter("format", "table for a plain text table instead of JSON",
+	&schema{Type: "string", Enum: []string{dataset.FormatTable}})
+
+var datasetsOperation = operation{
+	Tags: []string{"datasets"}, OperationID: "getDatasets",
+	Summary:    "The geometry tables of the database",
+	Parameters: []parameter{datasetFormatParameter, jsonFormat},
+	Responses: map[string]response{"200": {Description: "the datasets",
+		Content: jsonContent(&schema{Type: "array", Items: objectSchema})}},
+}
+
+var datasetOperation = operation{
+	Tags: []string{"datasets"}, OperationID: "getDataset",
+	Summary: "One geometry table of the database",
+	Parameters: []parameter{
+		{Name: "id", In: "path", Required: true, Description: "the schema and the name of the table", Schema: stringSchema},
+		datasetFormatParameter,
+		jsonFormat,
+	},
+	Responses: map[string]response{"200": {Description: "the dataset", Content: jsonContent(objectSchema)}, "404": {Description: "unknown dataset"}},
+}
+
+var spatialQueryOperation = operation{
+	Tags: []string{"search"}, OperationID: "spatialQuery",
+	Summary:    "The features of a layer matching a spatial predicate with a GeoJSON geometry",
+	Parameters: []parameter{geoJSONFormat},
+	RequestBody: &go_http_server.RequestBody{Required: true, Content: map[string]mediaType{mimeJSON: {Schema: &schema{
+		Type:     "object",
+		Required: []string{"layer", "predicate", "geometry"},
+		Properties: map[string]*schema{
+			"layer":         {Type: "string", Enum: features.DefaultSpatialQueryLayers},
+			"predicate":     {Type: "string", Enum: []string{"intersects", "within", "dwithin"}},
+			"distance":      {Type: "number", Description: "meters, only for dwithin", Minimum: go_http_server.Float64(0)},
+			"geometry":      {Type: "object", Description: "a GeoJSON geometry"},
+			"crs":           {Type: "string", Description: "of the geometry and of the returned features, " + features.CrsCRS84 + " by default"},
+			"count_only":    {Type: "boolean"},
+			"count_matched": {Type: "boolean", Description: "adds numberMatched, the count of all the matching features"},
+			"limit":         {Type: "integer", Minimum: go_http_server.Float64(1), Default: features.DefaultSpatialQueryLimit},
+			"offset":        {Type: "integer", Minimum: go_http_server.Float64(0)},
+		},
+	}}}},
+	Responses: map[string]response{
+		"200": {Description: "the matching features, with truncated true when more follow after offset+limit, or only their number in JSON with count_only", Content: geoJSONContent(objectSchema)},
+		"400": errorResponse("invalid spatial query"),
+	},
+}
+
+var nearestOperation = operation{
+	Tags: []string{"search"}, OperationID: "nearest",
+	Summary: "The features of a collection nearest to a point, sorted by distance",
+	Parameters: []parameter{
+		{Name: "id", In: "path", Required: true, Description: "the id of the collection", Schema: stringSchema},
+		{Name: "x", In: "query", Required: true, Description: "the first coordinate of the point, in crs", Schema: &schema{Type: "number"}},
+		{Name: "y", In: "query", Required: true, Description: "the second coordinate of the point, in crs", Schema: &schema{Type: "number"}},
+		queryParameter("k", fmt.Sprintf("the number of features, larger values than %d are reduced to it", features.MaxNearestCount),
+			&schema{Type: "integer", Minimum: go_http_server.Float64(1)}),
+		queryParameter("max_distance", "the maximum distance in meters", &schema{Type: "number", Minimum: go_http_server.Float64(0)}),
+		crsParameter,
+		geoJSONFormat,
+	},
+	Responses: map[string]response{"200": {Description: "the nearest features", Content: geoJSONContent(objectSchema)}},
+}
+
+// ogcOperation describes an OGC route answering JSON, the ones with the collection parameter can answer 404
+func ogcOperation(id, summary string, parameters ...parameter) operation {
+	op := operation{
+		Tags: []string{"ogc"}, OperationID: id, Summary: summary,
+		Parameters: append(parameters, jsonFormat),
+		Responses:  map[string]response{"200": {Description: summary, Content: jsonContent(objectSchema)}},
+	}
+	if len(parameters) > 0 {
+		op.Responses["404"] = errorResponse("unknown collection")
+	}
+	return op
+}
+
+var collectionParameter = parameter{Name: "id", In: "path", Required: true, Description: "the id of the collection", Schema: stringSchema}
+
+var queryablesOperation = operation{
+	Tags: []string{"ogc"}, OperationID: "getQueryables",
+	Summary:    "The properties of a collection usable in the filters",
+	Parameters: []parameter{collectionParameter, go_http_server.FormatParameter(go_http_server.FormatJSON)},
+	Responses: map[string]response{
+		"200": {Description: "the JSON schema of the queryables", Content: map[string]mediaType{features.MIMESchemaJSON: {Schema: objectSchema}}},
+		"404": errorResponse("unknown collection"),
+	},
+}
+
+var itemsOperation = operation{
+	Tags: []string{"ogc"}, OperationID: "getItems",
+	Summary: "The features of a collection",
+	Parameters: []parameter{
+		collectionParameter,
+		queryParameter("limit", "the maximum number of features, larger values are reduced to the maximum",
+			&schema{Type: "integer", Minimum: go_http_server.Float64(1), Default: features.DefaultLimit}),
+		queryParameter("offset", "the number of features to skip", &schema{Type: "integer", Minimum: go_http_server.Float64(0)}),
+		queryParameter("count", "true adds numberMatched, the count of all the matching features, slower on large collections",
+			&schema{Type: "boolean", Default: false}),
+		queryParameter("bbox", "minx,miny,maxx,maxy of the features, in bbox-crs",
+			&schema{Type: "array", MinItems: 4, MaxItems: 6, Items: &schema{Type: "number"}}),
+		queryParameter("bbox-crs", "the reference system of the bbox, "+features.CrsCRS84+" by default", stringSchema),
+		crsParameter,
+		queryParameter("datetime", "an instant or an interval like 2024-01-01/.. of the temporal property", stringSchema),
+		queryParameter("filter", "a CQL2 text filter on the queryables", stringSchema),
+		queryParameter("filter-lang", "the language of the filter", &schema{Type: "string", Enum: []string{"cql2-text"}}),
+		queryParameter("filter-crs", "the reference system of the geometries of the filter", stringSchema),
+		geoJSONFormat,
+		{Name: "queryables", In: "query", Description: "any queryable of the collection, like name=Lausanne",
+			Schema: &schema{Type: "object", AdditionalProperties: stringSchema}},
+	},
+	Responses: map[string]response{
+		"200": {Description: "a page of features", Content: geoJSONContent(objectSchema)},
+		"400": errorResponse("invalid query parameters or filter"),
+		"404": errorResponse("unknown collection"),
+	},
+}
+
+var itemOperation = operation{
+	Tags: []string{"ogc"}, OperationID: "getItem",
+	Summary: "One feature of a collection",
+	Parameters: []parameter{
+		collectionParameter,
+		{Name: "featureId", In: "path", Required: true, Schema: stringSchema},
+		crsParameter,
+		geoJSONFormat,
+	},
+	Responses: map[string]response{
+		"200": {Description: "the feature", Content: geoJSONContent(objectSchema)},
+		"404": errorResponse("unknown collection or feature"),
+	},
+}
+
+var tilesInfoOperation = operation{
+	Tags: []string{"tiles"}, OperationID: "getTilesInfo",
+	Summary:   "The layers, the tile matrix sets and the url templates of the vector tiles",
+	Responses: map[string]response{"200": jsonResponse("the tiles information", objectSchema)},
+}
+
+func tileOperation(id, summary string, parameters ...parameter) operation {
+	return operation{
+		Tags: []string{"tiles"}, OperationID: id, Summary: summary,
+		Parameters: append(parameters,
+			parameter{Name: "layer", In: "path", Required: true, Schema: stringSchema},
+			parameter{Name: "z", In: "path", Required: true, Schema: &schema{Type: "integer"}},
+			parameter{Name: "x", In: "path", Required: true, Schema: &schema{Type: "integer"}},
+			parameter{Name: "y", In: "path", Required: true, Description: "the row followed by the .mvt extension", Schema: stringSchema},
+		),
+		Responses: map[string]response{
+			"200": {Description: "the Mapbox vector tile", Content: map[string]mediaType{tiles.MIMEMapboxVectorTile: {}}},
+			"204": {Description: "an empty tile"},
+			"304": {Description: "the tile did not change since the ETag given in If-None-Match"},
+			"404": {Description: "unknown layer or tile out of range"},
+		},
+	}
+}
+
+var metricsOperation = operation{
+	Tags: []string{"server"}, OperationID: "getMetrics",
+	Summary:   "The metrics of the server in the Prometheus text format",
+	Responses: map[string]response{"200": {Description: "the metrics", Content: map[string]mediaType{mimeText: {}}}},
+}
+
+var frontConfigOperation = operation{
+	Tags: []string{"front"}, OperationID: "getFrontConfig",
+	Summary:   "The runtime configuration of the front-end",
+	Responses: map[string]response{"200": jsonResponse("the configuration", objectSchema)},
+}
+
+var staticFilesOperation = operation{
+	Tags: []string{"front"}, OperationID: "getStaticFile",
+	Summary: "The files of the front-end, index.html for the paths of the single page application",
+	// the browsers can add any query parameter, like a cache buster
+	Parameters: []parameter{{Name: "any", In: "query", Schema: &schema{Type: "object", AdditionalProperties: stringSchema}}},
+	Responses:  map[string]response{"200": {Description: "the file", Content: map[string]mediaType{"text/html": {}}}},
+}
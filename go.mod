@@ -6,6 +6,8 @@ require (
 	github.com/jackc/pgx/v5 v5.5.5
 	github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs v0.2.1
 	github.com/mattn/go-sqlite3 v1.14.22
+	github.com/rs/xid v1.5.0
+	golang.org/x/crypto v0.21.0
 )
 
 require (
@@ -15,8 +17,6 @@ require (
 	github.com/mattn/go-colorable v0.1.13 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mgutz/ansi v0.0.0-20200706080929-d51e80ef957d // indirect
-	github.com/rs/xid v1.5.0 // indirect
-	golang.org/x/crypto v0.21.0 // indirect
 	golang.org/x/sync v0.6.0 // indirect
 	golang.org/x/sys v0.18.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
@@ -1,143 +1,222 @@
 package main
 
 import (
-	"bytes"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/export"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/version"
-	"html/template"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 )
 
-// a simple http server using go Mux from net/http package
+const geopackageFilePath = "geodata/search.sqlite3"
 
-const (
-	defaultPort           = 8099
-	charsetUTF8           = "charset=UTF-8"
-	MIMEHtml              = "text/html"
-	MIMEHtmlCharsetUTF8   = MIMEHtml + "; " + charsetUTF8
-	geopackageFilePath    = "geodata/search.sqlite3"
-	sqlListTables         = "SELECT name FROM sqlite_master WHERE type='table' AND name = 'gpkg_geometry_columns';"
-	sqlListGeometryTables = "SELECT table_name FROM gpkg_geometry_columns;"
-)
+func usage() {
+	fmt.Fprintf(os.Stderr, `%s version %s
 
-// getHelloMsg returns a string with a personalised greeting
-func getHelloMsg(username string) (string, error) {
-	const helloMsg = `
-<!DOCTYPE html>
-<html lang="en">
-  <head>
-    <meta charset="utf-8">
-	<link rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/skeleton/2.0.4/skeleton.min.css" integrity="sha512-EZLkOqwILORob+p0BXZc+Vm3RgJBOe1Iq/0fiI7r/wJgzOFZMlsqTa29UEl6v6U6gsV4uIpsNZoV32YZqrCRCQ==" crossorigin="anonymous" referrerpolicy="no-referrer" />
-    <title>Hello {{.UserName}}</title>
-  </head>
-  <body>
-	<h3>Hello, {{.UserName}}!</h3>
-  </body>
-</html>
-`
-	data := struct {
-		UserName string
-	}{UserName: username}
-	tpl := new(bytes.Buffer)
-	t, err := template.New("hello-page").Parse(helloMsg)
-	if err != nil {
-		return "", err
-	}
-	if err := t.Execute(tpl, data); err != nil {
-		return "", err
+usage: %s <command> [options]
+
+commands:
+  inspect [-format table|json] [-env] [geopackage]
+        list the geometry tables with their srs, geometry type, extent, feature count and columns.
+        by default the GeoPackage %s is inspected, with -env the database defined by the DB_* env variables
+  export-gpkg [-tables search_item,adresses,communes] [-force] [geopackage]
+        copy the tables from the PostGIS database defined by the DB_* env variables to a new GeoPackage,
+        with their spatial indexes and the full text index of search_item, by default in %s
+`, version.APP, version.VERSION, os.Args[0], geopackageFilePath, geopackageFilePath)
+}
+
+// openDB opens the database given by the DB_* env variables when useEnv is true, or the GeoPackage at path
+func openDB(useEnv bool, path string, l golog.MyLogger) (database.DB, error) {
+	if useEnv {
+		dbConfig, err := database.GetConfigFromEnv(runtime.NumCPU())
+		if err != nil {
+			return nil, err
+		}
+		return database.GetInstanceFromConfig(dbConfig, l)
 	}
-	return tpl.String(), nil
+	opt := database.DefaultSqliteOptions()
+	opt.ReadOnly = true
+	return database.NewSqlite3DBWithOptions(path, opt, l)
 }
 
-func main() {
-	prefix := fmt.Sprintf("%s ", version.APP)
-	l, err := golog.NewLogger("zap", golog.DebugLevel, prefix)
-	if err != nil {
-		log.Fatalf("💥 ERROR: 'calling NewLogger()': %v", err)
+// runInspect implements the inspect command
+func runInspect(args []string, l golog.MyLogger) error {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	format := flags.String("format", dataset.FormatTable, "output format : table or json")
+	useEnv := flags.Bool("env", false, "inspect the database defined by the DB_* env variables instead of a GeoPackage")
+	flags.Usage = usage
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := geopackageFilePath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
 	}
-	l.Info("Starting %s version :%s", version.APP, version.VERSION)
 
-	db, err := database.GetInstance("sqlite3", geopackageFilePath, runtime.NumCPU(), l)
+	db, err := openDB(*useEnv, path, l)
 	if err != nil {
-		l.Fatal("💥💥 error doing database.GetInstance(sqlite ...) error: %v", err)
+		return fmt.Errorf("error opening database : %w", err)
 	}
 	defer db.Close()
 
-	l.Info("SUCCESS calling GetInstance(\"sqlite3\",%s)", geopackageFilePath)
-	//db.ExecActionQuery("SELECT InitSpatialMetaData();")
-	isItGeoPackage := db.IsItSpatial()
-	if !isItGeoPackage {
-		l.Fatal("💥 ERROR: 'IsItSpatial()': %v", err)
+	if !db.IsItSpatial() {
+		return errors.New("this database has no geometry tables metadata (gpkg_geometry_columns or postgis)")
 	}
-	spatialiteVersion, err := db.GetSpatialVersion()
-	if err != nil {
-		l.Fatal("💥 ERROR: 'calling GetSpatialVersion()': %v", err)
+	if spatialVersion, err := db.GetSpatialVersion(); err == nil {
+		l.Info("Spatial extension version : %s", spatialVersion)
+	}
+	if db.Dialect() == database.DialectSqlite {
+		if geosVersion, err := db.GetQueryString("SELECT geos_version();"); err == nil {
+			l.Info("geosVersion version : %s", geosVersion)
+		}
+		if hasGeoPackageExtension, err := db.GetQueryBool("SELECT HasGeoPackage();"); err == nil {
+			l.Info("Is GeoPackage extension present : %v", hasGeoPackageExtension)
+		}
 	}
-	l.Info("Spatialite version : %s", spatialiteVersion)
 
-	geosVersion, err := db.GetQueryString("SELECT geos_version();")
+	datasets, err := dataset.GetDatasets(context.Background(), db)
 	if err != nil {
-		l.Fatal("💥 ERROR: 'calling GetQueryString(SELECT geos_version)': %v", err)
+		return fmt.Errorf("error listing geometry tables : %w", err)
 	}
-	l.Info("geosVersion version : %s", geosVersion)
+	return dataset.Write(os.Stdout, datasets, *format)
+}
 
-	hasGeoPackageExtension, err := db.GetQueryBool("SELECT HasGeoPackage();")
-	if err != nil {
-		l.Fatal("💥 ERROR: 'calling GetQueryBool(SELECT HasGeoPackage())': %v", err)
+// runExportGpkg implements the export-gpkg command
+func runExportGpkg(args []string, l golog.MyLogger) error {
+	flags := flag.NewFlagSet("export-gpkg", flag.ExitOnError)
+	var defaultTables []string
+	for _, t := range export.DefaultTables {
+		defaultTables = append(defaultTables, t.Name)
+	}
+	tableList := flags.String("tables", strings.Join(defaultTables, ","), "comma separated list of the tables to export")
+	force := flags.Bool("force", false, "overwrite the GeoPackage if it already exists")
+	flags.Usage = usage
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	path := geopackageFilePath
+	if flags.NArg() > 0 {
+		path = flags.Arg(0)
+	}
+	var tables []export.Table
+	for _, name := range strings.Split(*tableList, ",") {
+		name = strings.TrimSpace(name)
+		t := export.Table{Name: name}
+		for _, known := range export.DefaultTables {
+			if known.Name == name {
+				t = known
+			}
+		}
+		if name != "" {
+			tables = append(tables, t)
+		}
 	}
-	l.Info("Is GeoPackage extension present : %v", hasGeoPackageExtension)
 
-	tables, err := db.GetQueryStringArr(sqlListGeometryTables)
-	l.Info("Listing Geometry tables :")
+	if _, err := os.Stat(path); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", path)
+	}
+	src, err := openDB(true, "", l)
 	if err != nil {
-		l.Fatal("💥 ERROR: 'calling GetQueryStringArr(%s)': %v", sqlListGeometryTables, err)
+		return fmt.Errorf("error opening source database : %w", err)
 	}
-	for _, table := range tables {
-		l.Info("Table : %s", table)
+	defer src.Close()
+	pgxSrc, isPgx := src.(*database.PgxDB)
+	if !isPgx {
+		return errors.New("export-gpkg needs a postgres source database, check DB_DRIVER")
 	}
 
-	os.Exit(0)
-	/*
-
-		listenAddr, err := GetPortFromEnv(defaultPort)
-			if err != nil {
-				log.Fatalf("💥 ERROR: 'calling GetPortFromEnv()': %v", err)
-			}
-
-			mux := http.NewServeMux()
-		mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
-			defaultResponse := fmt.Sprintf("Hello from %s v%s", APP, VERSION)
+	// the GeoPackage is written next to path and only replaces it once complete, a failed export keeps the previous one
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary GeoPackage : %w", err)
+	}
+	tmpPath := tmp.Name()
+	_ = tmp.Close()
+	// CreateTemp restricts the file to its owner, the GeoPackage is shipped like any data file
+	if err := os.Chmod(tmpPath, 0o644); err != nil {
+		removeSqliteFiles(tmpPath)
+		return err
+	}
+	if err := exportGpkg(pgxSrc, tmpPath, tables, l); err != nil {
+		removeSqliteFiles(tmpPath)
+		return err
+	}
+	// a journal left by the previous file would be applied to the new one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(path + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			removeSqliteFiles(tmpPath)
+			return err
+		}
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		removeSqliteFiles(tmpPath)
+		return fmt.Errorf("error replacing %s : %w", path, err)
+	}
+	l.Info("GeoPackage %s successfully written", path)
+	return nil
+}
 
-			w.Header().Set("Content-Type", MIMEHtmlCharsetUTF8)
-			n, err := fmt.Fprintf(w, defaultResponse)
-			if err != nil {
-				log.Printf("ERROR: 'calling fmt.Fprintf()': %v", err)
-			}
-			log.Printf("INFO: 'calling fmt.Fprintf()': %d bytes written", n)
-		})
-		mux.HandleFunc("GET /hello/{name}", func(w http.ResponseWriter, r *http.Request) {
-			name := r.PathValue("name")
-			helloResponse, err := getHelloMsg(name)
-			if err != nil {
-				log.Printf("ERROR: 'calling getHelloMsg()': %v", err)
-				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
-				return
-			}
+// exportGpkg writes tables from src to a new GeoPackage at path, it is closed when exportGpkg returns
+func exportGpkg(src *database.PgxDB, path string, tables []export.Table, l golog.MyLogger) error {
+	// a single file without wal is easier to ship to the remote sites
+	opt := database.DefaultSqliteOptions()
+	opt.Create = true
+	opt.JournalMode = "DELETE"
+	dst, err := database.NewSqlite3DBWithOptions(path, opt, l)
+	if err != nil {
+		return fmt.Errorf("error creating GeoPackage : %w", err)
+	}
+	defer dst.Close()
 
-			w.Header().Set("Content-Type", MIMEHtmlCharsetUTF8)
-			n, err := fmt.Fprintf(w, helloResponse)
-			if err != nil {
-				log.Printf("ERROR: 'calling fmt.Fprintf()': %v", err)
-			}
-			log.Printf("INFO: 'calling fmt.Fprintf()': %d bytes written", n)
+	if err := export.NewExporter(src, dst.(*database.SQLITE3), l).Run(context.Background(), tables); err != nil {
+		return err
+	}
+	if !dst.IsItSpatial() {
+		return fmt.Errorf("%s was written but is not recognized as a GeoPackage", path)
+	}
+	return nil
+}
 
-		})
+// removeSqliteFiles removes the sqlite3 file at path with its journals
+func removeSqliteFiles(path string) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		_ = os.Remove(path + suffix)
+	}
+}
 
-		log.Printf("Starting %s v%s on %s", APP, VERSION, listenAddr)
-		log.Fatal(http.ListenAndServe(listenAddr, mux))
-	*/
+func main() {
+	prefix := fmt.Sprintf("%s ", version.APP)
+	l, err := golog.NewLogger("zap", golog.DebugLevel, prefix)
+	if err != nil {
+		log.Fatalf("💥 ERROR: 'calling NewLogger()': %v", err)
+	}
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	l.Info("Starting %s version :%s command: %s", version.APP, version.VERSION, os.Args[1])
+
+	switch os.Args[1] {
+	case "inspect":
+		err = runInspect(os.Args[2:], l)
+	case "export-gpkg":
+		err = runExportGpkg(os.Args[2:], l)
+	case "-h", "-help", "--help", "help":
+		usage()
+	default:
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		l.Fatal("💥 ERROR: '%s' failed : %v", os.Args[1], err)
+	}
 }
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	defaultJwtDuration = 60 * time.Minute
+	defaultMaxSession  = 12 * time.Hour
+	minSecretLength    = 32 // HS256 keys should be at least as long as the 256 bits hash
+	envFileSuffix      = "_FILE"
+)
+
+// Config holds the secret used to sign the tokens and the credentials of the admin user
+type Config struct {
+	Secret            []byte
+	Duration          time.Duration // validity of a token
+	MaxSessionAge     time.Duration // time after the login when the tokens can no longer be refreshed
+	AdminUser         string
+	AdminPasswordHash []byte // bcrypt hash, the clear password is never kept
+}
+
+// GetConfigFromEnv builds the authentication Config from JWT_SECRET, JWT_DURATION_MINUTES, JWT_MAX_SESSION_HOURS,
+// ADMIN_USER and ADMIN_PASSWORD, or ADMIN_PASSWORD_HASH with a bcrypt hash (htpasswd -bnBC 12 "" password | tr -d ':\n').
+// Like the DB_* variables they can be read from a file by appending _FILE to their name.
+func GetConfigFromEnv() (*Config, error) {
+	var errs []error
+	getString := func(name string) string {
+		val, err := getEnvOrFile(name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return val
+	}
+	cfg := &Config{
+		Secret:        []byte(getString("JWT_SECRET")),
+		Duration:      defaultJwtDuration,
+		MaxSessionAge: defaultMaxSession,
+		AdminUser:     getString("ADMIN_USER"),
+	}
+	password, passwordHash := getString("ADMIN_PASSWORD"), getString("ADMIN_PASSWORD_HASH")
+	if val := getString("JWT_DURATION_MINUTES"); val != "" {
+		minutes, err := strconv.Atoi(val)
+		if err != nil || minutes <= 0 {
+			errs = append(errs, fmt.Errorf("env JWT_DURATION_MINUTES should be a positive number of minutes, got '%s'", val))
+		}
+		cfg.Duration = time.Duration(minutes) * time.Minute
+	}
+	if val := getString("JWT_MAX_SESSION_HOURS"); val != "" {
+		hours, err := strconv.Atoi(val)
+		if err != nil || hours <= 0 {
+			errs = append(errs, fmt.Errorf("env JWT_MAX_SESSION_HOURS should be a positive number of hours, got '%s'", val))
+		}
+		cfg.MaxSessionAge = time.Duration(hours) * time.Hour
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if len(cfg.Secret) < minSecretLength {
+		return nil, fmt.Errorf("env JWT_SECRET should be at least %d characters long", minSecretLength)
+	}
+	if cfg.MaxSessionAge < cfg.Duration {
+		return nil, fmt.Errorf("env JWT_MAX_SESSION_HOURS (%s) should not be shorter than JWT_DURATION_MINUTES (%s)", cfg.MaxSessionAge, cfg.Duration)
+	}
+	if cfg.AdminUser == "" {
+		return nil, errors.New("env ADMIN_USER is required")
+	}
+	switch {
+	case password != "" && passwordHash != "":
+		return nil, errors.New("env ADMIN_PASSWORD and ADMIN_PASSWORD_HASH are mutually exclusive")
+	case passwordHash != "":
+		if _, err := bcrypt.Cost([]byte(passwordHash)); err != nil {
+			return nil, fmt.Errorf("env ADMIN_PASSWORD_HASH should be a bcrypt hash : %w", err)
+		}
+		cfg.AdminPasswordHash = []byte(passwordHash)
+	case password != "":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return nil, fmt.Errorf("error hashing ADMIN_PASSWORD : %w", err)
+		}
+		cfg.AdminPasswordHash = hash
+	default:
+		return nil, errors.New("env ADMIN_PASSWORD or ADMIN_PASSWORD_HASH is required")
+	}
+	return cfg, nil
+}
+
+// getEnvOrFile returns the value of the env variable name, or the trimmed content of the file referenced by name_FILE
+func getEnvOrFile(name string) (string, error) {
+	if filePath, ok := os.LookupEnv(name + envFileSuffix); ok && filePath != "" {
+		if _, alsoDefined := os.LookupEnv(name); alsoDefined {
+			return "", fmt.Errorf("env %s and %s%s are mutually exclusive", name, name, envFileSuffix)
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", fmt.Errorf("error reading file given in env %s%s : %w", name, envFileSuffix, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	return os.Getenv(name), nil
+}
@@ -0,0 +1,189 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	MIMEJSON        = "application/json"
+	maxLoginBytes   = 4 << 10
+	bearerPrefix    = "Bearer "
+	claimsKey       = contextKey(0)
+	tokenTypeBearer = "Bearer"
+)
+
+type contextKey int
+
+// Service issues and checks the tokens of the users of the server
+type Service struct {
+	cfg    *Config
+	issuer string
+	log    golog.MyLogger
+}
+
+// Credentials is the body of POST /login, in json or as a form
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// TokenResponse is returned by the login and refresh handlers
+type TokenResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int64  `json:"expires_in"` // seconds
+	ExpiresAt string `json:"expires_at"`
+}
+
+type exception struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// NewService returns a Service signing its tokens with the secret of cfg, issuer is the name of the application
+func NewService(cfg *Config, issuer string, l golog.MyLogger) *Service {
+	return &Service{cfg: cfg, issuer: issuer, log: l}
+}
+
+// GetClaims returns the claims of the token accepted by the Middleware for this request
+func GetClaims(ctx context.Context) (*Claims, bool) {
+	claims, ok := ctx.Value(claimsKey).(*Claims)
+	return claims, ok
+}
+
+// checkCredentials compares the user and the password in constant time, bcrypt runs even for an unknown user
+// so the response time does not reveal the valid user names
+func (s *Service) checkCredentials(c Credentials) bool {
+	userOk := subtle.ConstantTimeCompare([]byte(c.Username), []byte(s.cfg.AdminUser)) == 1
+	passwordOk := bcrypt.CompareHashAndPassword(s.cfg.AdminPasswordHash, []byte(c.Password)) == nil
+	return userOk && passwordOk
+}
+
+func (s *Service) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", MIMEJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log.Error("auth failed to write response : %v", err)
+	}
+}
+
+func (s *Service) writeUnauthorized(w http.ResponseWriter, description string) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="`+s.issuer+`"`)
+	s.writeJSON(w, http.StatusUnauthorized, exception{Code: "Unauthorized", Description: description})
+}
+
+func (s *Service) writeToken(w http.ResponseWriter, subject string, isAdmin bool, authTime time.Time) {
+	token, claims, err := s.NewToken(subject, isAdmin, authTime)
+	if errors.Is(err, ErrSessionEnded) {
+		s.log.Info("refresh refused for user %q : %v", subject, err)
+		s.writeUnauthorized(w, err.Error()+", a new login is required")
+		return
+	}
+	if err != nil {
+		s.log.Error("auth failed to create token : %v", err)
+		s.writeJSON(w, http.StatusInternalServerError, exception{Code: "InternalServerError", Description: "token could not be created"})
+		return
+	}
+	s.writeJSON(w, http.StatusOK, TokenResponse{
+		Token:     token,
+		TokenType: tokenTypeBearer,
+		ExpiresIn: claims.ExpiresAt - claims.IssuedAt,
+		ExpiresAt: time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339),
+	})
+}
+
+// readCredentials decodes the json or form body of a login request
+func readCredentials(w http.ResponseWriter, r *http.Request) (Credentials, error) {
+	var c Credentials
+	r.Body = http.MaxBytesReader(w, r.Body, maxLoginBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-www-form-urlencoded", "multipart/form-data":
+		if err := r.ParseForm(); err != nil {
+			return c, err
+		}
+		c.Username, c.Password = r.PostForm.Get("username"), r.PostForm.Get("password")
+	default:
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			return c, err
+		}
+	}
+	if c.Username == "" || c.Password == "" {
+		return c, errors.New("username and password are required")
+	}
+	return c, nil
+}
+
+// GetLoginHandler serves POST /login, returning a token for valid credentials of the admin user
+func (s *Service) GetLoginHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := readCredentials(w, r)
+		if err != nil {
+			s.writeJSON(w, http.StatusBadRequest, exception{Code: "InvalidParameterValue", Description: "invalid login request : " + err.Error()})
+			return
+		}
+		if !s.checkCredentials(c) {
+			s.log.Warn("login failed for user %q", c.Username)
+			s.writeUnauthorized(w, "invalid username or password")
+			return
+		}
+		s.log.Info("login of user %q", c.Username)
+		s.writeToken(w, c.Username, true, time.Now())
+	}
+}
+
+// GetRefreshHandler serves a new token to the bearer of a still valid one, it must be behind the Middleware.
+// The new token keeps the auth_time of the login, so the refreshes stop MaxSessionAge after it.
+func (s *Service) GetRefreshHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaims(r.Context())
+		if !ok {
+			s.writeUnauthorized(w, "a valid token is required")
+			return
+		}
+		s.writeToken(w, claims.Subject, claims.IsAdmin, time.Unix(claims.AuthTime, 0))
+	}
+}
+
+// GetMeHandler returns the claims of the token of the request, it must be behind the Middleware
+func (s *Service) GetMeHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetClaims(r.Context())
+		if !ok {
+			s.writeUnauthorized(w, "a valid token is required")
+			return
+		}
+		s.writeJSON(w, http.StatusOK, claims)
+	}
+}
+
+// Middleware refuses with 401 the requests without a valid "Authorization: Bearer" token,
+// the claims of the token are available to the next handlers with GetClaims
+func (s *Service) Middleware() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authorization := r.Header.Get("Authorization")
+			if len(authorization) < len(bearerPrefix) || !strings.EqualFold(authorization[:len(bearerPrefix)], bearerPrefix) {
+				s.writeUnauthorized(w, "a bearer token is required")
+				return
+			}
+			claims, err := s.ParseToken(strings.TrimSpace(authorization[len(bearerPrefix):]))
+			if err != nil {
+				s.writeUnauthorized(w, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey, claims)))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// refresh sends token to the refresh handler behind the Middleware
+func refresh(s *Service, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/auth/refresh", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	s.Middleware()(s.GetRefreshHandler()).ServeHTTP(w, r)
+	return w
+}
+
+func TestRefreshKeepsAuthTime(t *testing.T) {
+	s := newTestService(t, testIssuer)
+	login := time.Now().Add(-2 * time.Hour)
+	token, _, err := s.NewToken("admin", true, login)
+	if err != nil {
+		t.Fatalf("NewToken failed : %v", err)
+	}
+	// refresh the refreshed token to check that auth_time is not the time of the previous refresh
+	for i := 0; i < 2; i++ {
+		w := refresh(s, token)
+		if w.Code != http.StatusOK {
+			t.Fatalf("refresh %d returned %d : %s", i, w.Code, w.Body)
+		}
+		var res TokenResponse
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("decoding the refresh response failed : %v", err)
+		}
+		claims, err := s.ParseToken(res.Token)
+		if err != nil {
+			t.Fatalf("ParseToken of the refreshed token failed : %v", err)
+		}
+		if claims.AuthTime != login.Unix() || !claims.IsAdmin || claims.Subject != "admin" {
+			t.Errorf("refreshed claims = %+v, want the subject, the admin flag and the auth_time %d of the login", claims, login.Unix())
+		}
+		token = res.Token
+	}
+}
+
+func TestRefreshAfterMaxSessionAge(t *testing.T) {
+	s := newTestService(t, testIssuer)
+	claims := validClaims()
+	claims.AuthTime = time.Now().Add(-s.cfg.MaxSessionAge - time.Minute).Unix()
+	w := refresh(s, signedToken(t, s, jwtHeader, claims))
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), ErrSessionEnded.Error()) {
+		t.Errorf("refresh of a session older than %s returned %d : %s, want 401", s.cfg.MaxSessionAge, w.Code, w.Body)
+	}
+
+	// the tokens issued before auth_time existed cannot be refreshed either
+	claims.AuthTime = 0
+	if w := refresh(s, signedToken(t, s, jwtHeader, claims)); w.Code != http.StatusUnauthorized {
+		t.Errorf("refresh of a token without auth_time returned %d : %s, want 401", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/xid"
+)
+
+// jwtHeader is the only header accepted, a token with another algorithm, like none, is refused
+const jwtHeader = `{"alg":"HS256","typ":"JWT"}`
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("token has expired")
+	ErrSessionEnded = errors.New("session has ended")
+)
+
+// Claims are the registered JWT claims used by the server, the handlers get them with GetClaims
+type Claims struct {
+	Issuer    string `json:"iss"`
+	Subject   string `json:"sub"`
+	ID        string `json:"jti"`
+	IssuedAt  int64  `json:"iat"`
+	NotBefore int64  `json:"nbf"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time"` // login time, kept by the refreshed tokens
+	IsAdmin   bool   `json:"admin"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// NewToken returns a HS256 token for subject who logged in at authTime, valid during the configured duration
+// but never after the end of the session, MaxSessionAge after authTime
+func (s *Service) NewToken(subject string, isAdmin bool, authTime time.Time) (string, *Claims, error) {
+	now := time.Now()
+	sessionEnd := authTime.Add(s.cfg.MaxSessionAge)
+	if !now.Before(sessionEnd) {
+		return "", nil, fmt.Errorf("%w at %s", ErrSessionEnded, sessionEnd.UTC().Format(time.RFC3339))
+	}
+	expiresAt := now.Add(s.cfg.Duration)
+	if expiresAt.After(sessionEnd) {
+		expiresAt = sessionEnd
+	}
+	claims := &Claims{
+		Issuer:    s.issuer,
+		Subject:   subject,
+		ID:        xid.New().String(),
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+		AuthTime:  authTime.Unix(),
+		IsAdmin:   isAdmin,
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	unsigned := b64.EncodeToString([]byte(jwtHeader)) + "." + b64.EncodeToString(payload)
+	return unsigned + "." + b64.EncodeToString(s.sign(unsigned)), claims, nil
+}
+
+func (s *Service) sign(unsigned string) []byte {
+	mac := hmac.New(sha256.New, s.cfg.Secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
+
+// ParseToken checks the signature and the validity period of token and returns its claims
+func (s *Service) ParseToken(token string) (*Claims, error) {
+	header, rest, found := strings.Cut(token, ".")
+	if !found {
+		return nil, ErrInvalidToken
+	}
+	payload, signature, found := strings.Cut(rest, ".")
+	if !found {
+		return nil, ErrInvalidToken
+	}
+	got, err := b64.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, s.sign(header+"."+payload)) {
+		return nil, ErrInvalidToken
+	}
+	// the signature is good, the header and payload were written by NewToken
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if raw, err := b64.DecodeString(header); err != nil || json.Unmarshal(raw, &h) != nil || h.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	raw, err := b64.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now().Unix()
+	if claims.Issuer != s.issuer || claims.Subject == "" || now < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	if now >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w since %s", ErrExpiredToken, time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339))
+	}
+	return &claims, nil
+}
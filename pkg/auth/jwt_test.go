@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const testIssuer = "goCloudGeoSearch"
+
+func newTestService(t *testing.T, issuer string) *Service {
+	t.Helper()
+	l, err := golog.NewLogger("zap", golog.ErrorLevel, "test ")
+	if err != nil {
+		t.Fatalf("golog.NewLogger failed : %v", err)
+	}
+	cfg := &Config{
+		Secret:        []byte("0123456789abcdef0123456789abcdef"),
+		Duration:      time.Hour,
+		MaxSessionAge: 12 * time.Hour,
+		AdminUser:     "admin",
+	}
+	return NewService(cfg, issuer, l)
+}
+
+// signedToken returns a token with header and claims signed with the secret of s, like an attacker knowing it
+// or NewToken with other claims would write it
+func signedToken(t *testing.T, s *Service, header string, claims Claims) string {
+	t.Helper()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("json.Marshal failed : %v", err)
+	}
+	unsigned := b64.EncodeToString([]byte(header)) + "." + b64.EncodeToString(payload)
+	return unsigned + "." + b64.EncodeToString(s.sign(unsigned))
+}
+
+func validClaims() Claims {
+	now := time.Now().Unix()
+	return Claims{Issuer: testIssuer, Subject: "admin", ID: "test", IssuedAt: now, NotBefore: now, ExpiresAt: now + 60, AuthTime: now}
+}
+
+func TestParseToken(t *testing.T) {
+	s := newTestService(t, testIssuer)
+	token, want, err := s.NewToken("admin", true, time.Now())
+	if err != nil {
+		t.Fatalf("NewToken failed : %v", err)
+	}
+	got, err := s.ParseToken(token)
+	if err != nil {
+		t.Fatalf("ParseToken failed : %v", err)
+	}
+	if *got != *want {
+		t.Errorf("ParseToken = %+v, want %+v", got, want)
+	}
+	if _, err := s.ParseToken(signedToken(t, s, jwtHeader, validClaims())); err != nil {
+		t.Errorf("ParseToken of a signedToken failed : %v", err)
+	}
+}
+
+func TestParseTokenErrors(t *testing.T) {
+	s := newTestService(t, testIssuer)
+	token, _, err := s.NewToken("admin", false, time.Now())
+	if err != nil {
+		t.Fatalf("NewToken failed : %v", err)
+	}
+	header, rest, _ := strings.Cut(token, ".")
+	payload, signature, _ := strings.Cut(rest, ".")
+	// the payload of another user with the signature of the first token
+	adminClaims := validClaims()
+	adminClaims.IsAdmin = true
+	forged, _ := json.Marshal(adminClaims)
+	// the first character of the signature holds its 6 first bits, changing it keeps a valid base64
+	tampered := []byte(signature)
+	if tampered[0] == 'A' {
+		tampered[0] = 'B'
+	} else {
+		tampered[0] = 'A'
+	}
+	otherSecret := newTestService(t, testIssuer)
+	otherSecret.cfg.Secret = []byte("another secret of 32 characters!")
+
+	with := func(change func(c *Claims)) string {
+		c := validClaims()
+		change(&c)
+		return signedToken(t, s, jwtHeader, c)
+	}
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"empty", "", ErrInvalidToken},
+		{"not a jwt", "abc", ErrInvalidToken},
+		{"missing signature", header + "." + payload, ErrInvalidToken},
+		{"tampered signature", header + "." + payload + "." + string(tampered), ErrInvalidToken},
+		{"tampered payload", header + "." + b64.EncodeToString(forged) + "." + signature, ErrInvalidToken},
+		{"signature of another secret", newToken(t, otherSecret), ErrInvalidToken},
+		{"alg none", b64.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + payload + ".", ErrInvalidToken},
+		{"alg HS512 signed with the secret", signedToken(t, s, `{"alg":"HS512","typ":"JWT"}`, validClaims()), ErrInvalidToken},
+		{"wrong issuer", newToken(t, newTestService(t, "another app")), ErrInvalidToken},
+		{"no subject", with(func(c *Claims) { c.Subject = "" }), ErrInvalidToken},
+		{"not yet valid", with(func(c *Claims) { c.NotBefore = time.Now().Add(time.Minute).Unix() }), ErrInvalidToken},
+		{"expired", with(func(c *Claims) { c.ExpiresAt = time.Now().Add(-time.Second).Unix() }), ErrExpiredToken},
+		{"expiring now", with(func(c *Claims) { c.ExpiresAt = time.Now().Unix() }), ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, err := s.ParseToken(tt.token); !errors.Is(err, tt.want) {
+				t.Errorf("ParseToken = %+v, %v, want %v", claims, err, tt.want)
+			}
+		})
+	}
+}
+
+// newToken returns a new token of s for the admin user
+func newToken(t *testing.T, s *Service) string {
+	t.Helper()
+	token, _, err := s.NewToken("admin", true, time.Now())
+	if err != nil {
+		t.Fatalf("NewToken failed : %v", err)
+	}
+	return token
+}
+
+func TestNewTokenSession(t *testing.T) {
+	s := newTestService(t, testIssuer)
+	authTime := time.Now().Add(-11*time.Hour - 30*time.Minute)
+	_, claims, err := s.NewToken("admin", true, authTime)
+	if err != nil {
+		t.Fatalf("NewToken failed : %v", err)
+	}
+	if claims.AuthTime != authTime.Unix() {
+		t.Errorf("auth_time = %d, want %d", claims.AuthTime, authTime.Unix())
+	}
+	// the token would outlive the session by 30 minutes
+	if want := authTime.Add(12 * time.Hour).Unix(); claims.ExpiresAt != want {
+		t.Errorf("exp = %d, want the end of the session %d", claims.ExpiresAt, want)
+	}
+	if _, _, err := s.NewToken("admin", true, time.Now().Add(-12*time.Hour)); !errors.Is(err, ErrSessionEnded) {
+		t.Errorf("NewToken at the end of the session returned %v, want %v", err, ErrSessionEnded)
+	}
+}
@@ -0,0 +1,160 @@
+// Package cql2 parses the text encoding of the OGC Common Query Language (CQL2) and translates the filters
+// to sql conditions for PostGIS or for a GeoPackage opened with SpatiaLite, binding every literal as a query argument.
+package cql2
+
+import (
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+// Expr is a boolean expression of a filter
+type Expr interface {
+	expr()
+}
+
+// Scalar is an operand of the comparison predicates, a Property or a Literal
+type Scalar interface {
+	scalar()
+}
+
+// GeometryOperand is an argument of the spatial functions, a Property or a GeometryLiteral
+type GeometryOperand interface {
+	geometryOperand()
+}
+
+// Logical is the AND or OR of two expressions
+type Logical struct {
+	Op          string // AND or OR
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+// Constant is the TRUE or FALSE filter
+type Constant struct {
+	Value bool
+}
+
+// Comparison is Left Op Right, with Op one of = <> < > <= >=
+type Comparison struct {
+	Op          string
+	Left, Right Scalar
+}
+
+type Like struct {
+	Value, Pattern Scalar
+	Not            bool
+}
+
+type In struct {
+	Value Scalar
+	List  []Scalar
+	Not   bool
+}
+
+type Between struct {
+	Value, Low, High Scalar
+	Not              bool
+}
+
+type IsNull struct {
+	Value Scalar
+	Not   bool
+}
+
+// Spatial is one of the spatial functions S_INTERSECTS, S_WITHIN and S_DWITHIN, the Distance in meters
+// is only used by S_DWITHIN
+type Spatial struct {
+	Op          string
+	Left, Right GeometryOperand
+	Distance    float64
+}
+
+// Property is a column of the filtered table
+type Property struct {
+	Name string
+}
+
+// Literal is a string, a float64 number, a bool or a time.Time date or timestamp
+type Literal struct {
+	Value interface{}
+	Kind  Kind
+}
+
+// GeometryLiteral is a WKT geometry or a BBOX, in the coordinate reference system of the filter
+type GeometryLiteral struct {
+	Geometry geom.Geometry
+}
+
+func (Logical) expr()    {}
+func (Not) expr()        {}
+func (Constant) expr()   {}
+func (Comparison) expr() {}
+func (Like) expr()       {}
+func (In) expr()         {}
+func (Between) expr()    {}
+func (IsNull) expr()     {}
+func (Spatial) expr()    {}
+
+func (Property) scalar() {}
+func (Literal) scalar()  {}
+
+func (Property) geometryOperand()        {}
+func (GeometryLiteral) geometryOperand() {}
+
+// Kind is the type of a literal or of a column as far as the comparisons are concerned
+type Kind int
+
+const (
+	KindAny Kind = iota // a column of a type not known here, it is compared as text
+	KindString
+	KindNumber
+	KindBoolean
+	KindDate
+	KindTimestamp
+	KindGeometry
+)
+
+var kindNames = map[Kind]string{
+	KindAny: "any", KindString: "string", KindNumber: "number", KindBoolean: "boolean",
+	KindDate: "date", KindTimestamp: "timestamp", KindGeometry: "geometry",
+}
+
+func (k Kind) String() string {
+	return kindNames[k]
+}
+
+// KindOfType returns the kind of a postgres or GeoPackage column type, like varchar(20), int4 or DATETIME
+func KindOfType(sqlType string) Kind {
+	t := normalizeType(sqlType)
+	switch t {
+	case "text", "varchar", "character varying", "character", "char", "bpchar", "name", "citext", "clob", "uuid":
+		return KindString
+	case "integer", "int", "int2", "int4", "int8", "smallint", "bigint", "mediumint", "tinyint",
+		"real", "double", "double precision", "float", "float4", "float8", "numeric", "decimal":
+		return KindNumber
+	case "boolean", "bool":
+		return KindBoolean
+	case "date":
+		return KindDate
+	case "timestamp", "timestamptz", "datetime", "timestamp with time zone", "timestamp without time zone":
+		return KindTimestamp
+	case "geometry", "geography", "point", "linestring", "polygon", "multipoint", "multilinestring",
+		"multipolygon", "geometrycollection":
+		return KindGeometry
+	}
+	return KindAny
+}
+
+// timestampFormat is the way the GeoPackage stores a DATETIME, they compare as strings in chronological order
+const timestampFormat = "2006-01-02T15:04:05.000Z"
+
+func formatTemporal(t time.Time, k Kind) string {
+	if k == KindDate {
+		return t.Format(time.DateOnly)
+	}
+	return t.UTC().Format(timestampFormat)
+}
@@ -0,0 +1,555 @@
+package cql2
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+var ErrSyntax = errors.New("cql2 syntax error")
+
+// maxDepth limits the nesting of the expressions so that a crafted filter cannot exhaust the stack
+const maxDepth = 64
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokQuotedIdent
+	tokString
+	tokNumber
+	tokSymbol
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// is tells if the token is the keyword or symbol s, keywords are case-insensitive
+func (t token) is(s string) bool {
+	return (t.kind == tokIdent || t.kind == tokSymbol) && strings.EqualFold(t.text, s)
+}
+
+// wktTypes are the geometry tags starting a WKT literal
+var wktTypes = map[string]bool{
+	"POINT": true, "LINESTRING": true, "POLYGON": true, "MULTIPOINT": true,
+	"MULTILINESTRING": true, "MULTIPOLYGON": true, "GEOMETRYCOLLECTION": true,
+}
+
+// parser reads the tokens on demand, the WKT literals are taken as raw text and given to geom.UnmarshalWKT
+type parser struct {
+	src   string
+	pos   int // position after tok
+	tok   token
+	depth int
+}
+
+// Parse returns the expression of a CQL2 text filter
+func Parse(text string) (Expr, error) {
+	p := &parser{src: text}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+	return e, nil
+}
+
+func (p *parser) errorf(format string, v ...interface{}) error {
+	return fmt.Errorf("%w at position %d: %s", ErrSyntax, p.tok.pos+1, fmt.Sprintf(format, v...))
+}
+
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.src) && unicode.IsSpace(rune(p.src[p.pos])) {
+		p.pos++
+	}
+}
+
+// next reads the token following the current one
+func (p *parser) next() error {
+	p.skipSpaces()
+	start := p.pos
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF, pos: start}
+		return nil
+	}
+	c := p.src[p.pos]
+	switch {
+	case c == '\'' || c == '"':
+		var sb strings.Builder
+		p.pos++
+		for {
+			if p.pos >= len(p.src) {
+				p.tok = token{pos: start}
+				return p.errorf("unterminated %c", c)
+			}
+			if p.src[p.pos] == c {
+				// a doubled quote is the quote itself
+				if p.pos+1 < len(p.src) && p.src[p.pos+1] == c {
+					sb.WriteByte(c)
+					p.pos += 2
+					continue
+				}
+				p.pos++
+				break
+			}
+			sb.WriteByte(p.src[p.pos])
+			p.pos++
+		}
+		kind := tokString
+		if c == '"' {
+			kind = tokQuotedIdent
+		}
+		p.tok = token{kind: kind, text: sb.String(), pos: start}
+	case c >= '0' && c <= '9' || c == '.':
+		for p.pos < len(p.src) && (isDigit(p.src[p.pos]) || p.src[p.pos] == '.' ||
+			p.src[p.pos] == 'e' || p.src[p.pos] == 'E' ||
+			((p.src[p.pos] == '+' || p.src[p.pos] == '-') && (p.src[p.pos-1] == 'e' || p.src[p.pos-1] == 'E'))) {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos], pos: start}
+	case c == '_' || unicode.IsLetter(rune(c)) || c >= 0x80:
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] == ':' || p.src[p.pos] >= 0x80 ||
+			unicode.IsLetter(rune(p.src[p.pos])) || isDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos], pos: start}
+	default:
+		for _, symbol := range []string{"<>", "<=", ">=", "=", "<", ">", "(", ")", ",", "-", "+"} {
+			if strings.HasPrefix(p.src[p.pos:], symbol) {
+				p.pos += len(symbol)
+				p.tok = token{kind: tokSymbol, text: symbol, pos: start}
+				return nil
+			}
+		}
+		p.tok = token{pos: start}
+		return p.errorf("unexpected character %q", c)
+	}
+	return nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (p *parser) expect(s string) error {
+	if !p.tok.is(s) {
+		if p.tok.kind == tokEOF {
+			return p.errorf("expected %s, got end of filter", s)
+		}
+		return p.errorf("expected %s, got %q", s, p.tok.text)
+	}
+	return p.next()
+}
+
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf("the filter is nested more than %d levels", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.is("OR") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.is("AND") {
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = Logical{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (Expr, error) {
+	if p.tok.is("NOT") {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer func() { p.depth-- }()
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: e}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	switch {
+	case p.tok.is("("):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		e, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case p.tok.is("S_INTERSECTS"), p.tok.is("S_WITHIN"), p.tok.is("S_DWITHIN"):
+		return p.parseSpatial()
+	}
+	value, err := p.parseScalar()
+	if err != nil {
+		return nil, err
+	}
+	not := false
+	if p.tok.is("NOT") {
+		not = true
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	switch {
+	case p.tok.is("LIKE"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		pattern, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		return Like{Value: value, Pattern: pattern, Not: not}, nil
+	case p.tok.is("BETWEEN"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		low, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("AND"); err != nil {
+			return nil, err
+		}
+		high, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		return Between{Value: value, Low: low, High: high, Not: not}, nil
+	case p.tok.is("IN"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		in := In{Value: value, Not: not}
+		for {
+			item, err := p.parseScalar()
+			if err != nil {
+				return nil, err
+			}
+			in.List = append(in.List, item)
+			if !p.tok.is(",") {
+				break
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		return in, p.expect(")")
+	case not:
+		return nil, p.errorf("expected LIKE, BETWEEN or IN after NOT")
+	case p.tok.is("IS"):
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		isNull := IsNull{Value: value}
+		if p.tok.is("NOT") {
+			isNull.Not = true
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		return isNull, p.expect("NULL")
+	}
+	for _, op := range []string{"=", "<>", "<", ">", "<=", ">="} {
+		if p.tok.is(op) {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			right, err := p.parseScalar()
+			if err != nil {
+				return nil, err
+			}
+			return Comparison{Op: op, Left: value, Right: right}, nil
+		}
+	}
+	if l, isLiteral := value.(Literal); isLiteral && l.Kind == KindBoolean {
+		return Constant{Value: l.Value.(bool)}, nil
+	}
+	if p.tok.kind == tokEOF {
+		return nil, p.errorf("expected a predicate, got end of filter")
+	}
+	return nil, p.errorf("expected a predicate, got %q", p.tok.text)
+}
+
+// parseScalar reads a property, a string, a number, a boolean or a DATE('...') or TIMESTAMP('...') literal
+func (p *parser) parseScalar() (Scalar, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokString:
+		return Literal{Value: tok.text, Kind: KindString}, p.next()
+	case tokQuotedIdent:
+		return Property{Name: tok.text}, p.next()
+	case tokNumber:
+		return p.parseNumber(1)
+	case tokSymbol:
+		if tok.text == "-" || tok.text == "+" {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			if p.tok.kind != tokNumber {
+				return nil, p.errorf("expected a number after %s", tok.text)
+			}
+			if tok.text == "-" {
+				return p.parseNumber(-1)
+			}
+			return p.parseNumber(1)
+		}
+	case tokIdent:
+		switch {
+		case tok.is("TRUE"), tok.is("FALSE"):
+			return Literal{Value: tok.is("TRUE"), Kind: KindBoolean}, p.next()
+		case tok.is("DATE"), tok.is("TIMESTAMP"):
+			return p.parseTemporal()
+		}
+		if isKeyword(tok.text) {
+			return nil, p.errorf("unexpected keyword %s", strings.ToUpper(tok.text))
+		}
+		return Property{Name: tok.text}, p.next()
+	case tokEOF:
+		return nil, p.errorf("expected a value, got end of filter")
+	}
+	return nil, p.errorf("expected a value, got %q", tok.text)
+}
+
+func (p *parser) parseNumber(sign float64) (Scalar, error) {
+	n, err := strconv.ParseFloat(p.tok.text, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %q", p.tok.text)
+	}
+	return Literal{Value: sign * n, Kind: KindNumber}, p.next()
+}
+
+func (p *parser) parseTemporal() (Scalar, error) {
+	kind := KindDate
+	if p.tok.is("TIMESTAMP") {
+		kind = KindTimestamp
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokString {
+		return nil, p.errorf("expected a quoted %s", kind)
+	}
+	var t time.Time
+	var err error
+	if kind == KindDate {
+		t, err = time.Parse(time.DateOnly, p.tok.text)
+	} else {
+		t, err = time.Parse(time.RFC3339Nano, p.tok.text)
+	}
+	if err != nil {
+		return nil, p.errorf("invalid %s %q", kind, p.tok.text)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return Literal{Value: t, Kind: kind}, p.expect(")")
+}
+
+func (p *parser) parseSpatial() (Expr, error) {
+	s := Spatial{Op: strings.ToUpper(p.tok.text)}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var err error
+	if s.Left, err = p.parseGeometryOperand(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	if s.Right, err = p.parseGeometryOperand(); err != nil {
+		return nil, err
+	}
+	if s.Op == "S_DWITHIN" {
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+		distance, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		l, isLiteral := distance.(Literal)
+		if !isLiteral || l.Kind != KindNumber || l.Value.(float64) < 0 {
+			return nil, p.errorf("the distance of S_DWITHIN should be a positive number")
+		}
+		s.Distance = l.Value.(float64)
+		if p.tok.is(",") {
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+			switch {
+			case p.tok.is("meters"), p.tok.is("m"):
+			case p.tok.is("kilometers"), p.tok.is("km"):
+				s.Distance *= 1000
+			default:
+				return nil, p.errorf("unknown distance unit %q, use meters or kilometers", p.tok.text)
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s, p.expect(")")
+}
+
+// parseGeometryOperand reads a property, a WKT geometry or a BBOX(min_x, min_y, max_x, max_y)
+func (p *parser) parseGeometryOperand() (GeometryOperand, error) {
+	tok := p.tok
+	switch {
+	case tok.kind == tokQuotedIdent:
+		return Property{Name: tok.text}, p.next()
+	case tok.kind == tokIdent && wktTypes[strings.ToUpper(tok.text)]:
+		return p.parseWkt()
+	case tok.is("BBOX"):
+		return p.parseBbox()
+	case tok.kind == tokIdent && !isKeyword(tok.text):
+		return Property{Name: tok.text}, p.next()
+	case tok.kind == tokEOF:
+		return nil, p.errorf("expected a geometry, got end of filter")
+	}
+	return nil, p.errorf("expected a geometry, got %q", tok.text)
+}
+
+// parseWkt takes the text from the geometry tag to its closing parenthesis, or to EMPTY
+func (p *parser) parseWkt() (GeometryOperand, error) {
+	start := p.tok.pos
+	level := 0
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == '(' {
+			level++
+		} else if c == ')' {
+			if level == 0 {
+				break
+			}
+			level--
+			if level == 0 {
+				p.pos++
+				break
+			}
+		} else if level == 0 && (c == ',') {
+			break
+		}
+		p.pos++
+	}
+	text := p.src[start:p.pos]
+	g, err := geom.UnmarshalWKT(text)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	return GeometryLiteral{Geometry: g}, nil
+}
+
+func (p *parser) parseBbox() (GeometryOperand, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var n []float64
+	for {
+		v, err := p.parseScalar()
+		if err != nil {
+			return nil, err
+		}
+		l, isLiteral := v.(Literal)
+		if !isLiteral || l.Kind != KindNumber {
+			return nil, p.errorf("BBOX expects numbers")
+		}
+		n = append(n, l.Value.(float64))
+		if !p.tok.is(",") {
+			break
+		}
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+	}
+	if len(n) == 6 {
+		n = []float64{n[0], n[1], n[3], n[4]}
+	}
+	if len(n) != 4 {
+		return nil, p.errorf("BBOX expects 4 or 6 numbers")
+	}
+	ring := []geom.Coord{{X: n[0], Y: n[1]}, {X: n[2], Y: n[1]}, {X: n[2], Y: n[3]}, {X: n[0], Y: n[3]}, {X: n[0], Y: n[1]}}
+	return GeometryLiteral{Geometry: &geom.Polygon{Lay: geom.XY, Rings: [][]geom.Coord{ring}}}, p.expect(")")
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "LIKE": true, "BETWEEN": true, "IN": true, "IS": true, "NULL": true,
+	"TRUE": true, "FALSE": true, "DATE": true, "TIMESTAMP": true, "BBOX": true,
+	"S_INTERSECTS": true, "S_WITHIN": true, "S_DWITHIN": true,
+}
+
+func isKeyword(s string) bool {
+	return keywords[strings.ToUpper(s)]
+}
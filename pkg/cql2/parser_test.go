@@ -0,0 +1,111 @@
+package cql2
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	a, b, c := Property{Name: "a"}, Property{Name: "b"}, Property{Name: "c"}
+	one := Literal{Value: 1.0, Kind: KindNumber}
+	tests := []struct {
+		name   string
+		filter string
+		want   Expr
+	}{
+		{"AND binds tighter than OR", "a = 1 OR b = 1 AND c = 1",
+			Logical{Op: "OR", Left: Comparison{Op: "=", Left: a, Right: one},
+				Right: Logical{Op: "AND", Left: Comparison{Op: "=", Left: b, Right: one}, Right: Comparison{Op: "=", Left: c, Right: one}}}},
+		{"parentheses override the precedence", "(a = 1 OR b = 1) AND c = 1",
+			Logical{Op: "AND", Left: Logical{Op: "OR", Left: Comparison{Op: "=", Left: a, Right: one}, Right: Comparison{Op: "=", Left: b, Right: one}},
+				Right: Comparison{Op: "=", Left: c, Right: one}}},
+		{"NOT applies to the next predicate only", "NOT a = 1 AND b = 1",
+			Logical{Op: "AND", Left: Not{Expr: Comparison{Op: "=", Left: a, Right: one}}, Right: Comparison{Op: "=", Left: b, Right: one}}},
+		{"keywords are case-insensitive", "a = 1 or b = 1",
+			Logical{Op: "OR", Left: Comparison{Op: "=", Left: a, Right: one}, Right: Comparison{Op: "=", Left: b, Right: one}}},
+		{"quoted identifier", `"x;drop" = 'v'`, Comparison{Op: "=", Left: Property{Name: "x;drop"}, Right: Literal{Value: "v", Kind: KindString}}},
+		{"doubled quote in a string", "a = 'l''avenue'", Comparison{Op: "=", Left: a, Right: Literal{Value: "l'avenue", Kind: KindString}}},
+		{"negative number", "a >= -1.5e2", Comparison{Op: ">=", Left: a, Right: Literal{Value: -150.0, Kind: KindNumber}}},
+		{"BETWEEN", "a NOT BETWEEN 1 AND 2",
+			Between{Value: a, Low: one, High: Literal{Value: 2.0, Kind: KindNumber}, Not: true}},
+		{"IN", "a IN ('x', 'y')",
+			In{Value: a, List: []Scalar{Literal{Value: "x", Kind: KindString}, Literal{Value: "y", Kind: KindString}}}},
+		{"LIKE", "a NOT LIKE 'Av%'", Like{Value: a, Pattern: Literal{Value: "Av%", Kind: KindString}, Not: true}},
+		{"IS NOT NULL", "a IS NOT NULL", IsNull{Value: a, Not: true}},
+		{"DATE", "a > DATE('2024-02-29')",
+			Comparison{Op: ">", Left: a, Right: Literal{Value: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC), Kind: KindDate}}},
+		{"constant", "TRUE", Constant{Value: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed : %v", tt.filter, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSpatial(t *testing.T) {
+	got, err := Parse("S_DWITHIN(geom, POINT(2538000 1152000), 1.5, kilometers)")
+	if err != nil {
+		t.Fatalf("Parse failed : %v", err)
+	}
+	s, ok := got.(Spatial)
+	if !ok || s.Op != "S_DWITHIN" || s.Distance != 1500 || s.Left != (Property{Name: "geom"}) {
+		t.Fatalf("Parse = %#v, want S_DWITHIN of geom at 1500 meters", got)
+	}
+	if _, isLiteral := s.Right.(GeometryLiteral); !isLiteral {
+		t.Errorf("the second argument is %T, want a GeometryLiteral", s.Right)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+	}{
+		{"empty", ""},
+		{"unterminated string", "a = 'x"},
+		{"unterminated identifier", `"a = 1`},
+		{"sql comment", "a = 1 -- drop"},
+		{"statement separator", "a = 1; DROP TABLE t"},
+		{"trailing tokens", "a = 1 b = 2"},
+		{"keyword as value", "a = AND"},
+		{"NOT without predicate", "a NOT = 1"},
+		{"invalid date", "a = DATE('2024-02-30')"},
+		{"negative distance", "S_DWITHIN(geom, POINT(0 0), -1)"},
+		{"unknown distance unit", "S_DWITHIN(geom, POINT(0 0), 1, miles)"},
+		{"BBOX of 3 numbers", "S_INTERSECTS(geom, BBOX(0, 0, 1))"},
+		{"unclosed ring", "S_INTERSECTS(geom, POLYGON((0 0, 1 0, 1 1, 0 1)))"},
+		{"short ring", "S_INTERSECTS(geom, POLYGON((0 0, 1 0, 1 1)))"},
+		{"unbalanced parentheses", "(a = 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Parse(tt.filter); !errors.Is(err, ErrSyntax) {
+				t.Errorf("Parse(%q) = %#v, %v, want an ErrSyntax", tt.filter, got, err)
+			}
+		})
+	}
+}
+
+func TestParseDepthLimit(t *testing.T) {
+	nested := func(n int) string {
+		return strings.Repeat("(", n) + "a = 1" + strings.Repeat(")", n)
+	}
+	if _, err := Parse(nested(maxDepth - 1)); err != nil {
+		t.Errorf("Parse of %d nested parentheses failed : %v", maxDepth-1, err)
+	}
+	for _, filter := range []string{nested(maxDepth + 1), nested(100000), strings.Repeat("NOT ", maxDepth+1) + "a = 1"} {
+		if _, err := Parse(filter); !errors.Is(err, ErrSyntax) || !strings.Contains(err.Error(), "nested") {
+			t.Errorf("Parse of a filter nested more than %d levels returned %v, want the nesting error", maxDepth, err)
+		}
+	}
+}
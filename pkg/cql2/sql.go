@@ -0,0 +1,388 @@
+package cql2
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+var (
+	ErrInvalidFilter      = errors.New("invalid cql2 filter")
+	ErrUnsupportedSpatial = errors.New("the spatial functions need PostGIS or mod_spatialite")
+)
+
+// Schema describes the table a filter applies to
+type Schema struct {
+	// Alias prefixes the columns in the sql, like t
+	Alias string
+	// Properties are the columns usable in the filters with their kind, see KindOfType
+	Properties map[string]Kind
+	// Geometry is the geometry column, in SrsID, it is a GeoPackage blob on sqlite
+	Geometry string
+	SrsID    int
+	// FilterSrid is the srid of the geometry literals, 4326 for the default CRS84
+	FilterSrid int
+	// Spatialite tells that the sqlite connection has the SpatiaLite functions
+	Spatialite bool
+	// Rtree is the GeoPackage rtree of Geometry on sqlite, used to reduce the rows given to SpatiaLite
+	Rtree string
+}
+
+type translator struct {
+	s *Schema
+	p *database.Params
+}
+
+// ToSql returns the sql condition of e on the table described by s, every literal is added to p
+// and only its placeholder is written in the sql text
+func ToSql(e Expr, s *Schema, p *database.Params) (string, error) {
+	t := &translator{s: s, p: p}
+	return t.expr(e)
+}
+
+func invalidFilter(format string, v ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidFilter, fmt.Sprintf(format, v...))
+}
+
+// normalizeType returns the lowercase type name without its size, like varchar for VARCHAR(20)
+func normalizeType(sqlType string) string {
+	t := strings.ToLower(strings.TrimSpace(sqlType))
+	if i := strings.IndexByte(t, '('); i >= 0 {
+		t = strings.TrimSpace(t[:i])
+	}
+	return t
+}
+
+func (t *translator) isPostgres() bool {
+	return t.p.Dialect() == database.DialectPostgres
+}
+
+func (t *translator) column(name string) string {
+	return t.s.Alias + "." + dataset.QuoteIdentifier(name)
+}
+
+func (t *translator) expr(e Expr) (string, error) {
+	switch e := e.(type) {
+	case Logical:
+		left, err := t.expr(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := t.expr(e.Right)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(%s %s %s)", left, e.Op, right), nil
+	case Not:
+		inner, err := t.expr(e.Expr)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("(NOT %s)", inner), nil
+	case Constant:
+		if e.Value {
+			return "(1=1)", nil
+		}
+		return "(1=0)", nil
+	case Comparison:
+		return t.comparison(e)
+	case Like:
+		return t.like(e)
+	case In:
+		return t.in(e)
+	case Between:
+		return t.between(e)
+	case IsNull:
+		value, err := t.scalar(e.Value, KindAny)
+		if err != nil {
+			return "", err
+		}
+		if e.Not {
+			return fmt.Sprintf("(%s IS NOT NULL)", value), nil
+		}
+		return fmt.Sprintf("(%s IS NULL)", value), nil
+	case Spatial:
+		return t.spatial(e)
+	}
+	return "", invalidFilter("unsupported expression %T", e)
+}
+
+// kindOf returns the kind of a scalar, the literals have their own and the properties the one of their column
+func (t *translator) kindOf(s Scalar) (Kind, error) {
+	switch s := s.(type) {
+	case Literal:
+		return s.Kind, nil
+	case Property:
+		k, ok := t.s.Properties[s.Name]
+		if !ok {
+			return KindAny, invalidFilter("unknown property %q", s.Name)
+		}
+		if k == KindGeometry {
+			return k, invalidFilter("the geometry %q can only be used in the spatial functions", s.Name)
+		}
+		return k, nil
+	}
+	return KindAny, invalidFilter("unsupported value %T", s)
+}
+
+// compatible tells if values of kinds a and b can be compared, the dates compare with the timestamps
+func compatible(a, b Kind) bool {
+	if a == KindAny || b == KindAny || a == b {
+		return true
+	}
+	return (a == KindDate || a == KindTimestamp) && (b == KindDate || b == KindTimestamp)
+}
+
+// commonKind returns the kind the scalars of one predicate are compared as
+func (t *translator) commonKind(scalars ...Scalar) (Kind, error) {
+	common := KindAny
+	for _, s := range scalars {
+		k, err := t.kindOf(s)
+		if err != nil {
+			return KindAny, err
+		}
+		if !compatible(common, k) {
+			return KindAny, invalidFilter("cannot compare a %s with a %s", common, k)
+		}
+		if common == KindAny || (common == KindDate && k == KindTimestamp) {
+			common = k
+		}
+	}
+	return common, nil
+}
+
+// scalar returns the sql of s compared as kind, a column of unknown type is cast to text on postgres
+// when compared with a string, since the prepared statement would refuse a text argument for it
+func (t *translator) scalar(s Scalar, kind Kind) (string, error) {
+	switch s := s.(type) {
+	case Property:
+		k, err := t.kindOf(s)
+		if err != nil {
+			return "", err
+		}
+		c := t.column(s.Name)
+		if t.isPostgres() && k == KindAny && kind == KindString {
+			c += "::text"
+		}
+		return c, nil
+	case Literal:
+		return t.literal(s, kind), nil
+	}
+	return "", invalidFilter("unsupported value %T", s)
+}
+
+// literal binds the value of l, typed on postgres where a parameter compared with another parameter has no type
+func (t *translator) literal(l Literal, kind Kind) string {
+	if tm, isTime := l.Value.(time.Time); isTime {
+		if t.isPostgres() {
+			if l.Kind == KindDate {
+				return t.p.Add(tm.Format(time.DateOnly)) + "::date"
+			}
+			return t.p.Add(tm) + "::timestamptz"
+		}
+		// a date compared with a GeoPackage DATETIME is the first instant of the day
+		k := l.Kind
+		if kind == KindTimestamp {
+			k = KindTimestamp
+		}
+		return t.p.Add(formatTemporal(tm, k))
+	}
+	placeholder := t.p.Add(l.Value)
+	if !t.isPostgres() {
+		return placeholder
+	}
+	switch l.Kind {
+	case KindNumber:
+		return placeholder + "::float8"
+	case KindBoolean:
+		return placeholder + "::boolean"
+	}
+	return placeholder + "::text"
+}
+
+func (t *translator) comparison(c Comparison) (string, error) {
+	kind, err := t.commonKind(c.Left, c.Right)
+	if err != nil {
+		return "", err
+	}
+	left, err := t.scalar(c.Left, kind)
+	if err != nil {
+		return "", err
+	}
+	right, err := t.scalar(c.Right, kind)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s %s %s)", left, c.Op, right), nil
+}
+
+func (t *translator) like(l Like) (string, error) {
+	for _, s := range []Scalar{l.Value, l.Pattern} {
+		k, err := t.kindOf(s)
+		if err != nil {
+			return "", err
+		}
+		if k != KindString && k != KindAny {
+			return "", invalidFilter("LIKE only applies to strings, not to a %s", k)
+		}
+	}
+	value, err := t.scalar(l.Value, KindString)
+	if err != nil {
+		return "", err
+	}
+	pattern, err := t.scalar(l.Pattern, KindString)
+	if err != nil {
+		return "", err
+	}
+	op := "LIKE"
+	if l.Not {
+		op = "NOT LIKE"
+	}
+	// CQL2 escapes the wildcards % and _ with a backslash
+	return fmt.Sprintf(`(%s %s %s ESCAPE '\')`, value, op, pattern), nil
+}
+
+func (t *translator) in(in In) (string, error) {
+	kind, err := t.commonKind(append([]Scalar{in.Value}, in.List...)...)
+	if err != nil {
+		return "", err
+	}
+	value, err := t.scalar(in.Value, kind)
+	if err != nil {
+		return "", err
+	}
+	list := make([]string, len(in.List))
+	for i, s := range in.List {
+		if list[i], err = t.scalar(s, kind); err != nil {
+			return "", err
+		}
+	}
+	op := "IN"
+	if in.Not {
+		op = "NOT IN"
+	}
+	return fmt.Sprintf("(%s %s (%s))", value, op, strings.Join(list, ", ")), nil
+}
+
+func (t *translator) between(b Between) (string, error) {
+	kind, err := t.commonKind(b.Value, b.Low, b.High)
+	if err != nil {
+		return "", err
+	}
+	if kind == KindBoolean {
+		return "", invalidFilter("BETWEEN does not apply to booleans")
+	}
+	sql := make([]string, 3)
+	for i, s := range []Scalar{b.Value, b.Low, b.High} {
+		if sql[i], err = t.scalar(s, kind); err != nil {
+			return "", err
+		}
+	}
+	op := "BETWEEN"
+	if b.Not {
+		op = "NOT BETWEEN"
+	}
+	return fmt.Sprintf("(%s %s %s AND %s)", sql[0], op, sql[1], sql[2]), nil
+}
+
+// spatial translates a spatial function, one of its arguments must be the geometry column and the other a literal
+func (t *translator) spatial(s Spatial) (string, error) {
+	var literal geom.Geometry
+	properties := 0
+	for _, operand := range []GeometryOperand{s.Left, s.Right} {
+		switch o := operand.(type) {
+		case Property:
+			if o.Name != t.s.Geometry {
+				return "", invalidFilter("%s expects the geometry %q, not %q", s.Op, t.s.Geometry, o.Name)
+			}
+			properties++
+		case GeometryLiteral:
+			literal = o.Geometry
+		}
+	}
+	if properties != 1 || literal == nil {
+		return "", invalidFilter("%s expects the geometry %q and a geometry literal", s.Op, t.s.Geometry)
+	}
+	// an empty literal has no envelope for the rtree and is NaN in WKB, an invalid one would fail in the database
+	if literal.IsEmpty() {
+		return "", invalidFilter("%s cannot use an empty geometry", s.Op)
+	}
+	if err := geom.Validate(literal); err != nil {
+		return "", invalidFilter("%s : %v", s.Op, err)
+	}
+	if t.isPostgres() {
+		return t.postgisSpatial(s, literal), nil
+	}
+	if !t.s.Spatialite {
+		return "", ErrUnsupportedSpatial
+	}
+	return t.spatialiteSpatial(s, literal)
+}
+
+// postgisSpatial lets PostGIS transform the literal to the srid of the column, so that its index is used,
+// the distances of S_DWITHIN are in meters on the geography when the column is in degrees
+func (t *translator) postgisSpatial(s Spatial, g geom.Geometry) string {
+	column := t.column(t.s.Geometry)
+	literal := fmt.Sprintf("ST_GeomFromWKB(%s::bytea, %d)", t.p.Add(geom.EncodeWkb(g)), t.s.FilterSrid)
+	if t.s.FilterSrid != t.s.SrsID {
+		literal = fmt.Sprintf("ST_Transform(%s, %d)", literal, t.s.SrsID)
+	}
+	left, right := column, literal
+	if _, literalFirst := s.Left.(GeometryLiteral); literalFirst {
+		left, right = literal, column
+	}
+	switch s.Op {
+	case "S_WITHIN":
+		return fmt.Sprintf("ST_Within(%s, %s)", left, right)
+	case "S_DWITHIN":
+		if t.s.SrsID == geom.SridWgs84 {
+			return fmt.Sprintf("ST_DWithin(%s::geography, %s::geography, %s::float8)", left, right, t.p.Add(s.Distance))
+		}
+		return fmt.Sprintf("ST_DWithin(%s, %s, %s::float8)", left, right, t.p.Add(s.Distance))
+	}
+	return fmt.Sprintf("ST_Intersects(%s, %s)", left, right)
+}
+
+// spatialiteSpatial transforms the literal in go, first selects the candidates in the rtree with its envelope
+// and then lets SpatiaLite check the predicate on the decoded GeoPackage geometries
+func (t *translator) spatialiteSpatial(s Spatial, g geom.Geometry) (string, error) {
+	toStorage, err := geom.GetTransform(t.s.FilterSrid, t.s.SrsID)
+	if err != nil {
+		return "", invalidFilter("%v", err)
+	}
+	g = geom.Transform(g, toStorage)
+	var conditions []string
+	if t.s.Rtree != "" && (s.Op != "S_DWITHIN" || t.s.SrsID != geom.SridWgs84) {
+		e := geom.EnvelopeOf(g)
+		if s.Op == "S_DWITHIN" {
+			e = geom.Envelope{MinX: e.MinX - s.Distance, MinY: e.MinY - s.Distance, MaxX: e.MaxX + s.Distance, MaxY: e.MaxY + s.Distance}
+		}
+		conditions = append(conditions, fmt.Sprintf("%s.rowid IN (SELECT id FROM %s WHERE maxx >= %s AND minx <= %s AND maxy >= %s AND miny <= %s)",
+			t.s.Alias, dataset.QuoteIdentifier(t.s.Rtree), t.p.Add(e.MinX), t.p.Add(e.MaxX), t.p.Add(e.MinY), t.p.Add(e.MaxY)))
+	}
+	column := fmt.Sprintf("GeomFromGPB(%s)", t.column(t.s.Geometry))
+	literal := fmt.Sprintf("GeomFromWKB(%s, %d)", t.p.Add(geom.EncodeWkb(g)), t.s.SrsID)
+	left, right := column, literal
+	if _, literalFirst := s.Left.(GeometryLiteral); literalFirst {
+		left, right = literal, column
+	}
+	switch s.Op {
+	case "S_WITHIN":
+		conditions = append(conditions, fmt.Sprintf("ST_Within(%s, %s) = 1", left, right))
+	case "S_DWITHIN":
+		if t.s.SrsID == geom.SridWgs84 {
+			// the third argument asks for the distance in meters on the ellipsoid
+			conditions = append(conditions, fmt.Sprintf("ST_Distance(%s, %s, 1) <= %s", left, right, t.p.Add(s.Distance)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("ST_Distance(%s, %s) <= %s", left, right, t.p.Add(s.Distance)))
+		}
+	default:
+		conditions = append(conditions, fmt.Sprintf("ST_Intersects(%s, %s) = 1", left, right))
+	}
+	return "(" + strings.Join(conditions, " AND ") + ")", nil
+}
@@ -0,0 +1,181 @@
+package cql2
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+)
+
+// testSchema is a table in LV95 with a name, a population, a date and a column of a type unknown to cql2
+func testSchema() *Schema {
+	return &Schema{
+		Alias: "t",
+		Properties: map[string]Kind{
+			"name": KindString, "population": KindNumber, "created": KindDate, "code": KindAny, "geom": KindGeometry,
+		},
+		Geometry:   "geom",
+		SrsID:      2056,
+		FilterSrid: 2056,
+	}
+}
+
+func TestToSql(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   string
+		dialect  database.Dialect
+		wantSql  string
+		wantArgs []interface{}
+	}{
+		{"precedence on postgres", "name = 'a' OR population > 10 AND population < 20", database.DialectPostgres,
+			`((t."name" = $1::text) OR ((t."population" > $2::float8) AND (t."population" < $3::float8)))`,
+			[]interface{}{"a", 10.0, 20.0}},
+		{"precedence on sqlite", "name = 'a' OR population > 10 AND population < 20", database.DialectSqlite,
+			`((t."name" = ?) OR ((t."population" > ?) AND (t."population" < ?)))`,
+			[]interface{}{"a", 10.0, 20.0}},
+		{"BETWEEN on postgres", "population NOT BETWEEN 1 AND 2", database.DialectPostgres,
+			`(t."population" NOT BETWEEN $1::float8 AND $2::float8)`, []interface{}{1.0, 2.0}},
+		{"BETWEEN on sqlite", "population BETWEEN 1 AND 2", database.DialectSqlite,
+			`(t."population" BETWEEN ? AND ?)`, []interface{}{1.0, 2.0}},
+		{"IN on postgres", "name IN ('a', 'b')", database.DialectPostgres,
+			`(t."name" IN ($1::text, $2::text))`, []interface{}{"a", "b"}},
+		{"NOT IN on sqlite", "name NOT IN ('a', 'b')", database.DialectSqlite,
+			`(t."name" NOT IN (?, ?))`, []interface{}{"a", "b"}},
+		{"LIKE on postgres", "name LIKE 'Av%'", database.DialectPostgres,
+			`(t."name" LIKE $1::text ESCAPE '\')`, []interface{}{"Av%"}},
+		{"LIKE of an unknown type cast to text on postgres", "code LIKE '1%'", database.DialectPostgres,
+			`(t."code"::text LIKE $1::text ESCAPE '\')`, []interface{}{"1%"}},
+		{"NOT LIKE on sqlite", "name NOT LIKE 'Av%'", database.DialectSqlite,
+			`(t."name" NOT LIKE ? ESCAPE '\')`, []interface{}{"Av%"}},
+		{"quotes stay in the argument", "name = 'x''); DROP TABLE t; --'", database.DialectSqlite,
+			`(t."name" = ?)`, []interface{}{"x'); DROP TABLE t; --"}},
+		{"date on sqlite", "created >= DATE('2024-01-31')", database.DialectSqlite,
+			`(t."created" >= ?)`, []interface{}{"2024-01-31"}},
+		{"NOT and IS NULL", "NOT name IS NULL", database.DialectPostgres, `(NOT (t."name" IS NULL))`, nil},
+		{"constant", "FALSE", database.DialectSqlite, "(1=0)", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed : %v", tt.filter, err)
+			}
+			p := database.NewParams(tt.dialect)
+			got, err := ToSql(e, testSchema(), p)
+			if err != nil {
+				t.Fatalf("ToSql(%q) failed : %v", tt.filter, err)
+			}
+			if got != tt.wantSql {
+				t.Errorf("ToSql(%q) =\n%s\nwant\n%s", tt.filter, got, tt.wantSql)
+			}
+			if !reflect.DeepEqual(p.Values(), tt.wantArgs) {
+				t.Errorf("ToSql(%q) bound %#v, want %#v", tt.filter, p.Values(), tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestToSqlErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		filter  string
+		dialect database.Dialect
+		schema  func(*Schema)
+		want    error
+	}{
+		{"identifier not in the properties", `"x;drop" = 1`, database.DialectPostgres, nil, ErrInvalidFilter},
+		{"unknown property", "secret = 'a'", database.DialectSqlite, nil, ErrInvalidFilter},
+		{"geometry in a comparison", "geom = 'a'", database.DialectPostgres, nil, ErrInvalidFilter},
+		{"string compared with a number", "population = 'a'", database.DialectPostgres, nil, ErrInvalidFilter},
+		{"LIKE on a number", "population LIKE '1%'", database.DialectSqlite, nil, ErrInvalidFilter},
+		{"BETWEEN booleans", "TRUE BETWEEN FALSE AND TRUE", database.DialectSqlite, nil, ErrInvalidFilter},
+		{"spatial function on another column", "S_INTERSECTS(name, POINT(0 0))", database.DialectPostgres, nil, ErrInvalidFilter},
+		{"spatial function on two literals", "S_INTERSECTS(POINT(0 0), POINT(1 1))", database.DialectPostgres, nil, ErrInvalidFilter},
+		{"empty point", "S_INTERSECTS(geom, POINT EMPTY)", database.DialectPostgres, nil, ErrInvalidFilter},
+		{"empty polygon with an rtree", "S_INTERSECTS(geom, POLYGON EMPTY)", database.DialectSqlite,
+			func(s *Schema) { s.Spatialite, s.Rtree = true, "rtree_t_geom" }, ErrInvalidFilter},
+		{"spatial function without SpatiaLite", "S_INTERSECTS(geom, POINT(0 0))", database.DialectSqlite, nil, ErrUnsupportedSpatial},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := Parse(tt.filter)
+			if err != nil {
+				t.Fatalf("Parse(%q) failed : %v", tt.filter, err)
+			}
+			s := testSchema()
+			if tt.schema != nil {
+				tt.schema(s)
+			}
+			p := database.NewParams(tt.dialect)
+			if got, err := ToSql(e, s, p); !errors.Is(err, tt.want) {
+				t.Errorf("ToSql(%q) = %q, %v, want %v", tt.filter, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestToSqlSpatial(t *testing.T) {
+	t.Run("postgres binds the literal as wkb", func(t *testing.T) {
+		e, err := Parse("S_INTERSECTS(geom, POINT(2538000 1152000))")
+		if err != nil {
+			t.Fatalf("Parse failed : %v", err)
+		}
+		p := database.NewParams(database.DialectPostgres)
+		got, err := ToSql(e, testSchema(), p)
+		if err != nil {
+			t.Fatalf("ToSql failed : %v", err)
+		}
+		if want := `ST_Intersects(t."geom", ST_GeomFromWKB($1::bytea, 2056))`; got != want {
+			t.Errorf("ToSql = %s, want %s", got, want)
+		}
+		if _, isWkb := p.Values()[0].([]byte); len(p.Values()) != 1 || !isWkb {
+			t.Errorf("ToSql bound %#v, want the wkb of the point", p.Values())
+		}
+	})
+	t.Run("sqlite prefilters with the rtree", func(t *testing.T) {
+		e, err := Parse("S_DWITHIN(geom, POINT(2538000 1152000), 100)")
+		if err != nil {
+			t.Fatalf("Parse failed : %v", err)
+		}
+		s := testSchema()
+		s.Spatialite, s.Rtree = true, "rtree_t_geom"
+		p := database.NewParams(database.DialectSqlite)
+		got, err := ToSql(e, s, p)
+		if err != nil {
+			t.Fatalf("ToSql failed : %v", err)
+		}
+		prefilter := `t.rowid IN (SELECT id FROM "rtree_t_geom" WHERE maxx >= ? AND minx <= ? AND maxy >= ? AND miny <= ?)`
+		if !strings.HasPrefix(got, "("+prefilter+" AND ST_Distance(GeomFromGPB(t.\"geom\"), GeomFromWKB(?, 2056)) <= ?)") {
+			t.Errorf("ToSql =\n%s\nwant the rtree prefilter followed by ST_Distance", got)
+		}
+		values := p.Values()
+		if len(values) != 6 {
+			t.Fatalf("ToSql bound %d values, want 6", len(values))
+		}
+		// the window is the envelope of the point grown by the distance
+		if want := []interface{}{2537900.0, 2538100.0, 1151900.0, 1152100.0}; !reflect.DeepEqual(values[:4], want) {
+			t.Errorf("rtree window = %v, want %v", values[:4], want)
+		}
+		if values[5] != 100.0 {
+			t.Errorf("distance = %v, want 100", values[5])
+		}
+	})
+	t.Run("sqlite without rtree has no prefilter", func(t *testing.T) {
+		e, err := Parse("S_WITHIN(geom, BBOX(2538000, 1152000, 2539000, 1153000))")
+		if err != nil {
+			t.Fatalf("Parse failed : %v", err)
+		}
+		s := testSchema()
+		s.Spatialite = true
+		got, err := ToSql(e, s, database.NewParams(database.DialectSqlite))
+		if err != nil {
+			t.Fatalf("ToSql failed : %v", err)
+		}
+		if want := `(ST_Within(GeomFromGPB(t."geom"), GeomFromWKB(?, 2056)) = 1)`; got != want {
+			t.Errorf("ToSql = %s, want %s", got, want)
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// searchIndexNotEmpty is the named query used by GetSearchIndexCheck, it returns 1 when search_item has at least one row
+var searchIndexNotEmpty = MustRegisterNamedQuery("search_index_not_empty", map[Dialect]string{
+	DialectPostgres: "SELECT count(*) FROM (SELECT 1 FROM search_item LIMIT 1) AS one_item;",
+	DialectSqlite:   "SELECT count(*) FROM (SELECT 1 FROM search_item LIMIT 1) AS one_item;",
+})
+
+// spatialSupport is the named query used by GetDbCheck, it returns true when PostGIS or the GeoPackage metadata is there
+var spatialSupport = MustRegisterNamedQuery("spatial_support", map[Dialect]string{
+	DialectPostgres: getPostgisExists,
+	DialectSqlite:   "SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type='table' AND name = 'gpkg_geometry_columns');",
+})
+
+// GetDbCheck returns a check verifying that db answers and has its spatial extension (PostGIS or GeoPackage) available,
+// both queries are bounded by the check context and the spatial support is only queried until it was found once
+func GetDbCheck(db DB) func(ctx context.Context) error {
+	var spatial atomic.Bool
+	return func(ctx context.Context) error {
+		if err := db.Ping(ctx); err != nil {
+			return fmt.Errorf("database ping failed: %w", err)
+		}
+		if spatial.Load() {
+			return nil
+		}
+		var isSpatial bool
+		if err := NamedQueryRow(ctx, db, spatialSupport, []interface{}{&isSpatial}); err != nil {
+			return fmt.Errorf("spatial support query failed: %w", err)
+		}
+		if !isSpatial {
+			return errors.New("database is reachable but has no spatial support")
+		}
+		spatial.Store(true)
+		return nil
+	}
+}
+
+// GetSearchIndexCheck returns a check verifying that the search_item table exists and is not empty
+func GetSearchIndexCheck(db DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var count int
+		if err := NamedQueryRow(ctx, db, searchIndexNotEmpty, []interface{}{&count}); err != nil {
+			return fmt.Errorf("search index query failed: %w", err)
+		}
+		if count == 0 {
+			return errors.New("search index table search_item is empty")
+		}
+		return nil
+	}
+}
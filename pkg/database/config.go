@@ -0,0 +1,285 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultDbDriver            = "postgres"
+	defaultDbHost              = "127.0.0.1"
+	defaultDbPort              = 5432
+	defaultDbSslMode           = "prefer"
+	defaultDbPath              = "geodata/search.sqlite3"
+	defaultPoolMinConns        = 0
+	defaultPoolMaxConnLifetime = time.Hour
+	defaultPoolMaxConnIdleTime = 30 * time.Minute
+	defaultPoolHealthCheck     = time.Minute
+	defaultDbConnectTimeout    = 10 * time.Second
+	envFileSuffix              = "_FILE"
+)
+
+// validSslModes are the values accepted by libpq and pgx for sslmode
+// check information in : https://www.postgresql.org/docs/current/libpq-ssl.html
+var validSslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// Config holds everything needed to open a connection to one of the supported databases
+type Config struct {
+	Driver            string        // pgx (alias postgres) or sqlite3
+	Host              string        // postgres host name or ip
+	Port              int           // postgres tcp port
+	Name              string        // postgres database name
+	User              string        // postgres user
+	Password          string        // postgres password (prefer DB_PASSWORD_FILE in k8s)
+	SslMode           string        // one of disable|allow|prefer|require|verify-ca|verify-full
+	SslRootCert       string        // path to the CA certificate used with verify-ca and verify-full
+	SslCert           string        // path to the client certificate
+	SslKey            string        // path to the client certificate key
+	Path              string        // path to the sqlite3 file (GeoPackage)
+	SqliteReadOnly    bool          // open the sqlite3 file without a writer connection
+	SqliteImmutable   bool          // the sqlite3 file is a shipped GeoPackage that never changes
+	SqliteJournalMode string        // journal mode set on the sqlite3 file, WAL when empty unless read-only
+	SqliteBusyTimeout time.Duration // how long a sqlite3 connection waits on a lock
+	MinConns          int           // minimum number of connections kept open in the pool
+	MaxConns          int           // maximum number of connections in the pool
+	MaxConnLifetime   time.Duration // a connection older than this will be closed
+	MaxConnIdleTime   time.Duration // an idle connection older than this will be closed
+	HealthCheckPeriod time.Duration // interval between checks of idle connections
+	ConnectTimeout    time.Duration // max time to establish a new connection
+}
+
+// GetConfigFromEnv builds a database Config from the DB_* environment variables.
+// Each variable can also be given as a path to a file containing the value, by appending _FILE to its name
+// (for example DB_PASSWORD_FILE=/run/secrets/db_password), which is the way k8s secrets are usually mounted.
+// maxConnectionCount is used as the default pool size when DB_POOL_MAX_CONNS is not defined.
+func GetConfigFromEnv(maxConnectionCount int) (*Config, error) {
+	var errs []error
+	getString := func(name, defaultValue string) string {
+		val, err := getEnvOrFile(name, defaultValue)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return val
+	}
+	getInt := func(name string, defaultValue int) int {
+		val := getString(name, strconv.Itoa(defaultValue))
+		i, err := strconv.Atoi(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("env %s should contain an integer, got '%s'", name, val))
+			return defaultValue
+		}
+		return i
+	}
+	getBool := func(name string, defaultValue bool) bool {
+		val := getString(name, strconv.FormatBool(defaultValue))
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("env %s should contain true or false, got '%s'", name, val))
+			return defaultValue
+		}
+		return b
+	}
+	getDuration := func(name string, defaultValue time.Duration) time.Duration {
+		val := getString(name, defaultValue.String())
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("env %s should contain a duration like 30s or 5m, got '%s'", name, val))
+			return defaultValue
+		}
+		return d
+	}
+
+	cfg := &Config{
+		Driver:            getString("DB_DRIVER", defaultDbDriver),
+		Host:              getString("DB_HOST", defaultDbHost),
+		Port:              getInt("DB_PORT", defaultDbPort),
+		Name:              getString("DB_NAME", ""),
+		User:              getString("DB_USER", ""),
+		Password:          getString("DB_PASSWORD", ""),
+		SslMode:           getString("DB_SSL_MODE", defaultDbSslMode),
+		SslRootCert:       getString("DB_SSL_ROOT_CERT", ""),
+		SslCert:           getString("DB_SSL_CERT", ""),
+		SslKey:            getString("DB_SSL_KEY", ""),
+		Path:              getString("DB_PATH", defaultDbPath),
+		SqliteReadOnly:    getBool("DB_SQLITE_READ_ONLY", false),
+		SqliteImmutable:   getBool("DB_SQLITE_IMMUTABLE", false),
+		SqliteJournalMode: getString("DB_SQLITE_JOURNAL_MODE", ""),
+		SqliteBusyTimeout: getDuration("DB_SQLITE_BUSY_TIMEOUT", defaultSqliteBusyTimeout),
+		MinConns:          getInt("DB_POOL_MIN_CONNS", defaultPoolMinConns),
+		MaxConns:          getInt("DB_POOL_MAX_CONNS", maxConnectionCount),
+		MaxConnLifetime:   getDuration("DB_POOL_MAX_CONN_LIFETIME", defaultPoolMaxConnLifetime),
+		MaxConnIdleTime:   getDuration("DB_POOL_MAX_CONN_IDLE_TIME", defaultPoolMaxConnIdleTime),
+		HealthCheckPeriod: getDuration("DB_POOL_HEALTH_CHECK_PERIOD", defaultPoolHealthCheck),
+		ConnectTimeout:    getDuration("DB_CONNECT_TIMEOUT", defaultDbConnectTimeout),
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// getEnvOrFile returns the value of the env variable name, or the trimmed content of the file
+// referenced by name_FILE, or defaultValue when none of them is defined
+func getEnvOrFile(name, defaultValue string) (string, error) {
+	if filePath, ok := os.LookupEnv(name + envFileSuffix); ok && filePath != "" {
+		if _, alsoDefined := os.LookupEnv(name); alsoDefined {
+			return "", fmt.Errorf("env %s and %s%s are mutually exclusive", name, name, envFileSuffix)
+		}
+		content, err := os.ReadFile(filePath)
+		if err != nil {
+			return "", fmt.Errorf("error reading file given in env %s%s : %w", name, envFileSuffix, err)
+		}
+		return strings.TrimRight(string(content), "\r\n"), nil
+	}
+	if val, ok := os.LookupEnv(name); ok {
+		return val, nil
+	}
+	return defaultValue, nil
+}
+
+// normalizedDriver returns the driver name used by GetInstance
+func (c *Config) normalizedDriver() string {
+	switch strings.ToLower(strings.TrimSpace(c.Driver)) {
+	case "pgx", "postgres", "postgresql":
+		return "pgx"
+	case "sqlite", "sqlite3", "gpkg", "geopackage":
+		return "sqlite3"
+	default:
+		return c.Driver
+	}
+}
+
+// Validate checks that the configuration is coherent and returns all the problems found at once
+func (c *Config) Validate() error {
+	var errs []error
+	switch c.normalizedDriver() {
+	case "pgx":
+		if strings.TrimSpace(c.Host) == "" {
+			errs = append(errs, errors.New("DB_HOST cannot be empty"))
+		}
+		if c.Port < 1 || c.Port > 65535 {
+			errs = append(errs, fmt.Errorf("DB_PORT should be between 1 and 65535, got %d", c.Port))
+		}
+		if strings.TrimSpace(c.Name) == "" {
+			errs = append(errs, errors.New("DB_NAME cannot be empty"))
+		}
+		if strings.TrimSpace(c.User) == "" {
+			errs = append(errs, errors.New("DB_USER cannot be empty"))
+		}
+		if !validSslModes[c.SslMode] {
+			errs = append(errs, fmt.Errorf("DB_SSL_MODE '%s' is not one of disable|allow|prefer|require|verify-ca|verify-full", c.SslMode))
+		}
+		if (c.SslMode == "verify-ca" || c.SslMode == "verify-full") && c.SslRootCert == "" {
+			errs = append(errs, fmt.Errorf("DB_SSL_ROOT_CERT is required with DB_SSL_MODE=%s", c.SslMode))
+		}
+		if (c.SslCert == "") != (c.SslKey == "") {
+			errs = append(errs, errors.New("DB_SSL_CERT and DB_SSL_KEY must be given together"))
+		}
+	case "sqlite3":
+		if strings.TrimSpace(c.Path) == "" {
+			errs = append(errs, errors.New("DB_PATH cannot be empty with the sqlite3 driver"))
+		}
+		if mode := strings.ToUpper(strings.TrimSpace(c.SqliteJournalMode)); mode != "" && !slices.Contains(sqliteJournalModes, mode) {
+			errs = append(errs, fmt.Errorf("DB_SQLITE_JOURNAL_MODE should be one of %s, got '%s'", strings.Join(sqliteJournalModes, ", "), c.SqliteJournalMode))
+		} else if mode != "" && (c.SqliteReadOnly || c.SqliteImmutable) {
+			errs = append(errs, errors.New("DB_SQLITE_JOURNAL_MODE cannot be set on a read-only or immutable sqlite3 database"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("DB_DRIVER '%s' is not supported, use postgres or sqlite3", c.Driver))
+	}
+	if c.MaxConns < 1 {
+		errs = append(errs, fmt.Errorf("DB_POOL_MAX_CONNS should be at least 1, got %d", c.MaxConns))
+	}
+	if c.MinConns < 0 || c.MinConns > c.MaxConns {
+		errs = append(errs, fmt.Errorf("DB_POOL_MIN_CONNS should be between 0 and DB_POOL_MAX_CONNS (%d), got %d", c.MaxConns, c.MinConns))
+	}
+	if c.MaxConnLifetime < 0 || c.MaxConnIdleTime < 0 || c.HealthCheckPeriod < 0 || c.ConnectTimeout < 0 || c.SqliteBusyTimeout < 0 {
+		errs = append(errs, errors.New("DB_POOL_*, DB_CONNECT_TIMEOUT and DB_SQLITE_BUSY_TIMEOUT durations cannot be negative"))
+	}
+	return errors.Join(errs...)
+}
+
+// quoteDsnValue quotes a value for a libpq key=value connection string
+func quoteDsnValue(val string) string {
+	val = strings.ReplaceAll(val, `\`, `\\`)
+	val = strings.ReplaceAll(val, `'`, `\'`)
+	return "'" + val + "'"
+}
+
+// DSN returns the libpq key=value connection string for postgres including all the ssl options
+func (c *Config) DSN() string {
+	params := []string{
+		"host=" + quoteDsnValue(c.Host),
+		fmt.Sprintf("port=%d", c.Port),
+		"dbname=" + quoteDsnValue(c.Name),
+		"user=" + quoteDsnValue(c.User),
+		"password=" + quoteDsnValue(c.Password),
+		"sslmode=" + quoteDsnValue(c.SslMode),
+	}
+	if c.SslRootCert != "" {
+		params = append(params, "sslrootcert="+quoteDsnValue(c.SslRootCert))
+	}
+	if c.SslCert != "" {
+		params = append(params, "sslcert="+quoteDsnValue(c.SslCert), "sslkey="+quoteDsnValue(c.SslKey))
+	}
+	if c.ConnectTimeout > 0 {
+		// libpq counts whole seconds and waits forever with 0, a duration under one second is rounded up
+		params = append(params, fmt.Sprintf("connect_timeout=%d", int(math.Ceil(c.ConnectTimeout.Seconds()))))
+	}
+	return strings.Join(params, " ")
+}
+
+// String returns the DSN with the password masked, safe to be logged
+func (c *Config) String() string {
+	if c.normalizedDriver() == "sqlite3" {
+		return fmt.Sprintf("sqlite3:%s", c.Path)
+	}
+	masked := *c
+	masked.Password = "********"
+	return masked.DSN()
+}
+
+// PoolConfig returns a pgxpool.Config with the tls settings derived from sslmode and the pool tuning applied
+func (c *Config) PoolConfig() (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(c.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("error doing pgxpool.ParseConfig(%s). err: %w", c, err)
+	}
+	poolConfig.MinConns = int32(c.MinConns)
+	poolConfig.MaxConns = int32(c.MaxConns)
+	poolConfig.MaxConnLifetime = c.MaxConnLifetime
+	poolConfig.MaxConnIdleTime = c.MaxConnIdleTime
+	poolConfig.HealthCheckPeriod = c.HealthCheckPeriod
+	return poolConfig, nil
+}
+
+// SqliteOptions returns the options used to open the sqlite3 database, the pool size is the read-only pool size
+func (c *Config) SqliteOptions() SqliteOptions {
+	opt := DefaultSqliteOptions()
+	opt.MaxReadConns = c.MaxConns
+	opt.ReadOnly = c.SqliteReadOnly
+	opt.Immutable = c.SqliteImmutable
+	opt.JournalMode = c.SqliteJournalMode
+	if c.SqliteBusyTimeout > 0 {
+		opt.BusyTimeout = c.SqliteBusyTimeout
+	}
+	return opt
+}
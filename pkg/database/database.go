@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
@@ -23,6 +24,11 @@ type DB interface {
 	Close()
 	IsItSpatial() bool
 	GetQueryStringArr(sql string, arguments ...interface{}) (result []string, err error)
+	Ping(ctx context.Context) error
+	Dialect() Dialect
+	QueryNamed(ctx context.Context, name string, arguments ...interface{}) (Rows, error)
+	ExecNamed(ctx context.Context, name string, arguments ...interface{}) (rowsAffected int, err error)
+	Query(ctx context.Context, sql string, arguments ...interface{}) (Rows, error)
 }
 
 func GetErrorF(errMsg string, err error) error {
@@ -34,13 +40,15 @@ func GetInstance(dbDriver, dbConnectionString string, maxConnectionCount int, lo
 	var err error
 	var db DB
 
-	if dbDriver == "pgx" {
+	if dbDriver == "pgx" || dbDriver == "postgres" {
 		db, err = newPgxConn(dbConnectionString, maxConnectionCount, log)
 		if err != nil {
 			return nil, fmt.Errorf("error opening postgresql database with pgx driver: %s", err)
 		}
 	} else if dbDriver == "sqlite3" {
-		db, err = NewSqlite3DB(dbConnectionString, log)
+		opt := DefaultSqliteOptions()
+		opt.MaxReadConns = maxConnectionCount
+		db, err = NewSqlite3DBWithOptions(dbConnectionString, opt, log)
 		if err != nil {
 			return nil, fmt.Errorf("error opening sqlite3 database with sqlite3 driver: %s", err)
 		}
@@ -50,3 +58,26 @@ func GetInstance(dbDriver, dbConnectionString string, maxConnectionCount int, lo
 
 	return db, nil
 }
+
+// GetInstanceFromConfig opens the database described by cfg, typically obtained with GetConfigFromEnv
+func GetInstanceFromConfig(cfg *Config, log golog.MyLogger) (DB, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid database configuration: %w", err)
+	}
+	switch cfg.normalizedDriver() {
+	case "pgx":
+		db, err := newPgxConnFromConfig(cfg, log)
+		if err != nil {
+			return nil, fmt.Errorf("error opening postgresql database with pgx driver: %w", err)
+		}
+		return db, nil
+	case "sqlite3":
+		db, err := NewSqlite3DBWithOptions(cfg.Path, cfg.SqliteOptions(), log)
+		if err != nil {
+			return nil, fmt.Errorf("error opening sqlite3 database with sqlite3 driver: %w", err)
+		}
+		return db, nil
+	default:
+		return nil, errors.New("unsupported DB driver type")
+	}
+}
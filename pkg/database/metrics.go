@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/metrics"
+)
+
+// dynamicQuery is the query label of the queries built at runtime, like the OGC items with their filters
+const dynamicQuery = "dynamic"
+
+var queryDuration = metrics.Default.NewHistogramVec("db_query_duration_seconds",
+	"Duration of the database queries, including the fetch of the rows, by dialect, named query and outcome.",
+	metrics.DefaultBuckets, "dialect", "query", "outcome")
+
+// PoolStats gives the usage of a pool of connections
+type PoolStats struct {
+	Name          string
+	MaxConns      int
+	TotalConns    int
+	AcquiredConns int
+	IdleConns     int
+	Waits         int64         // number of times a connection was not immediately available
+	WaitDuration  time.Duration // total time spent waiting for a connection
+}
+
+// PoolStats returns the usage of the pgxpool
+func (db *PgxDB) PoolStats() []PoolStats {
+	s := db.Conn.Stat()
+	return []PoolStats{{
+		Name:          "postgres",
+		MaxConns:      int(s.MaxConns()),
+		TotalConns:    int(s.TotalConns()),
+		AcquiredConns: int(s.AcquiredConns()),
+		IdleConns:     int(s.IdleConns()),
+		Waits:         s.EmptyAcquireCount(),
+		WaitDuration:  s.AcquireDuration(),
+	}}
+}
+
+// PoolStats returns the usage of the read-only pool and of the writer connection
+func (db *SQLITE3) PoolStats() []PoolStats {
+	res := make([]PoolStats, 0, 2)
+	add := func(name string, s sql.DBStats) {
+		res = append(res, PoolStats{Name: name, MaxConns: s.MaxOpenConnections, TotalConns: s.OpenConnections,
+			AcquiredConns: s.InUse, IdleConns: s.Idle, Waits: s.WaitCount, WaitDuration: s.WaitDuration})
+	}
+	add("sqlite_read", db.Conn.Stats())
+	if writer := db.openedWriter(); writer != nil {
+		add("sqlite_write", writer.Stats())
+	}
+	return res
+}
+
+// observeQueryDuration records one execution of a query in the db_query_duration_seconds histogram
+func observeQueryDuration(name string, dialect Dialect, elapsed time.Duration, err error) {
+	if name == "" {
+		name = dynamicQuery
+	}
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	queryDuration.Observe(elapsed.Seconds(), string(dialect), name, outcome)
+}
+
+// CollectMetrics returns a metrics collector writing the pool usage of db and the longest named queries
+func CollectMetrics(db DB) func(w *metrics.Writer) {
+	return func(w *metrics.Writer) {
+		if p, ok := db.(interface{ PoolStats() []PoolStats }); ok {
+			var maxConns, total, acquired, idle, waits, waitDuration []metrics.Series
+			for _, s := range p.PoolStats() {
+				labels := []string{"pool", s.Name}
+				maxConns = append(maxConns, metrics.Series{Labels: labels, Value: float64(s.MaxConns)})
+				total = append(total, metrics.Series{Labels: labels, Value: float64(s.TotalConns)})
+				acquired = append(acquired, metrics.Series{Labels: labels, Value: float64(s.AcquiredConns)})
+				idle = append(idle, metrics.Series{Labels: labels, Value: float64(s.IdleConns)})
+				waits = append(waits, metrics.Series{Labels: labels, Value: float64(s.Waits)})
+				waitDuration = append(waitDuration, metrics.Series{Labels: labels, Value: s.WaitDuration.Seconds()})
+			}
+			w.GaugeSeries("db_pool_max_connections", "Maximum number of connections of the pool.", maxConns)
+			w.GaugeSeries("db_pool_connections", "Number of connections currently open in the pool.", total)
+			w.GaugeSeries("db_pool_acquired_connections", "Number of connections currently in use.", acquired)
+			w.GaugeSeries("db_pool_idle_connections", "Number of idle connections in the pool.", idle)
+			w.CounterSeries("db_pool_waits_total", "Number of times no connection was immediately available.", waits)
+			w.CounterSeries("db_pool_wait_duration_seconds_total",
+				"Total time spent acquiring a connection, for sqlite only the time waiting for a busy pool.", waitDuration)
+		}
+		// the counts and the errors of the named queries are in db_query_duration_seconds
+		var maxDuration []metrics.Series
+		for _, stat := range GetNamedQueryStats() {
+			if stat.Dialect == db.Dialect() {
+				maxDuration = append(maxDuration, metrics.Series{Labels: []string{"dialect", string(stat.Dialect), "query", stat.Name}, Value: stat.MaxDuration.Seconds()})
+			}
+		}
+		w.GaugeSeries("db_named_query_max_duration_seconds", "Longest execution of each named query since the start.", maxDuration)
+	}
+}
@@ -0,0 +1,35 @@
+package database
+
+import "fmt"
+
+// Params collects the arguments of a query built at runtime and returns their placeholders in the sql dialect,
+// so that user values are always bound and never interpolated in the sql text
+type Params struct {
+	dialect Dialect
+	values  []interface{}
+}
+
+// NewParams returns an empty list of arguments for dialect
+func NewParams(dialect Dialect) *Params {
+	return &Params{dialect: dialect}
+}
+
+// Add appends value to the arguments and returns its placeholder, $n for postgres and ? for sqlite.
+// Since ? placeholders are positional, Add must be called in the order the placeholders appear in the sql text.
+func (p *Params) Add(value interface{}) string {
+	p.values = append(p.values, value)
+	if p.dialect == DialectPostgres {
+		return fmt.Sprintf("$%d", len(p.values))
+	}
+	return "?"
+}
+
+// Values returns the arguments to give to the query, in the order of their placeholders
+func (p *Params) Values() []interface{} {
+	return p.values
+}
+
+// Dialect returns the sql dialect of the placeholders
+func (p *Params) Dialect() Dialect {
+	return p.dialect
+}
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"time"
 )
 
 const getPGVersion = "SELECT version();"
 const getPostgisVersion = "SELECT PostGIS_full_version();"
+const getPostgisExists = "SELECT EXISTS(SELECT 1 FROM pg_extension WHERE extname = 'postgis') as exists;"
+const getGeometryTypeOid = "SELECT oid FROM pg_type WHERE typname = 'geometry' LIMIT 1;"
 const getTableExists = "SELECT EXISTS(SELECT FROM information_schema.tables WHERE  table_schema = $1 AND table_name = $2) as exists;"
 
 type PgxDB struct {
@@ -19,34 +23,66 @@ type PgxDB struct {
 }
 
 func newPgxConn(dbConnectionString string, maxConnectionsInPool int, log golog.MyLogger) (DB, error) {
-	var psql PgxDB
-	var parsedConfig *pgx.ConnConfig
-	var err error
-	parsedConfig, err = pgx.ParseConfig(dbConnectionString)
+	poolConfig, err := pgxpool.ParseConfig(dbConnectionString)
 	if err != nil {
-		return nil, errors.New(fmt.Sprintf("error doing pgx.ParseConfig(%s). err: %s", dbConnectionString, err))
+		// the error returned by ParseConfig already has the password redacted
+		return nil, errors.New(fmt.Sprintf("error doing pgxpool.ParseConfig. err: %s", err))
 	}
+	// keep all the options given in the connection string (sslmode, sslrootcert, ...) only adjust the pool size
+	poolConfig.MaxConns = int32(maxConnectionsInPool)
+	return newPgxPool(poolConfig, log)
+}
 
-	dbHost := parsedConfig.Host
-	dbPort := parsedConfig.Port
-	dbUser := parsedConfig.User
-	dbPass := parsedConfig.Password
-	dbName := parsedConfig.Database
+// newPgxConnFromConfig opens a pgx pool using the connection and pool settings from cfg
+func newPgxConnFromConfig(cfg *Config, log golog.MyLogger) (DB, error) {
+	poolConfig, err := cfg.PoolConfig()
+	if err != nil {
+		return nil, err
+	}
+	return newPgxPool(poolConfig, log)
+}
 
-	dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s pool_max_conns=%d", dbHost, dbPort, dbUser, dbPass, dbName, maxConnectionsInPool)
+// registerGeometryType makes pgx exchange the PostGIS geometry type in binary EWKB, so geometry columns
+// can be scanned into a geom.Value and geom.Value can be given as a geometry parameter
+func registerGeometryType(ctx context.Context, conn *pgx.Conn, log golog.MyLogger) {
+	var geometryOid uint32
+	err := conn.QueryRow(ctx, getGeometryTypeOid).Scan(&geometryOid)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Error("registerGeometryType could not find the geometry type oid : %v", err)
+		}
+		return
+	}
+	conn.TypeMap().RegisterType(&pgtype.Type{Name: "geometry", OID: geometryOid, Codec: pgtype.ByteaCodec{}})
+}
 
-	connPool, err := pgxpool.New(context.Background(), dsn)
+func newPgxPool(poolConfig *pgxpool.Config, log golog.MyLogger) (DB, error) {
+	var psql PgxDB
+	dbName := poolConfig.ConnConfig.Database
+	dbUser := poolConfig.ConnConfig.User
+	poolConfig.AfterConnect = func(ctx context.Context, conn *pgx.Conn) error {
+		registerGeometryType(ctx, conn, log)
+		// prepare all the named queries on each new connection of the pool
+		for name, sqlText := range getNamedQueriesForDialect(DialectPostgres) {
+			if _, err := conn.Prepare(ctx, name, sqlText); err != nil {
+				log.Error("AfterConnect could not prepare named query %s : %v", name, err)
+			}
+		}
+		return nil
+	}
+	connPool, err := pgxpool.NewWithConfig(context.Background(), poolConfig)
 	if err != nil {
 		log.Error("FAILED to connect to database %s with user %s", dbName, dbUser)
 		return nil, errors.New(fmt.Sprintf("error connecting to database. err : %s", err))
 	} else {
-		log.Info("SUCCESS connecting to database %s with user %s", dbName, dbUser)
+		log.Info("SUCCESS connecting to database %s with user %s (tls: %v, pool max conns: %d)",
+			dbName, dbUser, poolConfig.ConnConfig.TLSConfig != nil, poolConfig.MaxConns)
 		// let's first check that we can really make a query by querying the postgres version
 		var version string
 		errPing := connPool.QueryRow(context.Background(), getPGVersion).Scan(&version)
 		if errPing != nil {
-			log.Info("something very weird is occurring here... this db connection is probably invalid ! ")
-			log.Fatal("got db error retrieving postgres version with : [%s] error: %s", getPGVersion, errPing)
+			log.Error("got db error retrieving postgres version with : [%s] error: %s", getPGVersion, errPing)
+			connPool.Close()
 			return nil, errPing
 		}
 
@@ -166,9 +202,9 @@ func (db *PgxDB) Close() {
 	return
 }
 func (db *PgxDB) IsItSpatial() bool {
-	postgisExists, err := db.GetQueryBool(getPostgisVersion)
+	postgisExists, err := db.GetQueryBool(getPostgisExists)
 	if err != nil {
-		db.log.Error(" DoesTableExist() GetQueryBool returned error:%v \n", err)
+		db.log.Error(" IsItSpatial() GetQueryBool returned error:%v \n", err)
 		return false
 	}
 	return postgisExists
@@ -187,3 +223,80 @@ func (db *PgxDB) GetQueryStringArr(sql string, arguments ...any) (result []strin
 
 	return result, nil
 }
+
+// Ping checks that a connection of the pool can reach the server
+func (db *PgxDB) Ping(ctx context.Context) error {
+	return db.Conn.Ping(ctx)
+}
+
+// Dialect returns the sql dialect understood by this backend
+func (db *PgxDB) Dialect() Dialect {
+	return DialectPostgres
+}
+
+// acquirePrepared returns a connection from the pool where the named query is prepared
+func (db *PgxDB) acquirePrepared(ctx context.Context, name string) (*pgxpool.Conn, error) {
+	sqlText, err := getNamedQuerySql(name, DialectPostgres)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := db.Conn.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Prepare is idempotent, it only goes to the server when the query was registered after the connection was opened
+	if _, err := conn.Conn().Prepare(ctx, name, sqlText); err != nil {
+		conn.Release()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// QueryNamed runs the named query using the statement prepared on the pooled connection
+func (db *PgxDB) QueryNamed(ctx context.Context, name string, arguments ...interface{}) (Rows, error) {
+	start := time.Now()
+	conn, err := db.acquirePrepared(ctx, name)
+	if err != nil {
+		db.log.Error("QueryNamed(%s) could not get a prepared statement. error : %v", name, err)
+		observeNamedQuery(name, DialectPostgres, start, err)
+		return nil, err
+	}
+	rows, err := conn.Query(ctx, name, arguments...)
+	if err != nil {
+		conn.Release()
+		db.log.Error("QueryNamed(%s) unexpectedly failed. args : (%v), error : %v", name, arguments, err)
+		observeNamedQuery(name, DialectPostgres, start, err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, name: name, dialect: DialectPostgres, start: start, onClose: conn.Release}, nil
+}
+
+// Query runs a query built at runtime, user values must be given as arguments (see Params)
+func (db *PgxDB) Query(ctx context.Context, sql string, arguments ...interface{}) (Rows, error) {
+	start := time.Now()
+	rows, err := db.Conn.Query(ctx, sql, arguments...)
+	if err != nil {
+		db.log.Error("Query unexpectedly failed with %v. args : (%v), error : %v", sql, arguments, err)
+		observeNamedQuery("", DialectPostgres, start, err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, dialect: DialectPostgres, start: start}, nil
+}
+
+// ExecNamed runs the named action query using the statement prepared on the pooled connection
+func (db *PgxDB) ExecNamed(ctx context.Context, name string, arguments ...interface{}) (rowsAffected int, err error) {
+	start := time.Now()
+	defer func() { observeNamedQuery(name, DialectPostgres, start, err) }()
+	conn, err := db.acquirePrepared(ctx, name)
+	if err != nil {
+		db.log.Error("ExecNamed(%s) could not get a prepared statement. error : %v", name, err)
+		return 0, err
+	}
+	defer conn.Release()
+	commandTag, err := conn.Exec(ctx, name, arguments...)
+	if err != nil {
+		db.log.Error("ExecNamed(%s) unexpectedly failed. args : (%v), error : %v", name, arguments, err)
+		return 0, err
+	}
+	return int(commandTag.RowsAffected()), nil
+}
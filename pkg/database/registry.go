@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Dialect identifies the sql flavour understood by a DB backend
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "pgx"
+	DialectSqlite   Dialect = "sqlite3"
+)
+
+var (
+	ErrUnknownNamedQuery = errors.New("named query was not registered")
+	ErrNoSqlForDialect   = errors.New("named query has no sql for this dialect")
+	namedQueries         = &queryRegistry{queries: map[string]*namedQuery{}}
+)
+
+// Rows is the subset of pgx.Rows and sql.Rows returned by QueryNamed, Close must always be called
+type Rows interface {
+	Next() bool
+	Scan(dest ...interface{}) error
+	Err() error
+	Close()
+}
+
+// NamedQueryStat holds the timing metrics of one named query since the start of the program
+type NamedQueryStat struct {
+	Name          string        `json:"name"`
+	Dialect       Dialect       `json:"dialect"`
+	Count         uint64        `json:"count"`
+	Errors        uint64        `json:"errors"`
+	TotalDuration time.Duration `json:"total_duration"`
+	MaxDuration   time.Duration `json:"max_duration"`
+}
+
+// queryStat holds the metrics of a named query in one dialect, updated with atomics on each execution
+// so the queries do not contend on the registry lock
+type queryStat struct {
+	count         atomic.Uint64
+	errors        atomic.Uint64
+	totalDuration atomic.Int64
+	maxDuration   atomic.Int64
+}
+
+func (qs *queryStat) observe(elapsed time.Duration, err error) {
+	qs.count.Add(1)
+	if err != nil {
+		qs.errors.Add(1)
+	}
+	qs.totalDuration.Add(int64(elapsed))
+	for {
+		current := qs.maxDuration.Load()
+		if int64(elapsed) <= current || qs.maxDuration.CompareAndSwap(current, int64(elapsed)) {
+			return
+		}
+	}
+}
+
+type namedQuery struct {
+	name  string
+	sql   map[Dialect]string
+	stats map[Dialect]*queryStat // its keys never change after the registration
+}
+
+type queryRegistry struct {
+	mu      sync.RWMutex
+	queries map[string]*namedQuery
+}
+
+// RegisterNamedQuery declares once a query by name with its sql text for each supported dialect.
+// Postgres queries use $1 placeholders and sqlite ones use ?, a dialect can be omitted if it is not supported.
+// Queries should be registered at init time, before the DB is opened, so they can be prepared on each new connection.
+// Registering again a name with the same sql does nothing, like the queries of a second tiles.Server on the same database.
+func RegisterNamedQuery(name string, sqlByDialect map[Dialect]string) error {
+	if name == "" || len(sqlByDialect) == 0 {
+		return errors.New("a named query needs a name and at least one sql dialect")
+	}
+	namedQueries.mu.Lock()
+	defer namedQueries.mu.Unlock()
+	if q, exist := namedQueries.queries[name]; exist {
+		if maps.Equal(q.sql, sqlByDialect) {
+			return nil
+		}
+		return fmt.Errorf("named query %s is already registered with another sql", name)
+	}
+	q := &namedQuery{name: name, sql: map[Dialect]string{}, stats: map[Dialect]*queryStat{}}
+	for dialect, sqlText := range sqlByDialect {
+		q.sql[dialect] = sqlText
+		q.stats[dialect] = &queryStat{}
+	}
+	namedQueries.queries[name] = q
+	return nil
+}
+
+// MustRegisterNamedQuery is like RegisterNamedQuery but panics on error, to be used in package level var or init()
+func MustRegisterNamedQuery(name string, sqlByDialect map[Dialect]string) string {
+	if err := RegisterNamedQuery(name, sqlByDialect); err != nil {
+		panic(err)
+	}
+	return name
+}
+
+// getNamedQuerySql returns the sql text of the named query for dialect
+func getNamedQuerySql(name string, dialect Dialect) (string, error) {
+	namedQueries.mu.RLock()
+	defer namedQueries.mu.RUnlock()
+	q, exist := namedQueries.queries[name]
+	if !exist {
+		return "", fmt.Errorf("%w: %s", ErrUnknownNamedQuery, name)
+	}
+	sqlText, exist := q.sql[dialect]
+	if !exist {
+		return "", fmt.Errorf("%w: %s (%s)", ErrNoSqlForDialect, name, dialect)
+	}
+	return sqlText, nil
+}
+
+// getNamedQueriesForDialect returns a map name->sql of all the queries available in dialect
+func getNamedQueriesForDialect(dialect Dialect) map[string]string {
+	namedQueries.mu.RLock()
+	defer namedQueries.mu.RUnlock()
+	res := make(map[string]string, len(namedQueries.queries))
+	for name, q := range namedQueries.queries {
+		if sqlText, exist := q.sql[dialect]; exist {
+			res[name] = sqlText
+		}
+	}
+	return res
+}
+
+// observeNamedQuery records the duration and the outcome of one execution of a named query,
+// an empty name is a query built at runtime only counted in the metrics
+func observeNamedQuery(name string, dialect Dialect, start time.Time, err error) {
+	elapsed := time.Since(start)
+	observeQueryDuration(name, dialect, elapsed, err)
+	if name == "" {
+		return
+	}
+	namedQueries.mu.RLock()
+	q, exist := namedQueries.queries[name]
+	namedQueries.mu.RUnlock()
+	if !exist {
+		return
+	}
+	if stat := q.stats[dialect]; stat != nil {
+		stat.observe(elapsed, err)
+	}
+}
+
+// GetNamedQueryStats returns a snapshot of the timing metrics of every named query, sorted by name and dialect
+func GetNamedQueryStats() []NamedQueryStat {
+	namedQueries.mu.RLock()
+	defer namedQueries.mu.RUnlock()
+	res := make([]NamedQueryStat, 0, len(namedQueries.queries))
+	for _, q := range namedQueries.queries {
+		for dialect, stat := range q.stats {
+			res = append(res, NamedQueryStat{Name: q.name, Dialect: dialect, Count: stat.count.Load(), Errors: stat.errors.Load(),
+				TotalDuration: time.Duration(stat.totalDuration.Load()), MaxDuration: time.Duration(stat.maxDuration.Load())})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name == res[j].Name {
+			return res[i].Dialect < res[j].Dialect
+		}
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// timedRows wraps the rows of a query to record its duration, including the fetch, when it is closed
+type timedRows struct {
+	Rows
+	name    string
+	dialect Dialect
+	start   time.Time
+	onClose func()
+	once    sync.Once
+}
+
+func (r *timedRows) Close() {
+	r.once.Do(func() {
+		r.Rows.Close()
+		observeNamedQuery(r.name, r.dialect, r.start, r.Rows.Err())
+		if r.onClose != nil {
+			r.onClose()
+		}
+	})
+}
+
+// NamedQueryRow runs the named query and scans its first row into dest, returning ErrNoRecordFound if there is none
+func NamedQueryRow(ctx context.Context, db DB, name string, dest []interface{}, arguments ...interface{}) error {
+	rows, err := db.QueryNamed(ctx, name, arguments...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		return ErrNoRecordFound
+	}
+	return rows.Scan(dest...)
+}
@@ -1,68 +1,316 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geopackage"
 	"github.com/mattn/go-sqlite3"
+	"net/url"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 const getSqliteVersion = "SELECT sqlite_version();"
 const getSpatialiteVersion = "SELECT spatialite_version();"
-const getSqliteTableExists = "SELECT name FROM sqlite_master WHERE type='table';"
+const getSqliteTableExists = "SELECT count(*) as number FROM sqlite_master WHERE type='table' AND name = ?;"
+const getSqliteJournalMode = "PRAGMA journal_mode;"
+const sqliteDriverName = "sqlite3_with_spatialite"
+const sqlitePlainDriverName = "sqlite3_with_gpkg_functions" // used when mod_spatialite cannot be loaded
 
-// SQLITE3 is a struct to hold the connection to a sqlite3 database
+const (
+	defaultSqliteBusyTimeout   = 5 * time.Second
+	defaultSqliteMaxReadConns  = 4
+	defaultSqliteSynchronous   = "NORMAL"
+	defaultSqliteJournalMode   = "WAL" // readers are not blocked by the writer
+	defaultSqliteConnsLifetime = time.Hour
+)
+
+var (
+	ErrReadOnlyDB            = errors.New("this sqlite3 database was opened read-only")
+	sqliteJournalModes       = []string{"DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF"}
+	registerSqliteDriverOnce sync.Once
+)
+
+// SqliteOptions allows to tune the way a sqlite3 database is opened
+type SqliteOptions struct {
+	MaxReadConns int           // number of connections in the read-only pool used by all the Get* queries
+	ReadOnly     bool          // when true no writer connection is opened and ExecActionQuery returns ErrReadOnlyDB
+	Immutable    bool          // the file will never change (shipped GeoPackage), sqlite can skip all locking. implies ReadOnly
+	Create       bool          // the file is created when it does not exist, else opening a missing file fails
+	BusyTimeout  time.Duration // how long a connection waits on a lock before returning SQLITE_BUSY
+	// JournalMode is set by the writer, WAL by default unless ReadOnly, which allows readers to run concurrently with the writer
+	JournalMode string
+}
+
+// DefaultSqliteOptions returns the options used by NewSqlite3DB
+func DefaultSqliteOptions() SqliteOptions {
+	return SqliteOptions{
+		MaxReadConns: defaultSqliteMaxReadConns,
+		BusyTimeout:  defaultSqliteBusyTimeout,
+	}
+}
+
+// SQLITE3 is a struct to hold the connections to a sqlite3 database.
+// All the queries go through Conn, a pool of read-only connections that can run in parallel,
+// and the action queries go through a separate pool limited to one connection, which is the only writer.
 type SQLITE3 struct {
-	Conn *sql.DB
-	lck  sync.RWMutex // https://godoc.org/github.com/mxk/go-sqlite/sqlite3#hdr-Concurrency
-	log  golog.MyLogger
+	Conn       *sql.DB // read-only connections pool
+	path       string
+	driverName string
+	opt        SqliteOptions
+	writerMu   sync.Mutex
+	writer     *sql.DB // single read-write connection, nil when the database is read-only
+	spatialite bool    // false when mod_spatialite could not be loaded, geometries must then be decoded in go
+	log        golog.MyLogger
+	stmtsMu    sync.Mutex
+	readStmts  map[string]*sql.Stmt // named queries prepared on the read-only pool
+	writeStmts map[string]*sql.Stmt // named queries prepared on the writer
 }
 
-func NewSqlite3DB(geopackageFilePath string, log golog.MyLogger) (DB, error) {
-	var successOrFailure = "OK"
-	sql.Register("sqlite3_with_spatialite", &sqlite3.SQLiteDriver{
-		Extensions: []string{"mod_spatialite"},
-	})
+// sqliteDSN builds a go-sqlite3 uri connection string for geopackageFilePath with the given parameters
+func sqliteDSN(geopackageFilePath string, params url.Values) string {
+	// only the characters with a special meaning in an uri need to be escaped, sqlite decodes %HH
+	escaper := strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23")
+	return fmt.Sprintf("file:%s?%s", escaper.Replace(geopackageFilePath), params.Encode())
+}
 
-	// Load the geopackage
+// NewSqlite3DB opens the sqlite3 database at geopackageFilePath with the DefaultSqliteOptions
+func NewSqlite3DB(geopackageFilePath string, log golog.MyLogger) (DB, error) {
+	return NewSqlite3DBWithOptions(geopackageFilePath, DefaultSqliteOptions(), log)
+}
 
+// NewSqlite3DBWithOptions opens the sqlite3 database at geopackageFilePath with a read-only pool of opt.MaxReadConns
+// connections and, unless opt.ReadOnly or opt.Immutable, a single writer connection setting the journal mode,
+// WAL by default. A file on a read-only volume must be opened with opt.ReadOnly.
+func NewSqlite3DBWithOptions(geopackageFilePath string, opt SqliteOptions, log golog.MyLogger) (DB, error) {
+	registerSqliteDriverOnce.Do(func() {
+		sql.Register(sqliteDriverName, &sqlite3.SQLiteDriver{
+			Extensions:  []string{"mod_spatialite"},
+			ConnectHook: registerEnvelopeFunc,
+		})
+		sql.Register(sqlitePlainDriverName, &sqlite3.SQLiteDriver{
+			ConnectHook: registerGpkgFunctions,
+		})
+	})
+	if opt.MaxReadConns < 1 {
+		opt.MaxReadConns = defaultSqliteMaxReadConns
+	}
+	if opt.BusyTimeout <= 0 {
+		opt.BusyTimeout = defaultSqliteBusyTimeout
+	}
+	if opt.Immutable {
+		opt.ReadOnly = true
+	}
+	opt.JournalMode = strings.ToUpper(strings.TrimSpace(opt.JournalMode))
+	if opt.JournalMode == "" && !opt.ReadOnly {
+		opt.JournalMode = defaultSqliteJournalMode
+	}
+	if opt.JournalMode != "" && !slices.Contains(sqliteJournalModes, opt.JournalMode) {
+		return nil, fmt.Errorf("sqlite3 journal mode should be one of %s, got '%s'", strings.Join(sqliteJournalModes, ", "), opt.JournalMode)
+	}
+	if opt.ReadOnly && (opt.Create || opt.JournalMode != "") {
+		return nil, errors.New("a read-only sqlite3 database cannot be created or change its journal mode")
+	}
+	db := &SQLITE3{path: geopackageFilePath, opt: opt, log: log, readStmts: map[string]*sql.Stmt{}, writeStmts: map[string]*sql.Stmt{}}
 	log.Info("--------------->>NewSqlite3DB--------------")
-	db, err := sql.Open("sqlite3_with_spatialite", geopackageFilePath)
-	if err != nil {
-		successOrFailure = "FAILED"
-		log.Info("Connecting to Sqlite3 database '%s' : %s ", geopackageFilePath, successOrFailure)
-		log.Fatal("💥 ERROR TRYING DB CONNECTION : %v ", err)
-	} else {
-		log.Info("Connecting to Sqlite3 database '%s' : %s", geopackageFilePath, successOrFailure)
-		log.Info("Fetching one record to test if db connection is valid...")
-		var version string
-		getSqlite3Version := "SELECT sqlite_version();"
-		if errPing := db.QueryRow(getSqlite3Version).Scan(&version); errPing != nil {
-			log.Error("Connection is invalid ! ")
-			log.Fatal("DB ERROR scanning row: %s", errPing)
+	driverName := db.chooseDriver()
+	db.driverName = driverName
+
+	// the writer is opened first, so the file exists and is in its journal mode when the readers connect
+	if opt.Create || opt.JournalMode != "" {
+		if _, err := db.getWriter(); err != nil {
+			return nil, err
 		}
-		log.Info("SUCCESS Connecting to Sqlite3 version : [%s]", version)
 	}
+
+	readerParams := url.Values{}
+	readerParams.Set("mode", "ro")
+	readerParams.Set("_busy_timeout", fmt.Sprintf("%d", opt.BusyTimeout.Milliseconds()))
+	readerParams.Set("_query_only", "1")
+	if opt.Immutable {
+		readerParams.Set("immutable", "1")
+	}
+	reader, err := sql.Open(driverName, sqliteDSN(geopackageFilePath, readerParams))
+	if err != nil {
+		db.Close()
+		log.Error("Connecting to Sqlite3 database '%s' : FAILED", geopackageFilePath)
+		return nil, fmt.Errorf("error opening sqlite3 read-only connections: %w", err)
+	}
+	reader.SetMaxOpenConns(opt.MaxReadConns)
+	reader.SetMaxIdleConns(opt.MaxReadConns)
+	reader.SetConnMaxLifetime(defaultSqliteConnsLifetime)
+	db.Conn = reader
+
+	log.Info("Connecting to Sqlite3 database '%s' : OK (read conns: %d, read-only: %v, immutable: %v)",
+		geopackageFilePath, opt.MaxReadConns, opt.ReadOnly, opt.Immutable)
+	log.Info("Fetching one record to test if db connection is valid...")
+	var version string
+	if errPing := reader.QueryRow(getSqliteVersion).Scan(&version); errPing != nil {
+		db.Close()
+		log.Error("Connection is invalid ! ")
+		return nil, fmt.Errorf("error checking sqlite3 read-only connection: %w", errPing)
+	}
+	log.Info("SUCCESS Connecting to Sqlite3 version : [%s]", version)
 	log.Info("---------------NewSqlite3DB>>--------------")
 
-	return &SQLITE3{
-		Conn: db,
-		lck:  sync.RWMutex{},
-	}, err
+	return db, nil
 }
 
-func (db *SQLITE3) Close() {
-	err := db.Conn.Close()
+// getWriter returns the single writer connection, opening it the first time with mode=rw, or rwc with opt.Create,
+// a failed opening is retried by the next write
+func (db *SQLITE3) getWriter() (*sql.DB, error) {
+	if db.opt.ReadOnly {
+		return nil, ErrReadOnlyDB
+	}
+	db.writerMu.Lock()
+	defer db.writerMu.Unlock()
+	if db.writer != nil {
+		return db.writer, nil
+	}
+	writerParams := url.Values{}
+	writerParams.Set("mode", "rw")
+	if db.opt.Create {
+		writerParams.Set("mode", "rwc")
+	}
+	writerParams.Set("_busy_timeout", fmt.Sprintf("%d", db.opt.BusyTimeout.Milliseconds()))
+	writerParams.Set("_txlock", "immediate")
+	if db.opt.JournalMode != "" {
+		writerParams.Set("_journal_mode", db.opt.JournalMode)
+		writerParams.Set("_synchronous", defaultSqliteSynchronous)
+	}
+	writer, err := sql.Open(db.driverName, sqliteDSN(db.path, writerParams))
+	if err != nil {
+		db.log.Error("Connecting writer to Sqlite3 database '%s' : FAILED", db.path)
+		return nil, fmt.Errorf("error opening sqlite3 writer connection: %w", err)
+	}
+	// sqlite allows only one writer at a time, more connections would only wait on SQLITE_BUSY
+	writer.SetMaxOpenConns(1)
+	writer.SetMaxIdleConns(1)
+	writer.SetConnMaxLifetime(0)
+	var journalMode string
+	if errPing := writer.QueryRow(getSqliteJournalMode).Scan(&journalMode); errPing != nil {
+		_ = writer.Close()
+		db.log.Error("Writer connection is invalid ! ")
+		return nil, fmt.Errorf("error checking sqlite3 writer connection: %w", errPing)
+	}
+	db.log.Info("Connecting writer to Sqlite3 database '%s' : OK, journal mode : %s", db.path, journalMode)
+	db.writer = writer
+	return writer, nil
+}
+
+// openedWriter returns the writer connection if it was already opened, without opening it
+func (db *SQLITE3) openedWriter() *sql.DB {
+	db.writerMu.Lock()
+	defer db.writerMu.Unlock()
+	return db.writer
+}
+
+// chooseDriver returns the driver loading mod_spatialite when the extension is available on this node,
+// or else the plain sqlite3 driver, the GeoPackage can still be read with the pkg/geopackage decoder
+func (db *SQLITE3) chooseDriver() string {
+	// extensions are loaded when a connection is opened, an in memory database is enough to know
+	probe, err := sql.Open(sqliteDriverName, ":memory:")
+	if err == nil {
+		err = probe.Ping()
+		_ = probe.Close()
+	}
 	if err != nil {
-		db.log.Error("problem doing db.Conn.Close(): %v", err)
+		db.log.Warn("mod_spatialite could not be loaded, continuing without spatial sql functions : %v", err)
+		db.spatialite = false
+		return sqlitePlainDriverName
+	}
+	db.spatialite = true
+	return sqliteDriverName
+}
+
+// GpkgEnvelopeIntersects is the sql function GpkgEnvelopeIntersects(geometry, minx, miny, maxx, maxy) available on
+// every sqlite3 connection, true when the envelope of a GeoPackage geometry intersects the given one
+const GpkgEnvelopeIntersects = "GpkgEnvelopeIntersects"
+
+// registerEnvelopeFunc adds GpkgEnvelopeIntersects to a connection, to filter by bbox the tables without rtree
+func registerEnvelopeFunc(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc(GpkgEnvelopeIntersects, func(blob interface{}, minX, minY, maxX, maxY float64) bool {
+		b, isBlob := blob.([]byte)
+		return isBlob && geopackage.EnvelopeIntersectsFunc(b, minX, minY, maxX, maxY)
+	}, true)
+}
+
+// registerGpkgFunctions adds to a connection without mod_spatialite the functions used by the GeoPackage rtree triggers,
+// so a GeoPackage with spatial indexes stays writable on any node
+func registerGpkgFunctions(conn *sqlite3.SQLiteConn) error {
+	if err := registerEnvelopeFunc(conn); err != nil {
+		return err
+	}
+	for _, name := range []string{"ST_MinX", "ST_MaxX", "ST_MinY", "ST_MaxY"} {
+		name := name
+		err := conn.RegisterFunc(name, func(blob interface{}) interface{} {
+			b, isBlob := blob.([]byte)
+			if !isBlob {
+				return nil
+			}
+			if v, ok := geopackage.EnvelopeFunc(name, b); ok {
+				return v
+			}
+			return nil
+		}, true)
+		if err != nil {
+			return err
+		}
+	}
+	return conn.RegisterFunc("ST_IsEmpty", func(blob interface{}) interface{} {
+		b, isBlob := blob.([]byte)
+		if !isBlob {
+			return nil
+		}
+		return geopackage.IsEmptyFunc(b)
+	}, true)
+}
+
+// HasSpatialite returns true when the spatialite sql functions are available on the connections
+func (db *SQLITE3) HasSpatialite() bool {
+	return db.spatialite
+}
+
+func (db *SQLITE3) Close() {
+	db.stmtsMu.Lock()
+	for _, stmts := range []map[string]*sql.Stmt{db.readStmts, db.writeStmts} {
+		for name, stmt := range stmts {
+			if err := stmt.Close(); err != nil {
+				db.log.Error("problem closing prepared statement %s: %v", name, err)
+			}
+			delete(stmts, name)
+		}
+	}
+	db.stmtsMu.Unlock()
+	db.writerMu.Lock()
+	if db.writer != nil {
+		if err := db.writer.Close(); err != nil {
+			db.log.Error("problem doing db.writer.Close(): %v", err)
+		}
+		db.writer = nil
+	}
+	db.writerMu.Unlock()
+	if db.Conn != nil {
+		if err := db.Conn.Close(); err != nil {
+			db.log.Error("problem doing db.Conn.Close(): %v", err)
+		}
 	}
 	return
 }
 
+// ExecActionQuery runs an action query on the single writer connection, returning the numbers of rows affected
 func (db *SQLITE3) ExecActionQuery(sql string, arguments ...interface{}) (rowsAffected int, err error) {
-	db.lck.Lock()
-	defer db.lck.Unlock()
-	res, err := db.Conn.Exec(sql, arguments...)
+	writer, err := db.getWriter()
+	if err != nil {
+		db.log.Error("ExecActionQuery(%v) has no writer connection : %v", sql, err)
+		return 0, err
+	}
+	res, err := writer.Exec(sql, arguments...)
 	if err != nil {
 		db.log.Error("Exec unexpectedly failed with %v: %v", sql, err)
 		return 0, err
@@ -76,9 +324,27 @@ func (db *SQLITE3) ExecActionQuery(sql string, arguments ...interface{}) (rowsAf
 	return int(rowsAff), err
 }
 
+// WithTransaction runs fn in a transaction on the writer connection, committed when fn returns nil and rolled back otherwise.
+// It is meant for bulk loads like the GeoPackage export, where one transaction per row would be far too slow.
+func (db *SQLITE3) WithTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	writer, err := db.getWriter()
+	if err != nil {
+		return err
+	}
+	tx, err := writer.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning sqlite3 transaction: %w", err)
+	}
+	if err := fn(tx); err != nil {
+		if errRollback := tx.Rollback(); errRollback != nil {
+			db.log.Error("problem doing tx.Rollback(): %v", errRollback)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
 func (db *SQLITE3) GetQueryInt(sql string, arguments ...interface{}) (result int, err error) {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
 	err = db.Conn.QueryRow(sql, arguments...).Scan(&result)
 	if err != nil {
 		db.log.Error("GetQueryInt(%s) queryRow unexpectedly failed. args : (%v), error : %v\n", sql, arguments, err)
@@ -88,8 +354,6 @@ func (db *SQLITE3) GetQueryInt(sql string, arguments ...interface{}) (result int
 }
 
 func (db *SQLITE3) GetQueryBool(sql string, arguments ...interface{}) (result bool, err error) {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
 	err = db.Conn.QueryRow(sql, arguments...).Scan(&result)
 	if err != nil {
 		db.log.Error("GetQueryBool(%s) queryRow unexpectedly failed. args : (%v), error : %v\n", sql, arguments, err)
@@ -99,8 +363,6 @@ func (db *SQLITE3) GetQueryBool(sql string, arguments ...interface{}) (result bo
 }
 
 func (db *SQLITE3) GetQueryString(sql string, arguments ...interface{}) (result string, err error) {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
 	err = db.Conn.QueryRow(sql, arguments...).Scan(&result)
 	if err != nil {
 		db.log.Error("GetQueryString(%s) queryRow unexpectedly failed. args : (%v), error : %v\n", sql, arguments, err)
@@ -110,8 +372,6 @@ func (db *SQLITE3) GetQueryString(sql string, arguments ...interface{}) (result
 }
 
 func (db *SQLITE3) GetQueryStringArr(sql string, arguments ...interface{}) (result []string, err error) {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
 	rows, err := db.Conn.Query(sql, arguments...)
 	if err != nil {
 		db.log.Error(" GetQueryString(%s) query unexpectedly failed. args : (%v), error : %v\n", sql, arguments, err)
@@ -128,25 +388,21 @@ func (db *SQLITE3) GetQueryStringArr(sql string, arguments ...interface{}) (resu
 		result = append(result, val)
 
 	}
-	return result, nil
+	return result, rows.Err()
 }
 
 func (db *SQLITE3) IsItSpatial() bool {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
 	// check if the geopackage is valid
-	number, err := db.GetQueryInt("SELECT count(*) as number FROM sqlite_master WHERE type='table' AND name = 'gpkg_geometry_columns';")
-	if err != nil {
-		db.log.Error("IsItSpatial() query unexpectedly failed. error : %v\n", err)
-		return false
-	}
-	return number > 0
+	return db.DoesTableExist("", "gpkg_geometry_columns")
 }
 
 func (db *SQLITE3) GetSpatialVersion() (result string, err error) {
+	if !db.spatialite {
+		return "", errors.New("mod_spatialite is not loaded")
+	}
 	spatialiteVersion, err := db.GetQueryString(getSpatialiteVersion)
 	if err != nil {
-		db.log.Fatal("💥 ERROR: 'calling GetQueryString(SELECT spatialite_version())': %v", err)
+		db.log.Error("💥 ERROR: 'calling GetQueryString(SELECT spatialite_version())': %v", err)
 		return "", err
 	}
 	return spatialiteVersion, err
@@ -155,20 +411,122 @@ func (db *SQLITE3) GetSpatialVersion() (result string, err error) {
 func (db *SQLITE3) GetVersion() (result string, err error) {
 	sqliteVersion, err := db.GetQueryString(getSqliteVersion)
 	if err != nil {
-		db.log.Fatal("💥 ERROR: 'calling GetQueryString(%s)': %v", getSqliteVersion, err)
+		db.log.Error("💥 ERROR: 'calling GetQueryString(%s)': %v", getSqliteVersion, err)
 		return "", err
 	}
 	return sqliteVersion, err
 }
 
+// DoesTableExist returns true if table exists, schema is ignored because sqlite has only one
 func (db *SQLITE3) DoesTableExist(schema, table string) (exist bool) {
-	db.lck.RLock()
-	defer db.lck.RUnlock()
-	// check if the geopackage is valid
-	number, err := db.GetQueryInt("SELECT count(*) as number FROM sqlite_master WHERE type='table' AND name = '%s';", table)
+	number, err := db.GetQueryInt(getSqliteTableExists, table)
 	if err != nil {
 		db.log.Error("DoesTableExist() query unexpectedly failed. error : %v\n", err)
 		return false
 	}
 	return number > 0
 }
+
+// Ping checks that the read-only pool, and the writer if it was opened, can still use the database file
+func (db *SQLITE3) Ping(ctx context.Context) error {
+	if err := db.Conn.PingContext(ctx); err != nil {
+		return err
+	}
+	if writer := db.openedWriter(); writer != nil {
+		return writer.PingContext(ctx)
+	}
+	return nil
+}
+
+// Dialect returns the sql dialect understood by this backend
+func (db *SQLITE3) Dialect() Dialect {
+	return DialectSqlite
+}
+
+// sqlRows adapts sql.Rows to the Rows interface
+type sqlRows struct {
+	*sql.Rows
+}
+
+func (r sqlRows) Close() {
+	_ = r.Rows.Close()
+}
+
+// getStmt returns the sql.Stmt of the named query for the given pool, preparing it the first time.
+// database/sql transparently prepares it again on each connection of the pool that needs it.
+func (db *SQLITE3) getStmt(ctx context.Context, name string, forWrite bool) (*sql.Stmt, error) {
+	conn, stmts := db.Conn, db.readStmts
+	if forWrite {
+		writer, err := db.getWriter()
+		if err != nil {
+			return nil, err
+		}
+		conn, stmts = writer, db.writeStmts
+	}
+	db.stmtsMu.Lock()
+	defer db.stmtsMu.Unlock()
+	if stmt, exist := stmts[name]; exist {
+		return stmt, nil
+	}
+	sqlText, err := getNamedQuerySql(name, DialectSqlite)
+	if err != nil {
+		return nil, err
+	}
+	stmt, err := conn.PrepareContext(ctx, sqlText)
+	if err != nil {
+		return nil, err
+	}
+	stmts[name] = stmt
+	return stmt, nil
+}
+
+// QueryNamed runs the named query with its statement prepared on the read-only pool
+func (db *SQLITE3) QueryNamed(ctx context.Context, name string, arguments ...interface{}) (Rows, error) {
+	start := time.Now()
+	stmt, err := db.getStmt(ctx, name, false)
+	if err != nil {
+		db.log.Error("QueryNamed(%s) could not get a prepared statement. error : %v", name, err)
+		observeNamedQuery(name, DialectSqlite, start, err)
+		return nil, err
+	}
+	rows, err := stmt.QueryContext(ctx, arguments...)
+	if err != nil {
+		db.log.Error("QueryNamed(%s) unexpectedly failed. args : (%v), error : %v", name, arguments, err)
+		observeNamedQuery(name, DialectSqlite, start, err)
+		return nil, err
+	}
+	return &timedRows{Rows: sqlRows{rows}, name: name, dialect: DialectSqlite, start: start}, nil
+}
+
+// Query runs a query built at runtime on the read-only pool, user values must be given as arguments (see Params)
+func (db *SQLITE3) Query(ctx context.Context, sql string, arguments ...interface{}) (Rows, error) {
+	start := time.Now()
+	rows, err := db.Conn.QueryContext(ctx, sql, arguments...)
+	if err != nil {
+		db.log.Error("Query unexpectedly failed with %v. args : (%v), error : %v", sql, arguments, err)
+		observeNamedQuery("", DialectSqlite, start, err)
+		return nil, err
+	}
+	return &timedRows{Rows: sqlRows{rows}, dialect: DialectSqlite, start: start}, nil
+}
+
+// ExecNamed runs the named action query with its statement prepared on the writer
+func (db *SQLITE3) ExecNamed(ctx context.Context, name string, arguments ...interface{}) (rowsAffected int, err error) {
+	start := time.Now()
+	defer func() { observeNamedQuery(name, DialectSqlite, start, err) }()
+	stmt, err := db.getStmt(ctx, name, true)
+	if err != nil {
+		db.log.Error("ExecNamed(%s) could not get a prepared statement. error : %v", name, err)
+		return 0, err
+	}
+	res, err := stmt.ExecContext(ctx, arguments...)
+	if err != nil {
+		db.log.Error("ExecNamed(%s) unexpectedly failed. args : (%v), error : %v", name, arguments, err)
+		return 0, err
+	}
+	rowsAff, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(rowsAff), nil
+}
@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	benchFixtureRows = 20000
+	benchSearchSql   = "SELECT text FROM search_item WHERE text LIKE ? ORDER BY text LIMIT 20;"
+)
+
+// benchStreets are combined with the house numbers to fill the text of the search_item fixture
+var benchStreets = []string{"avenue de la gare", "rue de bourg", "chemin des roches", "place de la palud", "route de berne",
+	"rue du lac", "avenue de cour", "chemin de montolieu", "rue centrale", "avenue d'ouchy"}
+
+// gpkgPoint returns the GeoPackage binary of a point in srsID, a header without envelope followed by the WKB
+func gpkgPoint(srsID int32, x, y float64) []byte {
+	b := make([]byte, 8, 8+21)
+	copy(b, "GP")
+	b[3] = 1 // little endian, no envelope, not empty
+	binary.LittleEndian.PutUint32(b[4:], uint32(srsID))
+	b = append(b, 1)
+	b = binary.LittleEndian.AppendUint32(b, 1)
+	b = binary.LittleEndian.AppendUint64(b, math.Float64bits(x))
+	return binary.LittleEndian.AppendUint64(b, math.Float64bits(y))
+}
+
+// writeSearchFixture creates a GeoPackage with a search_item table of benchFixtureRows points in LV95,
+// with the plain sqlite3 driver to stay independent of the code being measured
+func writeSearchFixture(b *testing.B, path string) {
+	b.Helper()
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		b.Fatalf("opening fixture %s failed : %v", path, err)
+	}
+	defer conn.Close()
+	for _, stmt := range []string{
+		"PRAGMA application_id = 1196444487;",
+		`CREATE TABLE gpkg_spatial_ref_sys (srs_name TEXT NOT NULL, srs_id INTEGER PRIMARY KEY, organization TEXT NOT NULL,
+			organization_coordsys_id INTEGER NOT NULL, definition TEXT NOT NULL, description TEXT);`,
+		"INSERT INTO gpkg_spatial_ref_sys VALUES ('CH1903+ / LV95', 2056, 'EPSG', 2056, 'undefined', NULL);",
+		`CREATE TABLE gpkg_contents (table_name TEXT NOT NULL PRIMARY KEY, data_type TEXT NOT NULL, identifier TEXT UNIQUE,
+			description TEXT DEFAULT '', last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+			min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE, srs_id INTEGER);`,
+		"INSERT INTO gpkg_contents (table_name, data_type, identifier, srs_id) VALUES ('search_item', 'features', 'search_item', 2056);",
+		`CREATE TABLE gpkg_geometry_columns (table_name TEXT NOT NULL, column_name TEXT NOT NULL, geometry_type_name TEXT NOT NULL,
+			srs_id INTEGER NOT NULL, z TINYINT NOT NULL, m TINYINT NOT NULL, CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name));`,
+		"INSERT INTO gpkg_geometry_columns VALUES ('search_item', 'geom', 'POINT', 2056, 0, 0);",
+		"CREATE TABLE search_item (id INTEGER PRIMARY KEY AUTOINCREMENT, text TEXT NOT NULL, geom BLOB);",
+	} {
+		if _, err = conn.Exec(stmt); err != nil {
+			b.Fatalf("creating fixture %s failed : %v", path, err)
+		}
+	}
+	tx, err := conn.Begin()
+	if err != nil {
+		b.Fatalf("filling fixture %s failed : %v", path, err)
+	}
+	for i := 0; i < benchFixtureRows; i++ {
+		text := fmt.Sprintf("%s %d", benchStreets[i%len(benchStreets)], i/len(benchStreets)+1)
+		point := gpkgPoint(2056, 2538000+float64(i%200)*5, 1152000+float64(i/200)*5)
+		if _, err = tx.Exec("INSERT INTO search_item (text, geom) VALUES (?, ?);", text, point); err != nil {
+			_ = tx.Rollback()
+			b.Fatalf("filling fixture %s failed : %v", path, err)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		b.Fatalf("filling fixture %s failed : %v", path, err)
+	}
+}
+
+// BenchmarkSqliteParallelSearch runs the text searches of concurrent clients on a GeoPackage opened with NewSqlite3DB
+func BenchmarkSqliteParallelSearch(b *testing.B) {
+	l, err := golog.NewLogger("zap", golog.ErrorLevel, "bench ")
+	if err != nil {
+		b.Fatalf("NewLogger failed : %v", err)
+	}
+	path := filepath.Join(b.TempDir(), "search.gpkg")
+	writeSearchFixture(b, path)
+	db, err := NewSqlite3DB(path, l)
+	if err != nil {
+		b.Fatalf("NewSqlite3DB(%s) failed : %v", path, err)
+	}
+	defer db.Close()
+
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			i := int(next.Add(1))
+			term := fmt.Sprintf("%%%s %d%%", benchStreets[i%len(benchStreets)], i%97+1)
+			found, err := db.GetQueryStringArr(benchSearchSql, term)
+			if err != nil {
+				b.Errorf("search %q failed : %v", term, err)
+				return
+			}
+			if len(found) == 0 {
+				b.Errorf("search %q found nothing", term)
+				return
+			}
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package dataset
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+)
+
+// Column describes one attribute of a geometry table
+type Column struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	NotNull    bool   `json:"not_null"`
+	PrimaryKey bool   `json:"primary_key"`
+}
+
+// Dataset describes a geometry table of a GeoPackage (gpkg_contents/gpkg_geometry_columns)
+// or of a PostGIS database (geometry_columns)
+type Dataset struct {
+	Schema         string      `json:"schema,omitempty"`
+	TableName      string      `json:"table_name"`
+	Identifier     string      `json:"identifier"`
+	Description    string      `json:"description"`
+	GeometryColumn string      `json:"geometry_column"`
+	GeometryType   string      `json:"geometry_type"`
+	SrsID          int         `json:"srs_id"`
+	Srs            string      `json:"srs"`               // like EPSG:2056
+	Extent         *[4]float64 `json:"extent"`            // min_x, min_y, max_x, max_y or null when unknown
+	FeatureCount   int         `json:"feature_count"`     // estimated number of rows in the table, see Describe
+	Columns        []Column    `json:"columns,omitempty"` // attributes in table order, including the geometry
+}
+
+var ErrDatasetNotFound = errors.New("dataset not found")
+
+var (
+	listDatasets = database.MustRegisterNamedQuery("dataset_list", map[database.Dialect]string{
+		database.DialectSqlite: `SELECT '' AS schema, c.table_name, coalesce(c.identifier, c.table_name), coalesce(c.description, ''),
+       g.column_name, g.geometry_type_name, g.srs_id,
+       coalesce(s.organization || ':' || s.organization_coordsys_id, ''),
+       c.min_x, c.min_y, c.max_x, c.max_y
+FROM gpkg_contents c
+JOIN gpkg_geometry_columns g ON g.table_name = c.table_name
+LEFT JOIN gpkg_spatial_ref_sys s ON s.srs_id = g.srs_id
+WHERE c.data_type = 'features'
+ORDER BY c.table_name;`,
+		database.DialectPostgres: `SELECT g.f_table_schema::text, g.f_table_name::text, g.f_table_name::text,
+       coalesce(obj_description(format('%I.%I', g.f_table_schema, g.f_table_name)::regclass, 'pg_class'), ''),
+       g.f_geometry_column::text, g.type::text, g.srid,
+       coalesce(s.auth_name || ':' || s.auth_srid, ''),
+       NULL::float8, NULL::float8, NULL::float8, NULL::float8
+FROM geometry_columns g
+LEFT JOIN spatial_ref_sys s ON s.srid = g.srid
+WHERE g.f_table_schema NOT IN ('pg_catalog', 'information_schema', 'topology', 'tiger')
+ORDER BY g.f_table_schema, g.f_table_name;`,
+	})
+	listColumns = database.MustRegisterNamedQuery("dataset_columns", map[database.Dialect]string{
+		database.DialectSqlite: `SELECT name, type, "notnull" > 0, pk > 0 FROM pragma_table_info(?) ORDER BY cid;`,
+		database.DialectPostgres: `SELECT c.column_name::text, c.udt_name::text, c.is_nullable = 'NO',
+       EXISTS(SELECT 1 FROM information_schema.table_constraints tc
+              JOIN information_schema.key_column_usage k
+                ON k.constraint_name = tc.constraint_name AND k.table_schema = tc.table_schema
+              WHERE tc.constraint_type = 'PRIMARY KEY' AND tc.table_schema = c.table_schema
+                AND tc.table_name = c.table_name AND k.column_name = c.column_name)
+FROM information_schema.columns c
+WHERE c.table_schema = $1 AND c.table_name = $2
+ORDER BY c.ordinal_position;`,
+	})
+	// the statistics of the planner, or the live rows counted by autovacuum when the table was never analyzed
+	estimatedCount = database.MustRegisterNamedQuery("dataset_estimated_count", map[database.Dialect]string{
+		database.DialectPostgres: `SELECT coalesce(nullif(c.reltuples, -1)::bigint, s.n_live_tup, 0)
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_stat_user_tables s ON s.relid = c.oid
+WHERE n.nspname = $1 AND c.relname = $2;`,
+	})
+	estimatedExtent = database.MustRegisterNamedQuery("dataset_estimated_extent", map[database.Dialect]string{
+		database.DialectPostgres: `SELECT ST_XMin(e), ST_YMin(e), ST_XMax(e), ST_YMax(e)
+FROM (SELECT ST_EstimatedExtent($1, $2, $3) AS e) AS extent WHERE e IS NOT NULL;`,
+	})
+)
+
+// QuoteIdentifier returns name quoted as a sql identifier, valid for postgres and sqlite
+func QuoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// QualifiedName returns the quoted schema.table name to use in sql
+func (d *Dataset) QualifiedName() string {
+	if d.Schema == "" {
+		return QuoteIdentifier(d.TableName)
+	}
+	return QuoteIdentifier(d.Schema) + "." + QuoteIdentifier(d.TableName)
+}
+
+// ID returns the identifier used in urls, the table name prefixed by its schema when it is not public
+func (d *Dataset) ID() string {
+	if d.Schema == "" || d.Schema == "public" {
+		return d.TableName
+	}
+	return d.Schema + "." + d.TableName
+}
+
+// ListDatasets returns all the geometry tables of db, without the columns and feature count
+func ListDatasets(ctx context.Context, db database.DB) ([]Dataset, error) {
+	rows, err := db.QueryNamed(ctx, listDatasets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var res []Dataset
+	for rows.Next() {
+		var d Dataset
+		var minX, minY, maxX, maxY *float64
+		if err := rows.Scan(&d.Schema, &d.TableName, &d.Identifier, &d.Description, &d.GeometryColumn, &d.GeometryType,
+			&d.SrsID, &d.Srs, &minX, &minY, &maxX, &maxY); err != nil {
+			return nil, err
+		}
+		if minX != nil && minY != nil && maxX != nil && maxY != nil {
+			d.Extent = &[4]float64{*minX, *minY, *maxX, *maxY}
+		}
+		res = append(res, d)
+	}
+	return res, rows.Err()
+}
+
+// DescribeColumns fills d.Columns with the attributes of the table d, in table order
+func DescribeColumns(ctx context.Context, db database.DB, d *Dataset) error {
+	var args []interface{}
+	if db.Dialect() == database.DialectPostgres {
+		args = []interface{}{d.Schema, d.TableName}
+	} else {
+		args = []interface{}{d.TableName}
+	}
+	rows, err := db.QueryNamed(ctx, listColumns, args...)
+	if err != nil {
+		return err
+	}
+	d.Columns = nil
+	for rows.Next() {
+		var c Column
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.PrimaryKey); err != nil {
+			rows.Close()
+			return err
+		}
+		d.Columns = append(d.Columns, c)
+	}
+	rows.Close()
+	return rows.Err()
+}
+
+// Describe completes d with its columns, estimated feature count and, when gpkg_contents did not give it, its extent.
+// The count avoids scanning the tables on each request : postgres gives the statistics of its planner,
+// and the rowid of the last feature of a GeoPackage is exact as long as no feature was deleted.
+func Describe(ctx context.Context, db database.DB, d *Dataset) error {
+	err := DescribeColumns(ctx, db, d)
+	if err != nil {
+		return err
+	}
+
+	if db.Dialect() == database.DialectPostgres {
+		err = database.NamedQueryRow(ctx, db, estimatedCount, []interface{}{&d.FeatureCount}, d.Schema, d.TableName)
+		if err != nil && !errors.Is(err, database.ErrNoRecordFound) {
+			return err
+		}
+	} else {
+		// table names come from the metadata tables and are quoted, so they cannot inject sql here
+		d.FeatureCount, err = db.GetQueryInt(fmt.Sprintf("SELECT coalesce(max(rowid), 0) FROM %s;", d.QualifiedName()))
+		if err != nil {
+			return err
+		}
+	}
+
+	if d.Extent == nil && db.Dialect() == database.DialectPostgres {
+		var e [4]float64
+		err := database.NamedQueryRow(ctx, db, estimatedExtent, []interface{}{&e[0], &e[1], &e[2], &e[3]}, d.Schema, d.TableName, d.GeometryColumn)
+		if err == nil {
+			d.Extent = &e
+		} else if !errors.Is(err, database.ErrNoRecordFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDatasets returns all the geometry tables of db with their columns, feature count and extent
+func GetDatasets(ctx context.Context, db database.DB) ([]Dataset, error) {
+	list, err := ListDatasets(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if err := Describe(ctx, db, &list[i]); err != nil {
+			return nil, fmt.Errorf("error describing dataset %s: %w", list[i].ID(), err)
+		}
+	}
+	return list, nil
+}
+
+// GetDataset returns the fully described geometry table with the given id (see Dataset.ID) or ErrDatasetNotFound
+func GetDataset(ctx context.Context, db database.DB, id string) (*Dataset, error) {
+	list, err := ListDatasets(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].ID() == id {
+			if err := Describe(ctx, db, &list[i]); err != nil {
+				return nil, err
+			}
+			return &list[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", ErrDatasetNotFound, id)
+}
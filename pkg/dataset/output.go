@@ -0,0 +1,116 @@
+package dataset
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/go-http-server"
+)
+
+const (
+	FormatJson  = "json"
+	FormatTable = "table"
+)
+
+// Write outputs datasets to w in the given format (json or table)
+func Write(w io.Writer, datasets []Dataset, format string) error {
+	switch format {
+	case FormatJson:
+		return WriteJson(w, datasets)
+	case FormatTable:
+		return WriteTable(w, datasets)
+	default:
+		return fmt.Errorf("unknown output format %q, use %s or %s", format, FormatJson, FormatTable)
+	}
+}
+
+// WriteJson outputs datasets as an indented json array
+func WriteJson(w io.Writer, datasets []Dataset) error {
+	if datasets == nil {
+		datasets = []Dataset{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(datasets)
+}
+
+// WriteTable outputs datasets as a human-readable table, followed by the column schema of each one
+func WriteTable(w io.Writer, datasets []Dataset) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATASET\tGEOMETRY\tTYPE\tSRS\tFEATURES\tEXTENT")
+	for _, d := range datasets {
+		extent := "-"
+		if d.Extent != nil {
+			extent = fmt.Sprintf("%.2f %.2f %.2f %.2f", d.Extent[0], d.Extent[1], d.Extent[2], d.Extent[3])
+		}
+		srs := d.Srs
+		if srs == "" {
+			srs = fmt.Sprintf("srs_id:%d", d.SrsID)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", d.ID(), d.GeometryColumn, d.GeometryType, srs, d.FeatureCount, extent)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, d := range datasets {
+		fmt.Fprintf(w, "\n%s :\n", d.ID())
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "  COLUMN\tTYPE\tNOT NULL\tPK")
+		for _, c := range d.Columns {
+			fmt.Fprintf(tw, "  %s\t%s\t%v\t%v\n", c.Name, strings.ToLower(c.Type), c.NotNull, c.PrimaryKey)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetDatasetsHandler returns the handler of /api/datasets listing all the geometry tables of db,
+// /api/datasets/{id} returns only one of them. the table output is available with ?format=table,
+// the other formats are negotiated with the Accept header or ?f=
+func GetDatasetsHandler(db database.DB, l golog.MyLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var datasets []Dataset
+		var err error
+		if id := r.PathValue("id"); id != "" {
+			var d *Dataset
+			d, err = GetDataset(r.Context(), db, id)
+			if d != nil {
+				datasets = []Dataset{*d}
+			}
+		} else {
+			datasets, err = GetDatasets(r.Context(), db)
+		}
+		if err != nil {
+			if errors.Is(err, ErrDatasetNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			l.Error("GetDatasetsHandler failed to get datasets : %v", err)
+			http.Error(w, "error retrieving datasets", http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Query().Get("format") == FormatTable {
+			w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+			err = WriteTable(w, datasets)
+		} else if r.PathValue("id") != "" {
+			err = go_http_server.Render(w, r, http.StatusOK, datasets[0], go_http_server.JSONFormats...)
+		} else {
+			if datasets == nil {
+				datasets = []Dataset{}
+			}
+			err = go_http_server.Render(w, r, http.StatusOK, datasets, go_http_server.JSONFormats...)
+		}
+		if err != nil {
+			l.Error("GetDatasetsHandler failed to write response : %v", err)
+		}
+	}
+}
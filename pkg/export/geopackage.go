@@ -0,0 +1,399 @@
+// Package export copies the PostGIS tables used by the search into an OGC GeoPackage,
+// so remote sites can run the server on the sqlite3 backend with the same data.
+package export
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geopackage"
+)
+
+const (
+	defaultSchema         = "public"
+	defaultGeometryColumn = "geom"
+	defaultPrimaryKey     = "fid"
+	defaultSrsID          = 2056
+	// FullTextSuffix is appended to the table name to get the name of its fts4 full text index
+	FullTextSuffix = "_fts"
+)
+
+// Table is a postgres table to copy in the GeoPackage
+type Table struct {
+	Name   string
+	Schema string // defaults to public
+	// XColumn and YColumn give a POINT geometry in EPSG:2056 to a table without geometry column, like search_item
+	XColumn, YColumn string
+	// FullText are the text columns indexed in the fts4 table <Name>_fts, searched with MATCH
+	FullText []string
+}
+
+// DefaultTables are the tables needed by a remote site to run the search
+var DefaultTables = []Table{
+	{Name: "search_item", XColumn: "x", YColumn: "y", FullText: []string{"keywords", "display"}},
+	{Name: "adresses"},
+	{Name: "communes"},
+}
+
+var getSpatialRefSys = database.MustRegisterNamedQuery("export_spatial_ref_sys", map[database.Dialect]string{
+	database.DialectPostgres: "SELECT coalesce(auth_name, 'NONE'), coalesce(auth_srid, srid), coalesce(srtext, 'undefined') FROM spatial_ref_sys WHERE srid = $1;",
+})
+
+// gpkgGeometryTypes are the geometry type names allowed in gpkg_geometry_columns, the longest first
+var gpkgGeometryTypes = []string{
+	"GEOMETRYCOLLECTION", "MULTILINESTRING", "MULTIPOLYGON", "MULTIPOINT", "LINESTRING", "POLYGON", "GEOMETRY", "POINT",
+}
+
+// column is an attribute copied in the GeoPackage, with the postgres expression selecting it and its sqlite type
+type column struct {
+	name       string
+	selectExpr string
+	sqliteType string
+}
+
+// toSqliteColumn maps a postgres column to a GeoPackage column, ok is false for the types that are not copied.
+// The types without a GeoPackage equivalent are copied as TEXT with their postgres text representation.
+func toSqliteColumn(c dataset.Column) (col column, ok bool) {
+	quoted := dataset.QuoteIdentifier(c.Name)
+	col = column{name: c.Name, selectExpr: quoted}
+	switch c.Type {
+	case "int2":
+		col.sqliteType = "SMALLINT"
+	case "int4":
+		col.sqliteType = "MEDIUMINT"
+	case "int8":
+		col.sqliteType = "INTEGER"
+	case "float4":
+		col.sqliteType = "FLOAT"
+	case "float8":
+		col.sqliteType = "DOUBLE"
+	case "numeric":
+		col.sqliteType, col.selectExpr = "DOUBLE", quoted+"::float8"
+	case "bool":
+		col.sqliteType = "BOOLEAN"
+	case "text", "varchar", "bpchar", "name":
+		col.sqliteType = "TEXT"
+	case "bytea":
+		col.sqliteType = "BLOB"
+	case "date":
+		col.sqliteType, col.selectExpr = "DATE", quoted+"::text"
+	case "timestamp", "timestamptz":
+		// GeoPackage DATETIME are ISO 8601 strings in UTC
+		col.sqliteType = "DATETIME"
+		col.selectExpr = fmt.Sprintf(`to_char(%s, 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`, quoted)
+		if c.Type == "timestamptz" {
+			col.selectExpr = fmt.Sprintf(`to_char(%s AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.MS"Z"')`, quoted)
+		}
+	case "tsvector", "tsquery", "geometry", "geography", "raster":
+		// the full text index is rebuilt in fts4 and a GeoPackage feature table has only one geometry
+		return col, false
+	default:
+		col.sqliteType, col.selectExpr = "TEXT", quoted+"::text"
+	}
+	return col, true
+}
+
+// gpkgGeometryType returns the GeoPackage name of a postgis geometry type like MULTIPOLYGON or POINTZ
+func gpkgGeometryType(postgisType string) string {
+	t := strings.ToUpper(postgisType)
+	for _, name := range gpkgGeometryTypes {
+		if strings.HasPrefix(t, name) {
+			return name
+		}
+	}
+	return "GEOMETRY"
+}
+
+// toFloat converts the numeric values returned by pgx
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+// Exporter copies postgres tables from src to the GeoPackage dst
+type Exporter struct {
+	src *database.PgxDB
+	dst *database.SQLITE3
+	log golog.MyLogger
+}
+
+// NewExporter returns an Exporter writing to dst, which must be opened with a writer connection
+func NewExporter(src *database.PgxDB, dst *database.SQLITE3, log golog.MyLogger) *Exporter {
+	return &Exporter{src: src, dst: dst, log: log}
+}
+
+// Run creates the GeoPackage metadata tables and copies all the tables, each one in its own transaction
+func (e *Exporter) Run(ctx context.Context, tables []Table) error {
+	for _, stmt := range geopackage.CoreTablesSQL {
+		if _, err := e.dst.ExecActionQuery(stmt); err != nil {
+			return fmt.Errorf("error creating the GeoPackage metadata tables: %w", err)
+		}
+	}
+	for _, t := range tables {
+		if err := e.dst.WithTransaction(ctx, func(tx *sql.Tx) error {
+			return e.exportTable(ctx, tx, t)
+		}); err != nil {
+			return fmt.Errorf("error exporting table %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+// describe returns the source dataset of t, with its columns
+func (e *Exporter) describe(ctx context.Context, t Table) (*dataset.Dataset, error) {
+	if t.Schema == "" {
+		t.Schema = defaultSchema
+	}
+	geometryTables, err := dataset.ListDatasets(ctx, e.src)
+	if err != nil {
+		return nil, err
+	}
+	d := &dataset.Dataset{Schema: t.Schema, TableName: t.Name, Identifier: t.Name}
+	for i := range geometryTables {
+		if geometryTables[i].Schema == t.Schema && geometryTables[i].TableName == t.Name {
+			d = &geometryTables[i]
+			break
+		}
+	}
+	if d.GeometryColumn == "" {
+		if t.XColumn == "" || t.YColumn == "" {
+			return nil, fmt.Errorf("%s.%s has no geometry column and no x,y columns were given", t.Schema, t.Name)
+		}
+		d.GeometryColumn, d.GeometryType, d.SrsID = defaultGeometryColumn, "POINT", defaultSrsID
+	}
+	if err := dataset.DescribeColumns(ctx, e.src, d); err != nil {
+		return nil, err
+	}
+	if len(d.Columns) == 0 {
+		return nil, fmt.Errorf("%w: %s.%s", dataset.ErrDatasetNotFound, t.Schema, t.Name)
+	}
+	return d, nil
+}
+
+// addSpatialRefSys copies the definition of srsID from postgres when it is not one of the srs always present
+func (e *Exporter) addSpatialRefSys(ctx context.Context, tx *sql.Tx, srsID int) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT count(*) FROM gpkg_spatial_ref_sys WHERE srs_id = ?;", srsID).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	var organization, definition string
+	var organizationID int
+	err := database.NamedQueryRow(ctx, e.src, getSpatialRefSys, []interface{}{&organization, &organizationID, &definition}, srsID)
+	if err != nil {
+		return fmt.Errorf("error reading srid %d in spatial_ref_sys: %w", srsID, err)
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO gpkg_spatial_ref_sys VALUES (?, ?, ?, ?, ?, '');",
+		fmt.Sprintf("%s:%d", organization, organizationID), srsID, organization, organizationID, definition)
+	return err
+}
+
+func (e *Exporter) exportTable(ctx context.Context, tx *sql.Tx, t Table) error {
+	d, err := e.describe(ctx, t)
+	if err != nil {
+		return err
+	}
+	geometryType := gpkgGeometryType(d.GeometryType)
+	e.log.Info("exporting %s (%s %s, srid %d)", d.QualifiedName(), d.GeometryColumn, geometryType, d.SrsID)
+
+	// the GeoPackage primary key must be an integer, when the source has none a fid column is added
+	pk := defaultPrimaryKey
+	var columns []column
+	for _, c := range d.Columns {
+		col, ok := toSqliteColumn(c)
+		if !ok {
+			if c.Name != d.GeometryColumn {
+				e.log.Warn("column %s.%s of type %s is not exported", d.TableName, c.Name, c.Type)
+			}
+			continue
+		}
+		if c.PrimaryKey && (c.Type == "int2" || c.Type == "int4" || c.Type == "int8") && pk == defaultPrimaryKey {
+			pk = c.Name
+		}
+		columns = append(columns, col)
+	}
+
+	if err := e.addSpatialRefSys(ctx, tx, d.SrsID); err != nil {
+		return err
+	}
+	table := dataset.QuoteIdentifier(d.TableName)
+	ddl := []string{fmt.Sprintf("%s INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL", dataset.QuoteIdentifier(pk)),
+		fmt.Sprintf("%s %s", dataset.QuoteIdentifier(d.GeometryColumn), geometryType)}
+	for _, col := range columns {
+		if col.name != pk {
+			ddl = append(ddl, fmt.Sprintf("%s %s", dataset.QuoteIdentifier(col.name), col.sqliteType))
+		}
+	}
+	statements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", table),
+		fmt.Sprintf("CREATE TABLE %s (\n  %s\n);", table, strings.Join(ddl, ",\n  ")),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO gpkg_contents (table_name, data_type, identifier, description, srs_id)
+VALUES (?, 'features', ?, ?, ?);`, d.TableName, d.Identifier, d.Description, d.SrsID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO gpkg_geometry_columns VALUES (?, ?, ?, ?, 0, 0);",
+		d.TableName, d.GeometryColumn, geometryType, d.SrsID); err != nil {
+		return err
+	}
+	for _, stmt := range geopackage.CreateRtreeSQL(d.TableName, d.GeometryColumn) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	// the geometry is always the first column selected, forced to 2D since the GeoPackage declares z and m prohibited
+	selectExprs := []string{"NULL"}
+	if t.XColumn == "" {
+		selectExprs[0] = fmt.Sprintf("ST_Force2D(%s)", dataset.QuoteIdentifier(d.GeometryColumn))
+	}
+	insertColumns := []string{dataset.QuoteIdentifier(d.GeometryColumn)}
+	xIndex, yIndex := -1, -1
+	for i, col := range columns {
+		selectExprs = append(selectExprs, col.selectExpr)
+		insertColumns = append(insertColumns, dataset.QuoteIdentifier(col.name))
+		switch col.name {
+		case t.XColumn:
+			xIndex = i + 1
+		case t.YColumn:
+			yIndex = i + 1
+		}
+	}
+	if t.XColumn != "" && (xIndex < 0 || yIndex < 0) {
+		return fmt.Errorf("columns %s,%s not found in %s", t.XColumn, t.YColumn, d.QualifiedName())
+	}
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", table,
+		strings.Join(insertColumns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(insertColumns)), ", ")))
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	insertRtree, err := tx.PrepareContext(ctx, geopackage.InsertRtreeSQL(d.TableName, d.GeometryColumn))
+	if err != nil {
+		return err
+	}
+	defer insertRtree.Close()
+
+	rows, err := e.src.Conn.Query(ctx, fmt.Sprintf("SELECT %s FROM %s;", strings.Join(selectExprs, ", "), d.QualifiedName()))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	extent := geom.EmptyEnvelope()
+	count := 0
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return err
+		}
+		var g geom.Geometry
+		if t.XColumn != "" {
+			x, okX := toFloat(values[xIndex])
+			y, okY := toFloat(values[yIndex])
+			if okX && okY {
+				g = &geom.Point{Lay: geom.XY, Coord: geom.Coord{X: x, Y: y}}
+			}
+		} else if values[0] != nil {
+			var v geom.Value
+			if err := v.Scan(values[0]); err != nil {
+				return fmt.Errorf("error decoding geometry of row %d: %w", count+1, err)
+			}
+			g = v.Geometry
+		}
+		if g != nil {
+			values[0] = geopackage.Encode(g, int32(d.SrsID))
+		} else {
+			values[0] = nil
+		}
+		res, err := insert.ExecContext(ctx, values...)
+		if err != nil {
+			return fmt.Errorf("error inserting row %d: %w", count+1, err)
+		}
+		if g != nil && !g.IsEmpty() {
+			id, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			env := geom.EnvelopeOf(g)
+			if _, err := insertRtree.ExecContext(ctx, id, env.MinX, env.MaxX, env.MinY, env.MaxY); err != nil {
+				return err
+			}
+			extent.Extend(env)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if !extent.IsEmpty() {
+		if _, err := tx.ExecContext(ctx, "UPDATE gpkg_contents SET min_x = ?, min_y = ?, max_x = ?, max_y = ? WHERE table_name = ?;",
+			extent.MinX, extent.MinY, extent.MaxX, extent.MaxY, d.TableName); err != nil {
+			return err
+		}
+	}
+	// the triggers are created after the bulk insert, the rtree was filled above with the envelopes computed in go
+	for _, stmt := range geopackage.CreateRtreeTriggersSQL(d.TableName, d.GeometryColumn, pk) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if len(t.FullText) > 0 {
+		if err := createFullTextIndex(ctx, tx, d.TableName, t.FullText); err != nil {
+			return err
+		}
+	}
+	e.log.Info("exported %d rows of %s", count, d.QualifiedName())
+	return nil
+}
+
+// createFullTextIndex creates an external content fts4 table on columns of table, its docid is the table primary key.
+// The diacritics are removed so 'ecublens' matches 'Écublens'.
+func createFullTextIndex(ctx context.Context, tx *sql.Tx, table string, columns []string) error {
+	if len(columns) == 0 {
+		return errors.New("no column given for the full text index")
+	}
+	fts := dataset.QuoteIdentifier(table + FullTextSuffix)
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = dataset.QuoteIdentifier(c)
+	}
+	statements := []string{
+		fmt.Sprintf("DROP TABLE IF EXISTS %s;", fts),
+		fmt.Sprintf(`CREATE VIRTUAL TABLE %s USING fts4(content=%s, %s, tokenize=unicode61 "remove_diacritics=1");`,
+			fts, dataset.QuoteIdentifier(table), strings.Join(quoted, ", ")),
+		fmt.Sprintf("INSERT INTO %[1]s(%[1]s) VALUES ('rebuild');", fts),
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("error creating full text index %s: %w", fts, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+// Package features implements OGC API - Features Part 1 (core and GeoJSON) and the crs parameters of Part 2
+// over the geometry tables found by pkg/dataset, on PostGIS or on a GeoPackage, so that QGIS can connect directly.
+package features
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/go-http-server"
+)
+
+const (
+	CrsCRS84       = "http://www.opengis.net/def/crs/OGC/1.3/CRS84"
+	crsEpsgPrefix  = "http://www.opengis.net/def/crs/EPSG/0/"
+	MIMEGeoJSON    = "application/geo+json"
+	MIMEJSON       = "application/json"
+	MIMESchemaJSON = "application/schema+json"
+	MIMEOpenAPI    = "application/vnd.oai.openapi+json;version=3.1"
+	relQueryables  = "http://www.opengis.net/def/rel/ogc/1.0/queryables"
+	DefaultLimit   = 10
+	MaxLimit       = 10000
+	collectionsTTL = 5 * time.Minute
+)
+
+// ConformanceClasses are the requirements classes implemented by this server
+var ConformanceClasses = []string{
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/core",
+	"http://www.opengis.net/spec/ogcapi-features-1/1.0/conf/geojson",
+	"http://www.opengis.net/spec/ogcapi-features-2/1.0/conf/crs",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/queryables-query-parameters",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/filter",
+	"http://www.opengis.net/spec/ogcapi-features-3/1.0/conf/features-filter",
+	"http://www.opengis.net/spec/cql2/1.0/conf/cql2-text",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-cql2",
+	"http://www.opengis.net/spec/cql2/1.0/conf/advanced-comparison-operators",
+	"http://www.opengis.net/spec/cql2/1.0/conf/basic-spatial-functions",
+}
+
+// Link is a web link as defined in the OGC API common
+type Link struct {
+	Href  string `json:"href"`
+	Rel   string `json:"rel"`
+	Type  string `json:"type,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type LandingPage struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Links       []Link `json:"links"`
+}
+
+type Conformance struct {
+	ConformsTo []string `json:"conformsTo"`
+}
+
+type SpatialExtent struct {
+	Bbox [][4]float64 `json:"bbox"`
+	Crs  string       `json:"crs"`
+}
+
+type Extent struct {
+	Spatial SpatialExtent `json:"spatial"`
+}
+
+// Collection describes one geometry table
+type Collection struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	Links       []Link   `json:"links"`
+	Extent      *Extent  `json:"extent,omitempty"`
+	ItemType    string   `json:"itemType"`
+	Crs         []string `json:"crs"`
+	StorageCrs  string   `json:"storageCrs,omitempty"`
+}
+
+type Collections struct {
+	Links       []Link       `json:"links"`
+	Collections []Collection `json:"collections"`
+}
+
+// Feature is a GeoJSON feature, the geometry is null when the row has none
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Geometry   geom.Value             `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	Links      []Link                 `json:"links,omitempty"`
+}
+
+// Queryables is the JSON schema of the properties usable in the filters of a collection
+type Queryables struct {
+	Schema               string                       `json:"$schema"`
+	ID                   string                       `json:"$id"`
+	Type                 string                       `json:"type"`
+	Title                string                       `json:"title"`
+	Properties           map[string]map[string]string `json:"properties"`
+	AdditionalProperties bool                         `json:"additionalProperties"`
+}
+
+type FeatureCollection struct {
+	Type           string    `json:"type"`
+	Features       []Feature `json:"features"`
+	Links          []Link    `json:"links"`
+	NumberMatched  *int      `json:"numberMatched,omitempty"` // only when asked, see ItemsQuery.CountMatched
+	NumberReturned int       `json:"numberReturned"`
+	TimeStamp      string    `json:"timeStamp"`
+	// Truncated tells that more features match after this page, for the POST of a spatial query that cannot have a next link
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// Service serves the OGC API under BasePath, the described datasets are kept for collectionsTTL
+// since counting the features of every table on each request would be too slow
+type Service struct {
+	db          database.DB
+	log         golog.MyLogger
+	BasePath    string
+	Title       string
+	Description string
+	// ServiceDesc and ServiceDoc are the paths of the OpenAPI document and of its viewer, linked from the landing page
+	ServiceDesc string
+	ServiceDoc  string
+	mu          sync.Mutex
+	datasets    []dataset.Dataset
+	loadedAt    time.Time
+}
+
+// NewService returns a Service for db mounted at basePath, like /ogc
+func NewService(db database.DB, basePath, title, description string, l golog.MyLogger) *Service {
+	return &Service{db: db, log: l, BasePath: strings.TrimSuffix(basePath, "/"), Title: title, Description: description}
+}
+
+// getDatasets returns the described geometry tables, from the cache when it is fresh
+func (s *Service) getDatasets(ctx context.Context) ([]dataset.Dataset, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.datasets != nil && time.Since(s.loadedAt) < collectionsTTL {
+		return s.datasets, nil
+	}
+	list, err := dataset.GetDatasets(ctx, s.db)
+	if err != nil {
+		return nil, err
+	}
+	s.datasets, s.loadedAt = list, time.Now()
+	return list, nil
+}
+
+// getDataset returns the described geometry table with the collection id or dataset.ErrDatasetNotFound
+func (s *Service) getDataset(ctx context.Context, id string) (*dataset.Dataset, error) {
+	list, err := s.getDatasets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range list {
+		if list[i].ID() == id {
+			return &list[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %s", dataset.ErrDatasetNotFound, id)
+}
+
+// baseURL returns the absolute url of the service as seen by the client, behind a reverse proxy too.
+// The X-Forwarded-* headers are only used from a trusted proxy, a client could else point the links to any host.
+func (s *Service) baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	host := r.Host
+	if !go_http_server.FromTrustedProxy(r) {
+		return scheme + "://" + host + s.BasePath
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	if forwardedHost := r.Header.Get("X-Forwarded-Host"); forwardedHost != "" {
+		host = strings.TrimSpace(strings.Split(forwardedHost, ",")[0])
+	}
+	return scheme + "://" + host + s.BasePath
+}
+
+// crsURI returns the OGC uri of an EPSG srid, 4326 is served as CRS84 which has the longitude first like the data
+func crsURI(srid int) string {
+	if srid == geom.SridWgs84 {
+		return CrsCRS84
+	}
+	return crsEpsgPrefix + strconv.Itoa(srid)
+}
+
+// supportedCrs returns the reference systems in which the features of d can be returned
+func supportedCrs(d *dataset.Dataset) []string {
+	res := []string{CrsCRS84}
+	if d.SrsID > 0 && d.SrsID != geom.SridWgs84 {
+		res = append(res, crsURI(d.SrsID))
+	}
+	return res
+}
+
+// parseCrs returns the srid of one of the supportedCrs of d
+func parseCrs(value string, d *dataset.Dataset) (int, error) {
+	switch value {
+	case "", CrsCRS84:
+		return geom.SridWgs84, nil
+	case crsURI(d.SrsID):
+		return d.SrsID, nil
+	}
+	return 0, fmt.Errorf("crs %q is not supported by collection %s, use one of %s", value, d.ID(), strings.Join(supportedCrs(d), ", "))
+}
+
+// toCollection returns the description of d with links relative to base
+func toCollection(d *dataset.Dataset, base string) Collection {
+	title := d.Identifier
+	if title == "" {
+		title = d.TableName
+	}
+	self := base + "/collections/" + d.ID()
+	c := Collection{
+		ID:          d.ID(),
+		Title:       title,
+		Description: d.Description,
+		ItemType:    "feature",
+		Crs:         supportedCrs(d),
+		StorageCrs:  crsURI(d.SrsID),
+		Links: []Link{
+			{Href: self, Rel: "self", Type: MIMEJSON, Title: "this collection"},
+			{Href: self + "/items", Rel: "items", Type: MIMEGeoJSON, Title: "the features of " + title},
+			{Href: self + "/queryables", Rel: relQueryables, Type: MIMESchemaJSON, Title: "the properties usable in the filters"},
+		},
+	}
+	if d.Extent != nil {
+		e := geom.Envelope{MinX: d.Extent[0], MinY: d.Extent[1], MaxX: d.Extent[2], MaxY: d.Extent[3]}
+		if toWgs84, err := geom.GetTransform(d.SrsID, geom.SridWgs84); err == nil {
+			c.Extent = &Extent{Spatial: SpatialExtent{Bbox: [][4]float64{geom.TransformEnvelope(e, toWgs84).Bounds()}, Crs: CrsCRS84}}
+		}
+	}
+	return c
+}
@@ -0,0 +1,266 @@
+package features
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/cql2"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/go-http-server"
+)
+
+// exception is the error body defined by OGC API common
+type exception struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// writeJSON renders result in the format negotiated with the request, GeoJSON or JSON by default,
+// the JSON schemas are always written as indented JSON
+func (s *Service) writeJSON(w http.ResponseWriter, r *http.Request, contentType string, result interface{}) {
+	var err error
+	switch contentType {
+	case MIMEGeoJSON:
+		err = go_http_server.Render(w, r, http.StatusOK, result, go_http_server.GeoJSONFormats...)
+	case MIMEJSON:
+		err = go_http_server.Render(w, r, http.StatusOK, result, go_http_server.JSONFormats...)
+	default:
+		w.Header().Set("Content-Type", contentType)
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(result)
+	}
+	if err != nil {
+		s.log.Error("features failed to write response : %v", err)
+	}
+}
+
+// writeError answers with the http status and OGC exception code matching err
+func (s *Service) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := http.StatusInternalServerError, "ServerError"
+	switch {
+	case errors.Is(err, cql2.ErrUnsupportedSpatial):
+		status, code, err = http.StatusNotImplemented, "NotImplemented", cql2.ErrUnsupportedSpatial
+	case errors.Is(err, ErrInvalidParameter):
+		status, code = http.StatusBadRequest, "InvalidParameterValue"
+	case errors.Is(err, dataset.ErrDatasetNotFound), errors.Is(err, ErrFeatureNotFound):
+		status, code = http.StatusNotFound, "NotFound"
+	default:
+		s.log.Error("features request %s failed : %v", r.URL, err)
+		err = errors.New("error retrieving features")
+	}
+	w.Header().Set("Content-Type", MIMEJSON)
+	w.WriteHeader(status)
+	if errEnc := json.NewEncoder(w).Encode(exception{Code: code, Description: err.Error()}); errEnc != nil {
+		s.log.Error("features failed to write error response : %v", errEnc)
+	}
+}
+
+// GetLandingPageHandler serves the landing page of the service
+func (s *Service) GetLandingPageHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		base := s.baseURL(r)
+		page := LandingPage{
+			Title:       s.Title,
+			Description: s.Description,
+			Links: []Link{
+				{Href: base, Rel: "self", Type: MIMEJSON, Title: "this document"},
+				{Href: base + "/conformance", Rel: "conformance", Type: MIMEJSON, Title: "OGC API conformance classes implemented by this server"},
+				{Href: base + "/collections", Rel: "data", Type: MIMEJSON, Title: "information about the feature collections"},
+			},
+		}
+		// the OpenAPI document describes the whole server, it is not under the base path of the service
+		root := strings.TrimSuffix(base, s.BasePath)
+		if s.ServiceDesc != "" {
+			page.Links = append(page.Links, Link{Href: root + s.ServiceDesc, Rel: "service-desc", Type: MIMEOpenAPI, Title: "the OpenAPI definition of the api"})
+		}
+		if s.ServiceDoc != "" {
+			page.Links = append(page.Links, Link{Href: root + s.ServiceDoc, Rel: "service-doc", Type: "text/html", Title: "the documentation of the api"})
+		}
+		s.writeJSON(w, r, MIMEJSON, page)
+	}
+}
+
+// GetConformanceHandler serves /conformance
+func (s *Service) GetConformanceHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writeJSON(w, r, MIMEJSON, Conformance{ConformsTo: ConformanceClasses})
+	}
+}
+
+// GetCollectionsHandler serves /collections
+func (s *Service) GetCollectionsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := s.getDatasets(r.Context())
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		base := s.baseURL(r)
+		res := Collections{
+			Links:       []Link{{Href: base + "/collections", Rel: "self", Type: MIMEJSON, Title: "this document"}},
+			Collections: make([]Collection, 0, len(list)),
+		}
+		for i := range list {
+			res.Collections = append(res.Collections, toCollection(&list[i], base))
+		}
+		s.writeJSON(w, r, MIMEJSON, res)
+	}
+}
+
+// GetCollectionHandler serves /collections/{id}
+func (s *Service) GetCollectionHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.getDataset(r.Context(), r.PathValue("id"))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		s.writeJSON(w, r, MIMEJSON, toCollection(d, s.baseURL(r)))
+	}
+}
+
+// queryableSchemas are the JSON schemas of the kinds of columns, the columns of other kinds are strings
+var queryableSchemas = map[cql2.Kind]map[string]string{
+	cql2.KindNumber:    {"type": "number"},
+	cql2.KindBoolean:   {"type": "boolean"},
+	cql2.KindDate:      {"type": "string", "format": "date"},
+	cql2.KindTimestamp: {"type": "string", "format": "date-time"},
+}
+
+// GetQueryablesHandler serves /collections/{id}/queryables
+func (s *Service) GetQueryablesHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.getDataset(r.Context(), r.PathValue("id"))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		res := Queryables{
+			Schema:     "https://json-schema.org/draft/2020-12/schema",
+			ID:         s.baseURL(r) + "/collections/" + d.ID() + "/queryables",
+			Type:       "object",
+			Title:      toCollection(d, "").Title,
+			Properties: map[string]map[string]string{},
+		}
+		t := newTable(d, s.db.Dialect())
+		for _, c := range d.Columns {
+			if c.Name == d.GeometryColumn {
+				res.Properties[c.Name] = map[string]string{"title": c.Name, "format": "geometry-any"}
+				continue
+			}
+			if !t.hasColumn(c.Name) && c.Name != t.idColumn {
+				continue
+			}
+			schema := map[string]string{"title": c.Name, "type": "string"}
+			for k, v := range queryableSchemas[cql2.KindOfType(c.Type)] {
+				schema[k] = v
+			}
+			res.Properties[c.Name] = schema
+		}
+		s.writeJSON(w, r, MIMESchemaJSON, res)
+	}
+}
+
+// numberMatched returns the numberMatched of a FeatureCollection, omitted when matched is -1 since it was not counted
+func numberMatched(matched int) *int {
+	if matched < 0 {
+		return nil
+	}
+	return &matched
+}
+
+// pageLink returns the url of the items with another offset
+func pageLink(itemsURL string, values url.Values, offset int) string {
+	page := url.Values{}
+	for k, v := range values {
+		page[k] = v
+	}
+	page.Set("offset", strconv.Itoa(offset))
+	return itemsURL + "?" + page.Encode()
+}
+
+// GetItemsHandler serves /collections/{id}/items
+func (s *Service) GetItemsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.getDataset(r.Context(), r.PathValue("id"))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		t := newTable(d, s.db.Dialect())
+		values := r.URL.Query()
+		q, err := parseItemsQuery(values, t)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		features, more, matched, err := s.getItems(r.Context(), t, q)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		observeResults("items", d.ID(), len(features), matched)
+		itemsURL := s.baseURL(r) + "/collections/" + d.ID() + "/items"
+		res := FeatureCollection{
+			Type:           "FeatureCollection",
+			Features:       features,
+			NumberMatched:  numberMatched(matched),
+			NumberReturned: len(features),
+			TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+			Links: []Link{
+				{Href: itemsURL + "?" + values.Encode(), Rel: "self", Type: MIMEGeoJSON, Title: "this document"},
+				{Href: s.baseURL(r) + "/collections/" + d.ID(), Rel: "collection", Type: MIMEJSON, Title: "the collection"},
+			},
+		}
+		if more {
+			res.Links = append(res.Links, Link{Href: pageLink(itemsURL, values, q.Offset+q.Limit), Rel: "next", Type: MIMEGeoJSON, Title: "next page"})
+		}
+		if q.Offset > 0 {
+			res.Links = append(res.Links, Link{Href: pageLink(itemsURL, values, max(0, q.Offset-q.Limit)), Rel: "prev", Type: MIMEGeoJSON, Title: "previous page"})
+		}
+		w.Header().Set("Content-Crs", "<"+crsURI(q.OutSrid)+">")
+		s.writeJSON(w, r, MIMEGeoJSON, res)
+	}
+}
+
+// GetItemHandler serves /collections/{id}/items/{featureId}
+func (s *Service) GetItemHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.getDataset(r.Context(), r.PathValue("id"))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		for name := range r.URL.Query() {
+			if name != "crs" && name != "f" {
+				s.writeError(w, r, invalidParameter("unknown parameter %q", name))
+				return
+			}
+		}
+		outSrid, err := parseCrs(r.URL.Query().Get("crs"), d)
+		if err != nil {
+			s.writeError(w, r, invalidParameter("%v", err))
+			return
+		}
+		t := newTable(d, s.db.Dialect())
+		f, err := s.getItem(r.Context(), t, r.PathValue("featureId"), outSrid)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		collectionURL := s.baseURL(r) + "/collections/" + d.ID()
+		f.Links = []Link{
+			{Href: collectionURL + "/items/" + url.PathEscape(r.PathValue("featureId")), Rel: "self", Type: MIMEGeoJSON, Title: "this document"},
+			{Href: collectionURL, Rel: "collection", Type: MIMEJSON, Title: "the collection"},
+		}
+		w.Header().Set("Content-Crs", "<"+crsURI(outSrid)+">")
+		s.writeJSON(w, r, MIMEGeoJSON, f)
+	}
+}
@@ -0,0 +1,438 @@
+package features
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/cql2"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geopackage"
+)
+
+var (
+	ErrInvalidParameter = errors.New("invalid parameter")
+	ErrFeatureNotFound  = errors.New("feature not found")
+)
+
+// reservedParameters are the query parameters that are not property filters
+var reservedParameters = map[string]bool{
+	"limit": true, "offset": true, "count": true, "bbox": true, "bbox-crs": true, "crs": true, "datetime": true, "f": true,
+	"filter": true, "filter-lang": true, "filter-crs": true,
+}
+
+// temporalTypes are the column types, postgres or GeoPackage, the datetime parameter applies to
+var temporalTypes = map[string]bool{"date": true, "timestamp": true, "timestamptz": true, "datetime": true}
+
+// skippedTypes are the columns that are not returned in the properties
+var skippedTypes = map[string]bool{"tsvector": true, "bytea": true, "blob": true, "geometry": true, "geography": true}
+
+// ItemsQuery are the parsed parameters of a request to /collections/{id}/items
+type ItemsQuery struct {
+	Limit      int
+	Offset     int
+	Bbox       *geom.Envelope
+	BboxSrid   int
+	Start, End *time.Time // the datetime interval, nil when open
+	Properties map[string]string
+	Filter     cql2.Expr // nil without filter parameter
+	FilterSrid int
+	OutSrid    int
+	// CountMatched asks for numberMatched, a count of all the matching features that is too slow for every page
+	CountMatched bool
+}
+
+// table is what the sql builders need to know about a dataset
+type table struct {
+	d          *dataset.Dataset
+	idColumn   string   // primary key, rowid on a GeoPackage without one, empty if none
+	columns    []string // the properties, in table order
+	temporal   *dataset.Column
+	geometry   string
+	quotedName string
+}
+
+func newTable(d *dataset.Dataset, dialect database.Dialect) *table {
+	t := &table{d: d, geometry: "t." + dataset.QuoteIdentifier(d.GeometryColumn), quotedName: d.QualifiedName()}
+	for i, c := range d.Columns {
+		if c.PrimaryKey && t.idColumn == "" {
+			t.idColumn = c.Name
+			continue
+		}
+		typ := strings.ToLower(c.Type)
+		if c.Name == d.GeometryColumn || skippedTypes[typ] {
+			continue
+		}
+		if temporalTypes[typ] && t.temporal == nil {
+			t.temporal = &d.Columns[i]
+		}
+		t.columns = append(t.columns, c.Name)
+	}
+	if t.idColumn == "" && dialect == database.DialectSqlite {
+		t.idColumn = "rowid"
+	}
+	return t
+}
+
+func (t *table) hasColumn(name string) bool {
+	for _, c := range t.columns {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidParameter(format string, v ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidParameter, fmt.Sprintf(format, v...))
+}
+
+// parseDatetime parses a RFC 3339 date-time or a date, end tells to take the last instant of a date
+func parseDatetime(value string, end bool) (*time.Time, error) {
+	if value == "" || value == ".." {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, invalidParameter("datetime %q is neither a RFC 3339 date-time nor a date", value)
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Millisecond)
+	}
+	return &t, nil
+}
+
+// parseItemsQuery checks and parses the query parameters of an items request on the table t
+func parseItemsQuery(values url.Values, t *table) (*ItemsQuery, error) {
+	q := &ItemsQuery{Limit: DefaultLimit, Properties: map[string]string{}}
+	var err error
+	for name := range values {
+		if !reservedParameters[name] && !t.hasColumn(name) {
+			return nil, invalidParameter("unknown parameter %q", name)
+		}
+	}
+	if v := values.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit < 1 {
+			return nil, invalidParameter("limit should be a positive integer, got %q", v)
+		}
+		// the spec asks to use the maximum instead of refusing a larger limit
+		q.Limit = min(q.Limit, MaxLimit)
+	}
+	if v := values.Get("offset"); v != "" {
+		if q.Offset, err = strconv.Atoi(v); err != nil || q.Offset < 0 {
+			return nil, invalidParameter("offset should be a positive integer, got %q", v)
+		}
+	}
+	if v := values.Get("count"); v != "" {
+		if q.CountMatched, err = strconv.ParseBool(v); err != nil {
+			return nil, invalidParameter("count should be true or false, got %q", v)
+		}
+	}
+	if q.OutSrid, err = parseCrs(values.Get("crs"), t.d); err != nil {
+		return nil, invalidParameter("%v", err)
+	}
+	if v := values.Get("bbox"); v != "" {
+		parts := strings.Split(v, ",")
+		if len(parts) != 4 && len(parts) != 6 {
+			return nil, invalidParameter("bbox should have 4 or 6 numbers, got %q", v)
+		}
+		n := make([]float64, len(parts))
+		for i, part := range parts {
+			if n[i], err = strconv.ParseFloat(strings.TrimSpace(part), 64); err != nil {
+				return nil, invalidParameter("bbox should have 4 or 6 numbers, got %q", v)
+			}
+		}
+		if len(n) == 6 {
+			// the z range is ignored, the geometries are 2D
+			n = []float64{n[0], n[1], n[3], n[4]}
+		}
+		q.Bbox = &geom.Envelope{MinX: n[0], MinY: n[1], MaxX: n[2], MaxY: n[3]}
+		if q.BboxSrid, err = parseCrs(values.Get("bbox-crs"), t.d); err != nil {
+			return nil, invalidParameter("bbox-crs : %v", err)
+		}
+	} else if values.Get("bbox-crs") != "" {
+		return nil, invalidParameter("bbox-crs is only allowed with bbox")
+	}
+	if v := values.Get("datetime"); v != "" {
+		start, end, isInterval := strings.Cut(v, "/")
+		if !isInterval {
+			end = start
+		}
+		if q.Start, err = parseDatetime(start, false); err != nil {
+			return nil, err
+		}
+		if q.End, err = parseDatetime(end, true); err != nil {
+			return nil, err
+		}
+		if !isInterval && q.Start == nil {
+			return nil, invalidParameter("datetime %q is not a valid instant", v)
+		}
+	}
+	for _, c := range t.columns {
+		if v, ok := values[c]; ok {
+			q.Properties[c] = v[0]
+		}
+	}
+	if lang := values.Get("filter-lang"); lang != "" && lang != "cql2-text" {
+		return nil, invalidParameter("filter-lang %q is not supported, use cql2-text", lang)
+	}
+	if v := values.Get("filter"); v != "" {
+		if q.Filter, err = cql2.Parse(v); err != nil {
+			return nil, invalidParameter("%v", err)
+		}
+		if q.FilterSrid, err = parseCrs(values.Get("filter-crs"), t.d); err != nil {
+			return nil, invalidParameter("filter-crs : %v", err)
+		}
+	}
+	return q, nil
+}
+
+// filterSchema returns what the cql2 translator needs to know about t
+func (t *table) filterSchema(filterSrid int, db database.DB) *cql2.Schema {
+	s := &cql2.Schema{Alias: "t", Properties: map[string]cql2.Kind{}, Geometry: t.d.GeometryColumn, SrsID: t.d.SrsID, FilterSrid: filterSrid}
+	for _, c := range t.d.Columns {
+		s.Properties[c.Name] = cql2.KindOfType(c.Type)
+	}
+	s.Properties[t.d.GeometryColumn] = cql2.KindGeometry
+	if spatial, ok := db.(interface{ HasSpatialite() bool }); ok {
+		s.Spatialite = spatial.HasSpatialite()
+		if rtree := geopackage.RtreeName(t.d.TableName, t.d.GeometryColumn); db.DoesTableExist("", rtree) {
+			s.Rtree = rtree
+		}
+	}
+	return s
+}
+
+// where returns the sql condition of q on t with its arguments added to p, 1=1 when there is no filter
+func (t *table) where(q *ItemsQuery, p *database.Params, db database.DB) (string, error) {
+	conditions := []string{"1=1"}
+	if q.Bbox != nil {
+		if p.Dialect() == database.DialectPostgres {
+			envelope := fmt.Sprintf("ST_MakeEnvelope(%s, %s, %s, %s, %d)",
+				p.Add(q.Bbox.MinX), p.Add(q.Bbox.MinY), p.Add(q.Bbox.MaxX), p.Add(q.Bbox.MaxY), q.BboxSrid)
+			if q.BboxSrid != t.d.SrsID {
+				envelope = fmt.Sprintf("ST_Transform(%s, %d)", envelope, t.d.SrsID)
+			}
+			conditions = append(conditions, fmt.Sprintf("%s && %s", t.geometry, envelope))
+		} else {
+			toStorage, err := geom.GetTransform(q.BboxSrid, t.d.SrsID)
+			if err != nil {
+				return "", invalidParameter("bbox-crs cannot be used with this collection : %v", err)
+			}
+			e := geom.TransformEnvelope(*q.Bbox, toStorage)
+			rtree := geopackage.RtreeName(t.d.TableName, t.d.GeometryColumn)
+			if db.DoesTableExist("", rtree) {
+				conditions = append(conditions, fmt.Sprintf("t.rowid IN (SELECT id FROM %s WHERE maxx >= %s AND minx <= %s AND maxy >= %s AND miny <= %s)",
+					dataset.QuoteIdentifier(rtree), p.Add(e.MinX), p.Add(e.MaxX), p.Add(e.MinY), p.Add(e.MaxY)))
+			} else {
+				// without rtree the envelopes of all the geometries are read, slower but still a valid bbox query
+				conditions = append(conditions, fmt.Sprintf("%s(%s, %s, %s, %s, %s)", database.GpkgEnvelopeIntersects,
+					t.geometry, p.Add(e.MinX), p.Add(e.MinY), p.Add(e.MaxX), p.Add(e.MaxY)))
+			}
+		}
+	}
+	if t.temporal != nil && (q.Start != nil || q.End != nil) {
+		column := "t." + dataset.QuoteIdentifier(t.temporal.Name)
+		bind := func(v time.Time) string {
+			if p.Dialect() == database.DialectPostgres {
+				return p.Add(v)
+			}
+			// GeoPackage dates are ISO 8601 strings that compare in chronological order
+			if strings.EqualFold(t.temporal.Type, "date") {
+				return p.Add(v.UTC().Format(time.DateOnly))
+			}
+			return p.Add(v.UTC().Format("2006-01-02T15:04:05.000Z"))
+		}
+		if q.Start != nil {
+			conditions = append(conditions, fmt.Sprintf("%s >= %s", column, bind(*q.Start)))
+		}
+		if q.End != nil {
+			conditions = append(conditions, fmt.Sprintf("%s <= %s", column, bind(*q.End)))
+		}
+	}
+	for _, c := range t.columns {
+		v, ok := q.Properties[c]
+		if !ok {
+			continue
+		}
+		column := "t." + dataset.QuoteIdentifier(c)
+		if p.Dialect() == database.DialectPostgres {
+			// comparing as text accepts the value of any column type, the prepared statement would else refuse it
+			column += "::text"
+		}
+		conditions = append(conditions, fmt.Sprintf("%s = %s", column, p.Add(v)))
+	}
+	if q.Filter != nil {
+		condition, err := cql2.ToSql(q.Filter, t.filterSchema(q.FilterSrid, db), p)
+		if err != nil {
+			return "", fmt.Errorf("%w: filter : %w", ErrInvalidParameter, err)
+		}
+		conditions = append(conditions, condition)
+	}
+	return strings.Join(conditions, " AND "), nil
+}
+
+// selectSql returns the select list : the geometry, the id when there is one and the properties
+func (t *table) selectSql(outSrid int, dialect database.Dialect) string {
+	g := t.geometry
+	if dialect == database.DialectPostgres && outSrid != t.d.SrsID {
+		g = fmt.Sprintf("ST_Transform(%s, %d)", g, outSrid)
+	}
+	cols := []string{g}
+	if t.idColumn != "" {
+		cols = append(cols, "t."+dataset.QuoteIdentifier(t.idColumn))
+	}
+	for _, c := range t.columns {
+		cols = append(cols, "t."+dataset.QuoteIdentifier(c))
+	}
+	return strings.Join(cols, ", ")
+}
+
+// scanFeature reads the current row of a query on selectSql followed by the extra columns
+func (t *table) scanFeature(rows database.Rows, extra ...interface{}) (Feature, error) {
+	f := Feature{Type: "Feature", Properties: map[string]interface{}{}}
+	var id interface{}
+	values := make([]interface{}, len(t.columns))
+	dest := []interface{}{&f.Geometry}
+	if t.idColumn != "" {
+		dest = append(dest, &id)
+	}
+	for i := range values {
+		dest = append(dest, &values[i])
+	}
+	if err := rows.Scan(append(dest, extra...)...); err != nil {
+		return f, err
+	}
+	f.ID = jsonValue(id)
+	for i, c := range t.columns {
+		f.Properties[c] = jsonValue(values[i])
+	}
+	return f, nil
+}
+
+// scanFeatures reads the rows of a query on selectSql, the GeoPackage geometries are transformed here to outSrid
+func (t *table) scanFeatures(rows database.Rows, outSrid int, dialect database.Dialect) ([]Feature, error) {
+	var transform geom.TransformFunc
+	if dialect == database.DialectSqlite && outSrid != t.d.SrsID {
+		var err error
+		if transform, err = geom.GetTransform(t.d.SrsID, outSrid); err != nil {
+			return nil, err
+		}
+	}
+	features := []Feature{}
+	for rows.Next() {
+		f, err := t.scanFeature(rows)
+		if err != nil {
+			return nil, err
+		}
+		if transform != nil && f.Geometry.Geometry != nil {
+			f.Geometry.Geometry = geom.Transform(f.Geometry.Geometry, transform)
+		}
+		f.Geometry.SRID = outSrid
+		features = append(features, f)
+	}
+	return features, rows.Err()
+}
+
+// jsonValue converts the text returned as bytes by sqlite
+func jsonValue(v interface{}) interface{} {
+	if b, isBytes := v.([]byte); isBytes {
+		return string(b)
+	}
+	return v
+}
+
+// countItems returns the number of features of t matching q, without limit and offset
+func (s *Service) countItems(ctx context.Context, t *table, q *ItemsQuery) (int, error) {
+	p := database.NewParams(s.db.Dialect())
+	where, err := t.where(q, p, s.db)
+	if err != nil {
+		return 0, err
+	}
+	var matched int
+	rows, err := s.db.Query(ctx, fmt.Sprintf("SELECT count(*) FROM %s t WHERE %s;", t.quotedName, where), p.Values()...)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		if err := rows.Scan(&matched); err != nil {
+			return 0, err
+		}
+	}
+	return matched, rows.Err()
+}
+
+// getItems returns the features of t matching q, if more are matching after them, and the number of features
+// matching without limit and offset when q.CountMatched, -1 otherwise
+func (s *Service) getItems(ctx context.Context, t *table, q *ItemsQuery) ([]Feature, bool, int, error) {
+	dialect := s.db.Dialect()
+	matched := -1
+	if q.CountMatched {
+		var err error
+		if matched, err = s.countItems(ctx, t, q); err != nil {
+			return nil, false, 0, err
+		}
+	}
+	p := database.NewParams(dialect)
+	where, err := t.where(q, p, s.db)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	sqlText := fmt.Sprintf("SELECT %s FROM %s t WHERE %s", t.selectSql(q.OutSrid, dialect), t.quotedName, where)
+	if t.idColumn != "" {
+		sqlText += " ORDER BY t." + dataset.QuoteIdentifier(t.idColumn)
+	}
+	// one more feature than the limit tells if there is a next page without counting them all
+	sqlText += fmt.Sprintf(" LIMIT %s OFFSET %s;", p.Add(q.Limit+1), p.Add(q.Offset))
+	rows, err := s.db.Query(ctx, sqlText, p.Values()...)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	defer rows.Close()
+	features, err := t.scanFeatures(rows, q.OutSrid, dialect)
+	if err != nil {
+		return nil, false, 0, err
+	}
+	more := len(features) > q.Limit
+	if more {
+		features = features[:q.Limit]
+	}
+	return features, more, matched, nil
+}
+
+// getItem returns the feature of t with the given id
+func (s *Service) getItem(ctx context.Context, t *table, id string, outSrid int) (*Feature, error) {
+	if t.idColumn == "" {
+		return nil, fmt.Errorf("%w: collection %s has no primary key", ErrFeatureNotFound, t.d.ID())
+	}
+	dialect := s.db.Dialect()
+	p := database.NewParams(dialect)
+	column := "t." + dataset.QuoteIdentifier(t.idColumn)
+	if dialect == database.DialectPostgres {
+		column += "::text"
+	}
+	sqlText := fmt.Sprintf("SELECT %s FROM %s t WHERE %s = %s;", t.selectSql(outSrid, dialect), t.quotedName, column, p.Add(id))
+	rows, err := s.db.Query(ctx, sqlText, p.Values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	features, err := t.scanFeatures(rows, outSrid, dialect)
+	if err != nil {
+		return nil, err
+	}
+	if len(features) == 0 {
+		return nil, fmt.Errorf("%w: %s in %s", ErrFeatureNotFound, id, t.d.ID())
+	}
+	return &features[0], nil
+}
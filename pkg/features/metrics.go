@@ -0,0 +1,21 @@
+package features
+
+import "github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/metrics"
+
+var (
+	resultBuckets   = []float64{0, 1, 5, 10, 50, 100, 500, 1000, 5000, 10000}
+	resultsReturned = metrics.Default.NewHistogramVec("features_results_returned",
+		"Number of features returned by a search, by endpoint and collection.", resultBuckets, "endpoint", "collection")
+	resultsMatched = metrics.Default.NewHistogramVec("features_results_matched",
+		"Number of features matching a search, by endpoint and collection.", resultBuckets, "endpoint", "collection")
+)
+
+// observeResults records the size of the answer to a search, returned or matched are -1 when they are unknown
+func observeResults(endpoint, collection string, returned, matched int) {
+	if returned >= 0 {
+		resultsReturned.Observe(float64(returned), endpoint, collection)
+	}
+	if matched >= 0 {
+		resultsMatched.Observe(float64(matched), endpoint, collection)
+	}
+}
@@ -0,0 +1,303 @@
+package features
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geopackage"
+)
+
+const (
+	DefaultNearestCount = 10
+	MaxNearestCount     = 1000
+	// nearestStartRadius is the first distance in meters searched in a GeoPackage rtree, multiplied by 4 until enough features are found
+	nearestStartRadius = 100.0
+	// nearestCandidatesFactor times k features nearest in degrees hold the k nearest in meters, except close to the poles
+	nearestCandidatesFactor = 10
+	nearestMinCandidates    = 100
+)
+
+// nearestParameters are the query parameters of the nearest search
+var nearestParameters = map[string]bool{"x": true, "y": true, "k": true, "max_distance": true, "crs": true, "f": true}
+
+// NearestQuery asks for the Count features nearest to Point, given in Srid, not farther than MaxDistance meters when it is not 0
+type NearestQuery struct {
+	Point       geom.Coord
+	Srid        int
+	Count       int
+	MaxDistance float64
+}
+
+// NearestFeature is a feature with its distance in meters to the point of the query
+type NearestFeature struct {
+	Feature
+	Distance float64 `json:"distance"`
+}
+
+type NearestCollection struct {
+	Type           string           `json:"type"`
+	Features       []NearestFeature `json:"features"`
+	Links          []Link           `json:"links"`
+	NumberReturned int              `json:"numberReturned"`
+	TimeStamp      string           `json:"timeStamp"`
+}
+
+// parseNearestQuery checks the query parameters of a nearest search on the collection t
+func parseNearestQuery(values url.Values, t *table) (*NearestQuery, error) {
+	q := &NearestQuery{Count: DefaultNearestCount}
+	for name := range values {
+		if !nearestParameters[name] {
+			return nil, invalidParameter("unknown parameter %q", name)
+		}
+	}
+	var err error
+	for _, c := range []struct {
+		name  string
+		value *float64
+	}{{"x", &q.Point.X}, {"y", &q.Point.Y}} {
+		v := values.Get(c.name)
+		if v == "" {
+			return nil, invalidParameter("%s is required", c.name)
+		}
+		if *c.value, err = strconv.ParseFloat(v, 64); err != nil || math.IsNaN(*c.value) || math.IsInf(*c.value, 0) {
+			return nil, invalidParameter("%s should be a number, got %q", c.name, v)
+		}
+	}
+	if v := values.Get("k"); v != "" {
+		if q.Count, err = strconv.Atoi(v); err != nil || q.Count < 1 {
+			return nil, invalidParameter("k should be a positive integer, got %q", v)
+		}
+		q.Count = min(q.Count, MaxNearestCount)
+	}
+	if v := values.Get("max_distance"); v != "" {
+		if q.MaxDistance, err = strconv.ParseFloat(v, 64); err != nil || !(q.MaxDistance > 0) || math.IsInf(q.MaxDistance, 0) {
+			return nil, invalidParameter("max_distance should be a positive number of meters, got %q", v)
+		}
+	}
+	if q.Srid, err = parseCrs(values.Get("crs"), t.d); err != nil {
+		return nil, invalidParameter("%v", err)
+	}
+	return q, nil
+}
+
+// getNearest returns the features of t nearest to the point of q, sorted by distance
+func (s *Service) getNearest(ctx context.Context, t *table, q *NearestQuery) ([]NearestFeature, error) {
+	if s.db.Dialect() == database.DialectPostgres {
+		return s.postgresNearest(ctx, t, q)
+	}
+	return s.sqliteNearest(ctx, t, q)
+}
+
+// postgresNearest lets PostGIS walk its gist index with the <-> operator, a table in degrees is sorted in meters
+// among nearestCandidatesFactor times more candidates
+func (s *Service) postgresNearest(ctx context.Context, t *table, q *NearestQuery) ([]NearestFeature, error) {
+	p := database.NewParams(database.DialectPostgres)
+	// the placeholders of the point are used several times, which postgres allows
+	point := fmt.Sprintf("ST_SetSRID(ST_MakePoint(%s::float8, %s::float8), %d)", p.Add(q.Point.X), p.Add(q.Point.Y), q.Srid)
+	if q.Srid != t.d.SrsID {
+		point = fmt.Sprintf("ST_Transform(%s, %d)", point, t.d.SrsID)
+	}
+	var sqlText string
+	if t.d.SrsID == geom.SridWgs84 {
+		// <-> on the geography cannot use the gist index of the geometry column, the index gives the candidates
+		// nearest in degrees and only them are sorted by their exact distance in meters
+		var within string
+		if q.MaxDistance > 0 {
+			within = fmt.Sprintf(" WHERE t.nearest_distance <= %s::float8", p.Add(q.MaxDistance))
+		}
+		sqlText = fmt.Sprintf(`WITH candidates AS MATERIALIZED (SELECT t.*, ST_Distance(%s::geography, %s::geography) AS nearest_distance
+			FROM %s t WHERE %s IS NOT NULL ORDER BY %s <-> %s LIMIT %s)
+			SELECT %s, t.nearest_distance FROM candidates t%s ORDER BY t.nearest_distance LIMIT %s;`,
+			t.geometry, point, t.quotedName, t.geometry, t.geometry, point, p.Add(max(q.Count*nearestCandidatesFactor, nearestMinCandidates)),
+			t.selectSql(q.Srid, database.DialectPostgres), within, p.Add(q.Count))
+	} else {
+		scale := 1.0
+		if toStorage, err := geom.GetTransform(q.Srid, t.d.SrsID); err == nil {
+			scale, _ = geom.MetersPerUnit(t.d.SrsID, toStorage(q.Point))
+		}
+		var within string
+		if q.MaxDistance > 0 {
+			within = fmt.Sprintf(" AND ST_DWithin(%s, %s, %s::float8)", t.geometry, point, p.Add(q.MaxDistance/scale))
+		}
+		sqlText = fmt.Sprintf("SELECT %s, ST_Distance(%s, %s) * %s::float8 FROM %s t WHERE %s IS NOT NULL%s ORDER BY %s <-> %s LIMIT %s;",
+			t.selectSql(q.Srid, database.DialectPostgres), t.geometry, point, p.Add(scale), t.quotedName, t.geometry, within,
+			t.geometry, point, p.Add(q.Count))
+	}
+	rows, err := s.db.Query(ctx, sqlText, p.Values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	res := []NearestFeature{}
+	for rows.Next() {
+		var f NearestFeature
+		if f.Feature, err = t.scanFeature(rows, &f.Distance); err != nil {
+			return nil, err
+		}
+		f.Geometry.SRID = q.Srid
+		res = append(res, f)
+	}
+	return res, rows.Err()
+}
+
+// sqliteNearest searches the GeoPackage rtree in a window growing around the point until it holds enough features
+// nearer than its radius, the distances are computed in go on the decoded geometries. SpatiaLite's KNN2 cannot
+// be used here since it only knows the SpatiaLite spatial indexes and not the rtree of a GeoPackage.
+func (s *Service) sqliteNearest(ctx context.Context, t *table, q *NearestQuery) ([]NearestFeature, error) {
+	toStorage, err := geom.GetTransform(q.Srid, t.d.SrsID)
+	if err != nil {
+		return nil, err
+	}
+	toOut, err := geom.GetTransform(t.d.SrsID, q.Srid)
+	if err != nil {
+		return nil, err
+	}
+	center := toStorage(q.Point)
+	sx, sy := geom.MetersPerUnit(t.d.SrsID, center)
+	toMeters := func(c geom.Coord) geom.Coord {
+		return geom.Coord{X: (c.X - center.X) * sx, Y: (c.Y - center.Y) * sy}
+	}
+
+	limit := math.Inf(1)
+	if q.MaxDistance > 0 {
+		limit = q.MaxDistance
+	}
+	rtree := geopackage.RtreeName(t.d.TableName, t.d.GeometryColumn)
+	radius := limit
+	if s.db.DoesTableExist("", rtree) {
+		extent, err := s.rtreeExtent(ctx, rtree)
+		if err != nil {
+			return nil, err
+		}
+		if extent.IsEmpty() {
+			return []NearestFeature{}, nil
+		}
+		// beyond the farthest corner of the table the window holds all the features
+		farthest := 0.0
+		for _, corner := range []geom.Coord{{X: extent.MinX, Y: extent.MinY}, {X: extent.MinX, Y: extent.MaxY}, {X: extent.MaxX, Y: extent.MinY}, {X: extent.MaxX, Y: extent.MaxY}} {
+			m := toMeters(corner)
+			farthest = math.Max(farthest, math.Hypot(m.X, m.Y))
+		}
+		limit = math.Min(limit, farthest+1)
+		radius = math.Min(nearestStartRadius, limit)
+	} else {
+		rtree = ""
+	}
+
+	for {
+		found, err := s.sqliteNearestIn(ctx, t, rtree, center, radius, sx, sy, toMeters)
+		if err != nil {
+			return nil, err
+		}
+		if len(found) >= q.Count || radius >= limit {
+			sort.SliceStable(found, func(i, j int) bool { return found[i].Distance < found[j].Distance })
+			if len(found) > q.Count {
+				found = found[:q.Count]
+			}
+			for i := range found {
+				if q.Srid != t.d.SrsID {
+					found[i].Geometry.Geometry = geom.Transform(found[i].Geometry.Geometry, toOut)
+				}
+				found[i].Geometry.SRID = q.Srid
+			}
+			return found, nil
+		}
+		radius = math.Min(radius*4, limit)
+	}
+}
+
+// sqliteNearestIn returns the features of t nearer than radius meters, read through the rtree when there is one
+func (s *Service) sqliteNearestIn(ctx context.Context, t *table, rtree string, center geom.Coord, radius, sx, sy float64,
+	toMeters geom.TransformFunc) ([]NearestFeature, error) {
+	p := database.NewParams(database.DialectSqlite)
+	sqlText := fmt.Sprintf("SELECT %s FROM %s t WHERE %s IS NOT NULL", t.selectSql(t.d.SrsID, database.DialectSqlite), t.quotedName, t.geometry)
+	if rtree != "" && !math.IsInf(radius, 1) {
+		dx, dy := radius/sx, radius/sy
+		sqlText += fmt.Sprintf(" AND t.rowid IN (SELECT id FROM %s WHERE maxx >= %s AND minx <= %s AND maxy >= %s AND miny <= %s)",
+			dataset.QuoteIdentifier(rtree), p.Add(center.X-dx), p.Add(center.X+dx), p.Add(center.Y-dy), p.Add(center.Y+dy))
+	}
+	rows, err := s.db.Query(ctx, sqlText+";", p.Values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	found := []NearestFeature{}
+	for rows.Next() {
+		f, err := t.scanFeature(rows)
+		if err != nil {
+			return nil, err
+		}
+		if f.Geometry.Geometry == nil {
+			continue
+		}
+		d := geom.Distance(geom.Transform(f.Geometry.Geometry, toMeters), geom.Coord{})
+		if d <= radius {
+			found = append(found, NearestFeature{Feature: f, Distance: d})
+		}
+	}
+	return found, rows.Err()
+}
+
+// rtreeExtent returns the envelope of all the entries of a GeoPackage rtree
+func (s *Service) rtreeExtent(ctx context.Context, rtree string) (geom.Envelope, error) {
+	e := geom.EmptyEnvelope()
+	rows, err := s.db.Query(ctx, fmt.Sprintf("SELECT min(minx), min(miny), max(maxx), max(maxy) FROM %s;", dataset.QuoteIdentifier(rtree)))
+	if err != nil {
+		return e, err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var minX, minY, maxX, maxY *float64
+		if err := rows.Scan(&minX, &minY, &maxX, &maxY); err != nil {
+			return e, err
+		}
+		if minX != nil && minY != nil && maxX != nil && maxY != nil {
+			e = geom.Envelope{MinX: *minX, MinY: *minY, MaxX: *maxX, MaxY: *maxY}
+		}
+	}
+	return e, rows.Err()
+}
+
+// GetNearestHandler serves GET /api/nearest/{id}?x=&y=&k=&max_distance=&crs= with the k features of the collection
+// nearest to the point x, y, given like the returned geometries in crs, CRS84 by default
+func (s *Service) GetNearestHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		d, err := s.getDataset(r.Context(), r.PathValue("id"))
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		t := newTable(d, s.db.Dialect())
+		q, err := parseNearestQuery(r.URL.Query(), t)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		features, err := s.getNearest(r.Context(), t, q)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		observeResults("nearest", d.ID(), len(features), -1)
+		res := NearestCollection{
+			Type:           "FeatureCollection",
+			Features:       features,
+			NumberReturned: len(features),
+			TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+			Links: []Link{
+				{Href: s.baseURL(r) + "/collections/" + d.ID(), Rel: "collection", Type: MIMEJSON, Title: "the collection"},
+			},
+		}
+		w.Header().Set("Content-Crs", "<"+crsURI(q.Srid)+">")
+		s.writeJSON(w, r, MIMEGeoJSON, res)
+	}
+}
@@ -0,0 +1,151 @@
+package features
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/cql2"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+const (
+	DefaultSpatialQueryLimit = 1000
+	maxSpatialQueryBytes     = 8 << 20 // a detailed polygon of a district is a few hundred KB in GeoJSON
+)
+
+// DefaultSpatialQueryLayers are the collections the spatial query endpoint accepts
+var DefaultSpatialQueryLayers = []string{"adresses", "communes"}
+
+// spatialPredicates maps the predicates of a SpatialQuery to the cql2 spatial functions
+var spatialPredicates = map[string]string{"intersects": "S_INTERSECTS", "within": "S_WITHIN", "dwithin": "S_DWITHIN"}
+
+// SpatialQuery is the body of POST /api/spatial-query, like all addresses within 200 m of a line :
+// {"layer": "adresses", "predicate": "dwithin", "distance": 200, "geometry": {"type": "LineString", ...}}
+type SpatialQuery struct {
+	Layer     string `json:"layer"`
+	Predicate string `json:"predicate"`
+	// Distance in meters, only for dwithin
+	Distance float64         `json:"distance,omitempty"`
+	Geometry json.RawMessage `json:"geometry"`
+	// Crs of the geometry and of the returned features, CRS84 by default
+	Crs       string `json:"crs,omitempty"`
+	CountOnly bool   `json:"count_only,omitempty"`
+	// CountMatched adds numberMatched to the features, counting them all
+	CountMatched bool `json:"count_matched,omitempty"`
+	Limit        int  `json:"limit,omitempty"`
+	Offset       int  `json:"offset,omitempty"`
+}
+
+// SpatialCount is the answer to a SpatialQuery in count only mode
+type SpatialCount struct {
+	Layer         string `json:"layer"`
+	Predicate     string `json:"predicate"`
+	NumberMatched int    `json:"numberMatched"`
+}
+
+// toItemsQuery checks sq against the collection t and returns the query of its features
+func (sq *SpatialQuery) toItemsQuery(t *table) (*ItemsQuery, error) {
+	function, ok := spatialPredicates[strings.ToLower(sq.Predicate)]
+	if !ok {
+		return nil, invalidParameter("predicate %q is not one of intersects, within or dwithin", sq.Predicate)
+	}
+	if function == "S_DWITHIN" && sq.Distance <= 0 {
+		return nil, invalidParameter("dwithin needs a positive distance in meters")
+	}
+	if len(sq.Geometry) == 0 {
+		return nil, invalidParameter("geometry is required")
+	}
+	g, err := geom.UnmarshalGeoJSON(sq.Geometry)
+	if err != nil {
+		return nil, invalidParameter("%v", err)
+	}
+	if g.IsEmpty() {
+		return nil, invalidParameter("geometry is empty")
+	}
+	srid, err := parseCrs(sq.Crs, t.d)
+	if err != nil {
+		return nil, invalidParameter("%v", err)
+	}
+	q := &ItemsQuery{Limit: DefaultSpatialQueryLimit, Offset: sq.Offset, OutSrid: srid, FilterSrid: srid, Properties: map[string]string{},
+		CountMatched: sq.CountMatched}
+	if sq.Limit != 0 {
+		if sq.Limit < 0 {
+			return nil, invalidParameter("limit should be a positive integer, got %d", sq.Limit)
+		}
+		q.Limit = min(sq.Limit, MaxLimit)
+	}
+	if sq.Offset < 0 {
+		return nil, invalidParameter("offset should be a positive integer, got %d", sq.Offset)
+	}
+	q.Filter = cql2.Spatial{
+		Op:       function,
+		Left:     cql2.Property{Name: t.d.GeometryColumn},
+		Right:    cql2.GeometryLiteral{Geometry: g},
+		Distance: sq.Distance,
+	}
+	return q, nil
+}
+
+// PostSpatialQueryHandler serves POST /api/spatial-query, returning the features of one of layers matching
+// a spatial predicate with the GeoJSON geometry of the request, or only their number with count_only
+func (s *Service) PostSpatialQueryHandler(layers []string) http.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, layer := range layers {
+		allowed[layer] = true
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sq SpatialQuery
+		dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxSpatialQueryBytes))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&sq); err != nil {
+			s.writeError(w, r, invalidParameter("invalid request body : %v", err))
+			return
+		}
+		if !allowed[sq.Layer] {
+			s.writeError(w, r, invalidParameter("layer %q is not one of %s", sq.Layer, strings.Join(layers, ", ")))
+			return
+		}
+		d, err := s.getDataset(r.Context(), sq.Layer)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		t := newTable(d, s.db.Dialect())
+		q, err := sq.toItemsQuery(t)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		if sq.CountOnly {
+			matched, err := s.countItems(r.Context(), t, q)
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			observeResults("spatial_query", d.ID(), -1, matched)
+			s.writeJSON(w, r, MIMEJSON, SpatialCount{Layer: sq.Layer, Predicate: strings.ToLower(sq.Predicate), NumberMatched: matched})
+			return
+		}
+		features, more, matched, err := s.getItems(r.Context(), t, q)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		observeResults("spatial_query", d.ID(), len(features), matched)
+		res := FeatureCollection{
+			Type:           "FeatureCollection",
+			Features:       features,
+			NumberMatched:  numberMatched(matched),
+			NumberReturned: len(features),
+			TimeStamp:      time.Now().UTC().Format(time.RFC3339),
+			Links: []Link{
+				{Href: s.baseURL(r) + "/collections/" + d.ID(), Rel: "collection", Type: MIMEJSON, Title: "the collection"},
+			},
+			Truncated: more,
+		}
+		w.Header().Set("Content-Crs", "<"+crsURI(q.OutSrid)+">")
+		s.writeJSON(w, r, MIMEGeoJSON, res)
+	}
+}
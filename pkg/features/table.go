@@ -0,0 +1,112 @@
+package features
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+// the csv and html formats of the features have one row by feature, with its id, its properties and its geometry in WKT
+
+// propertyNames returns the sorted names of the properties of all the features
+func propertyNames(features []Feature) []string {
+	seen := map[string]bool{}
+	var names []string
+	for _, f := range features {
+		for name := range f.Properties {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func featureHeader(names []string) []string {
+	header := append([]string{"id"}, names...)
+	return append(header, "geometry")
+}
+
+func featureRow(f Feature, names []string) []string {
+	row := make([]string, 0, len(names)+2)
+	row = append(row, cell(f.ID))
+	for _, name := range names {
+		row = append(row, cell(f.Properties[name]))
+	}
+	wkt := ""
+	if f.Geometry.Geometry != nil {
+		wkt = geom.MarshalWKT(f.Geometry.Geometry)
+	}
+	return append(row, wkt)
+}
+
+// cell formats a property like in the GeoJSON, the dates in RFC 3339
+func cell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// TableHeader implements go_http_server.Table
+func (fc FeatureCollection) TableHeader() []string {
+	return featureHeader(propertyNames(fc.Features))
+}
+
+// TableRows implements go_http_server.Table
+func (fc FeatureCollection) TableRows() [][]string {
+	names := propertyNames(fc.Features)
+	rows := make([][]string, 0, len(fc.Features))
+	for _, f := range fc.Features {
+		rows = append(rows, featureRow(f, names))
+	}
+	return rows
+}
+
+// TableHeader implements go_http_server.Table
+func (f Feature) TableHeader() []string {
+	return featureHeader(propertyNames([]Feature{f}))
+}
+
+// TableRows implements go_http_server.Table
+func (f Feature) TableRows() [][]string {
+	return [][]string{featureRow(f, propertyNames([]Feature{f}))}
+}
+
+func (nc NearestCollection) features() []Feature {
+	features := make([]Feature, 0, len(nc.Features))
+	for _, f := range nc.Features {
+		features = append(features, f.Feature)
+	}
+	return features
+}
+
+// TableHeader implements go_http_server.Table, the distance in meters comes after the geometry
+func (nc NearestCollection) TableHeader() []string {
+	return append(featureHeader(propertyNames(nc.features())), "distance")
+}
+
+// TableRows implements go_http_server.Table
+func (nc NearestCollection) TableRows() [][]string {
+	names := propertyNames(nc.features())
+	rows := make([][]string, 0, len(nc.Features))
+	for _, f := range nc.Features {
+		rows = append(rows, append(featureRow(f.Feature, names), strconv.FormatFloat(f.Distance, 'f', -1, 64)))
+	}
+	return rows
+}
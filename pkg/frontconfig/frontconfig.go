@@ -0,0 +1,174 @@
+package frontconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/version"
+)
+
+const (
+	defaultWmtsURL  = "https://tilesmn95.lausanne.ch/tiles/1.0.0/LausanneWMTS.xml"
+	defaultBaseMap  = "fonds_geo_osm_bdcad_couleur"
+	defaultMapZoom  = 8
+	defaultMapSrsID = 2056
+)
+
+var (
+	defaultMapCenter = []float64{2538202, 1152364}                   // place St-François in Lausanne
+	defaultMapExtent = []float64{2532500, 1149000, 2545625, 1161000} // the territory of Lausanne
+	defaultBaseMaps  = []string{"orthophotos_ortho_lidar_2016", "fonds_geo_osm_bdcad_gris", "fonds_geo_osm_bdcad_couleur"}
+)
+
+// MapConfig is the initial state of the map of the front-end
+type MapConfig struct {
+	SrsID  int       `json:"srsId"`
+	Center []float64 `json:"center"`
+	Zoom   int       `json:"zoom"`
+	Extent []float64 `json:"extent"`
+	// WmtsURL is the url of the WMTS capabilities of the base maps
+	WmtsURL  string   `json:"wmtsUrl"`
+	BaseMaps []string `json:"baseMaps"`
+	BaseMap  string   `json:"baseMap"`
+	// Layers are the vector tiles layers the front-end can display
+	Layers []string `json:"layers"`
+}
+
+// Config is served on /config.json so one build of the front-end can be deployed in any environment
+type Config struct {
+	App        string    `json:"app"`
+	Version    string    `json:"version"`
+	Revision   string    `json:"revision"`
+	BuildStamp string    `json:"buildStamp"`
+	Repository string    `json:"repository"`
+	BackendURL string    `json:"backendUrl"`
+	Map        MapConfig `json:"map"`
+}
+
+// GetConfigFromEnv returns the front-end configuration, the defaults show Lausanne and can be changed with the
+// FRONT_* env variables, layers are the served vector tiles layers, all enabled when FRONT_MAP_LAYERS is not set
+func GetConfigFromEnv(layers []string) (*Config, error) {
+	c := &Config{
+		App:        version.APP,
+		Version:    version.VERSION,
+		Revision:   version.REVISION,
+		BuildStamp: version.BuildStamp,
+		Repository: version.REPOSITORY,
+		// an empty backend url means the same origin as the front-end
+		BackendURL: strings.TrimSuffix(os.Getenv("FRONT_BACKEND_URL"), "/"),
+		Map: MapConfig{
+			SrsID:    defaultMapSrsID,
+			Center:   defaultMapCenter,
+			Zoom:     defaultMapZoom,
+			Extent:   defaultMapExtent,
+			WmtsURL:  defaultWmtsURL,
+			BaseMaps: defaultBaseMaps,
+			BaseMap:  defaultBaseMap,
+			Layers:   layers,
+		},
+	}
+	var err error
+	if c.Map.Center, err = getNumbersFromEnv("FRONT_MAP_CENTER", 2, c.Map.Center); err != nil {
+		return nil, err
+	}
+	if c.Map.Extent, err = getNumbersFromEnv("FRONT_MAP_EXTENT", 4, c.Map.Extent); err != nil {
+		return nil, err
+	}
+	if c.Map.Extent[0] >= c.Map.Extent[2] || c.Map.Extent[1] >= c.Map.Extent[3] {
+		return nil, fmt.Errorf("FRONT_MAP_EXTENT should be minx,miny,maxx,maxy, got %v", c.Map.Extent)
+	}
+	if val, exist := os.LookupEnv("FRONT_MAP_ZOOM"); exist {
+		c.Map.Zoom, err = strconv.Atoi(val)
+		if err != nil || c.Map.Zoom < 0 {
+			return nil, fmt.Errorf("FRONT_MAP_ZOOM should be a positive integer, got %q", val)
+		}
+	}
+	if val, exist := os.LookupEnv("FRONT_MAP_SRS_ID"); exist {
+		c.Map.SrsID, err = strconv.Atoi(val)
+		if err != nil || c.Map.SrsID <= 0 {
+			return nil, fmt.Errorf("FRONT_MAP_SRS_ID should be an EPSG code, got %q", val)
+		}
+	}
+	if val, exist := os.LookupEnv("FRONT_WMTS_URL"); exist {
+		c.Map.WmtsURL = val
+	}
+	if val, exist := os.LookupEnv("FRONT_BASE_MAPS"); exist {
+		c.Map.BaseMaps = splitList(val)
+	}
+	if val, exist := os.LookupEnv("FRONT_BASE_MAP"); exist {
+		c.Map.BaseMap = val
+	}
+	if len(c.Map.BaseMaps) > 0 && !contains(c.Map.BaseMaps, c.Map.BaseMap) {
+		return nil, fmt.Errorf("FRONT_BASE_MAP %q is not one of the base maps %s", c.Map.BaseMap, strings.Join(c.Map.BaseMaps, ","))
+	}
+	if val, exist := os.LookupEnv("FRONT_MAP_LAYERS"); exist {
+		c.Map.Layers = splitList(val)
+		for _, layer := range c.Map.Layers {
+			if !contains(layers, layer) {
+				return nil, fmt.Errorf("FRONT_MAP_LAYERS %q is not one of the served tile layers %s", layer, strings.Join(layers, ","))
+			}
+		}
+	}
+	if c.Map.Layers == nil {
+		c.Map.Layers = []string{}
+	}
+	return c, nil
+}
+
+// getNumbersFromEnv parses the comma separated list of n numbers of the env variable name, or returns defaultValue
+func getNumbersFromEnv(name string, n int, defaultValue []float64) ([]float64, error) {
+	val, exist := os.LookupEnv(name)
+	if !exist {
+		return defaultValue, nil
+	}
+	parts := strings.Split(val, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("%s should be %d comma separated numbers, got %q", name, n, val)
+	}
+	numbers := make([]float64, n)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be %d comma separated numbers, got %q", name, n, val)
+		}
+		numbers[i] = v
+	}
+	return numbers, nil
+}
+
+func splitList(val string) []string {
+	list := []string{}
+	for _, item := range strings.Split(val, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// GetConfigHandler serves c in json, the browsers revalidate it at each load of the application
+func GetConfigHandler(c *Config, l golog.MyLogger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c); err != nil {
+			l.Error("GetConfigHandler failed to write config : %v", err)
+		}
+	}
+}
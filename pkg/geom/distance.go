@@ -0,0 +1,92 @@
+package geom
+
+import "math"
+
+// Distance returns the planar distance from c to the nearest point of g, 0 when c is inside a polygon of g
+// and +Inf for an empty geometry
+func Distance(g Geometry, c Coord) float64 {
+	switch t := g.(type) {
+	case *Point:
+		if t.Empty {
+			return math.Inf(1)
+		}
+		return math.Hypot(t.Coord.X-c.X, t.Coord.Y-c.Y)
+	case *LineString:
+		return distanceToLine(t.Coords, c)
+	case *Polygon:
+		return distanceToPolygon(t.Rings, c)
+	case *MultiPoint:
+		d := math.Inf(1)
+		for i := range t.Points {
+			d = math.Min(d, Distance(&t.Points[i], c))
+		}
+		return d
+	case *MultiLineString:
+		d := math.Inf(1)
+		for _, l := range t.LineStrings {
+			d = math.Min(d, distanceToLine(l.Coords, c))
+		}
+		return d
+	case *MultiPolygon:
+		d := math.Inf(1)
+		for _, p := range t.Polygons {
+			d = math.Min(d, distanceToPolygon(p.Rings, c))
+		}
+		return d
+	case *GeometryCollection:
+		d := math.Inf(1)
+		for _, child := range t.Geometries {
+			d = math.Min(d, Distance(child, c))
+		}
+		return d
+	}
+	return math.Inf(1)
+}
+
+// distanceToSegment returns the distance from c to the segment a b
+func distanceToSegment(a, b, c Coord) float64 {
+	dx, dy := b.X-a.X, b.Y-a.Y
+	if dx == 0 && dy == 0 {
+		return math.Hypot(c.X-a.X, c.Y-a.Y)
+	}
+	r := ((c.X-a.X)*dx + (c.Y-a.Y)*dy) / (dx*dx + dy*dy)
+	r = math.Max(0, math.Min(1, r))
+	return math.Hypot(c.X-(a.X+r*dx), c.Y-(a.Y+r*dy))
+}
+
+func distanceToLine(coords []Coord, c Coord) float64 {
+	switch len(coords) {
+	case 0:
+		return math.Inf(1)
+	case 1:
+		return math.Hypot(coords[0].X-c.X, coords[0].Y-c.Y)
+	}
+	d := math.Inf(1)
+	for i := 1; i < len(coords); i++ {
+		d = math.Min(d, distanceToSegment(coords[i-1], coords[i], c))
+	}
+	return d
+}
+
+// distanceToPolygon is 0 inside the exterior ring and outside the holes, else the distance to the nearest ring
+func distanceToPolygon(rings [][]Coord, c Coord) float64 {
+	if len(rings) == 0 {
+		return math.Inf(1)
+	}
+	inside := false
+	d := math.Inf(1)
+	for _, ring := range rings {
+		// even-odd rule over all the rings, a point in a hole crosses the exterior ring and the hole
+		for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+			a, b := ring[j], ring[i]
+			if (a.Y > c.Y) != (b.Y > c.Y) && c.X < (b.X-a.X)*(c.Y-a.Y)/(b.Y-a.Y)+a.X {
+				inside = !inside
+			}
+		}
+		d = math.Min(d, distanceToLine(ring, c))
+	}
+	if inside {
+		return 0
+	}
+	return d
+}
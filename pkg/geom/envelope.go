@@ -0,0 +1,96 @@
+package geom
+
+import "math"
+
+// Envelope is an axis aligned bounding box in the x,y plane
+type Envelope struct {
+	MinX, MinY, MaxX, MaxY float64
+}
+
+// EmptyEnvelope returns an envelope that contains nothing, ready to be extended
+func EmptyEnvelope() Envelope {
+	return Envelope{MinX: math.Inf(1), MinY: math.Inf(1), MaxX: math.Inf(-1), MaxY: math.Inf(-1)}
+}
+
+// IsEmpty returns true when the envelope contains no position
+func (e Envelope) IsEmpty() bool {
+	return e.MinX > e.MaxX || e.MinY > e.MaxY
+}
+
+// ExtendCoord grows the envelope to include c
+func (e *Envelope) ExtendCoord(c Coord) {
+	e.MinX = math.Min(e.MinX, c.X)
+	e.MinY = math.Min(e.MinY, c.Y)
+	e.MaxX = math.Max(e.MaxX, c.X)
+	e.MaxY = math.Max(e.MaxY, c.Y)
+}
+
+// Extend grows the envelope to include other
+func (e *Envelope) Extend(other Envelope) {
+	if other.IsEmpty() {
+		return
+	}
+	e.ExtendCoord(Coord{X: other.MinX, Y: other.MinY})
+	e.ExtendCoord(Coord{X: other.MaxX, Y: other.MaxY})
+}
+
+// Intersects returns true when the two envelopes share at least one position
+func (e Envelope) Intersects(other Envelope) bool {
+	if e.IsEmpty() || other.IsEmpty() {
+		return false
+	}
+	return e.MinX <= other.MaxX && other.MinX <= e.MaxX && e.MinY <= other.MaxY && other.MinY <= e.MaxY
+}
+
+// Contains returns true when c is inside or on the border of the envelope
+func (e Envelope) Contains(c Coord) bool {
+	return c.X >= e.MinX && c.X <= e.MaxX && c.Y >= e.MinY && c.Y <= e.MaxY
+}
+
+// Bounds returns the envelope as [min_x, min_y, max_x, max_y], the order used by GeoJSON bbox
+func (e Envelope) Bounds() [4]float64 {
+	return [4]float64{e.MinX, e.MinY, e.MaxX, e.MaxY}
+}
+
+// EnvelopeOf computes the envelope of g, it is empty for an empty geometry
+func EnvelopeOf(g Geometry) Envelope {
+	e := EmptyEnvelope()
+	extendWith(&e, g)
+	return e
+}
+
+func extendWith(e *Envelope, g Geometry) {
+	switch t := g.(type) {
+	case *Point:
+		if !t.Empty {
+			e.ExtendCoord(t.Coord)
+		}
+	case *LineString:
+		for _, c := range t.Coords {
+			e.ExtendCoord(c)
+		}
+	case *Polygon:
+		// the exterior ring contains all the holes
+		if len(t.Rings) > 0 {
+			for _, c := range t.Rings[0] {
+				e.ExtendCoord(c)
+			}
+		}
+	case *MultiPoint:
+		for i := range t.Points {
+			extendWith(e, &t.Points[i])
+		}
+	case *MultiLineString:
+		for i := range t.LineStrings {
+			extendWith(e, &t.LineStrings[i])
+		}
+	case *MultiPolygon:
+		for i := range t.Polygons {
+			extendWith(e, &t.Polygons[i])
+		}
+	case *GeometryCollection:
+		for _, part := range t.Geometries {
+			extendWith(e, part)
+		}
+	}
+}
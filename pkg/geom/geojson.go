@@ -0,0 +1,318 @@
+package geom
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var ErrInvalidGeoJSON = errors.New("invalid geojson geometry")
+
+var geoJSONTypeNames = map[GeometryType]string{
+	TypePoint:              "Point",
+	TypeLineString:         "LineString",
+	TypePolygon:            "Polygon",
+	TypeMultiPoint:         "MultiPoint",
+	TypeMultiLineString:    "MultiLineString",
+	TypeMultiPolygon:       "MultiPolygon",
+	TypeGeometryCollection: "GeometryCollection",
+}
+
+// MarshalGeoJSON returns the RFC 7946 GeoJSON geometry of g. M values are dropped, GeoJSON has no measure.
+func MarshalGeoJSON(g Geometry) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeGeoJSON(&buf, g, 0); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeGeoJSONCoord(buf *bytes.Buffer, c Coord, lay Layout) {
+	buf.WriteByte('[')
+	buf.WriteString(strconv.FormatFloat(c.X, 'f', -1, 64))
+	buf.WriteByte(',')
+	buf.WriteString(strconv.FormatFloat(c.Y, 'f', -1, 64))
+	if lay.HasZ() {
+		buf.WriteByte(',')
+		buf.WriteString(strconv.FormatFloat(c.Z, 'f', -1, 64))
+	}
+	buf.WriteByte(']')
+}
+
+func writeGeoJSONCoords(buf *bytes.Buffer, coords []Coord, lay Layout) {
+	buf.WriteByte('[')
+	for i, c := range coords {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeGeoJSONCoord(buf, c, lay)
+	}
+	buf.WriteByte(']')
+}
+
+func writeGeoJSONRings(buf *bytes.Buffer, rings [][]Coord, lay Layout) {
+	buf.WriteByte('[')
+	for i, ring := range rings {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		writeGeoJSONCoords(buf, ring, lay)
+	}
+	buf.WriteByte(']')
+}
+
+func writeGeoJSON(buf *bytes.Buffer, g Geometry, depth int) error {
+	if depth > maxWkbDepth {
+		return fmt.Errorf("%w: geometry collections nested too deeply", ErrInvalidGeoJSON)
+	}
+	name, ok := geoJSONTypeNames[g.Type()]
+	if !ok {
+		return fmt.Errorf("%w: unsupported type %s", ErrInvalidGeoJSON, g.Type())
+	}
+	lay := g.Layout()
+	buf.WriteString(`{"type":"`)
+	buf.WriteString(name)
+	if t, isCollection := g.(*GeometryCollection); isCollection {
+		buf.WriteString(`","geometries":[`)
+		for i, part := range t.Geometries {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeGeoJSON(buf, part, depth+1); err != nil {
+				return err
+			}
+		}
+		buf.WriteString("]}")
+		return nil
+	}
+	buf.WriteString(`","coordinates":`)
+	switch t := g.(type) {
+	case *Point:
+		if t.Empty {
+			buf.WriteString("[]")
+		} else {
+			writeGeoJSONCoord(buf, t.Coord, lay)
+		}
+	case *LineString:
+		writeGeoJSONCoords(buf, t.Coords, lay)
+	case *Polygon:
+		writeGeoJSONRings(buf, t.Rings, lay)
+	case *MultiPoint:
+		// an empty point has NaN coordinates, it has no position in a GeoJSON MultiPoint
+		buf.WriteByte('[')
+		written := 0
+		for i := range t.Points {
+			if t.Points[i].Empty {
+				continue
+			}
+			if written > 0 {
+				buf.WriteByte(',')
+			}
+			writeGeoJSONCoord(buf, t.Points[i].Coord, lay)
+			written++
+		}
+		buf.WriteByte(']')
+	case *MultiLineString:
+		buf.WriteByte('[')
+		for i := range t.LineStrings {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeGeoJSONCoords(buf, t.LineStrings[i].Coords, lay)
+		}
+		buf.WriteByte(']')
+	case *MultiPolygon:
+		buf.WriteByte('[')
+		for i := range t.Polygons {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeGeoJSONRings(buf, t.Polygons[i].Rings, lay)
+		}
+		buf.WriteByte(']')
+	}
+	buf.WriteByte('}')
+	return nil
+}
+
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates json.RawMessage   `json:"coordinates"`
+	Geometries  []json.RawMessage `json:"geometries"`
+}
+
+// toCoord converts a GeoJSON position, the layout is XYZ when it has a third value
+func toCoord(position []float64) (Coord, Layout, error) {
+	switch len(position) {
+	case 2:
+		return Coord{X: position[0], Y: position[1]}, XY, nil
+	case 3:
+		return Coord{X: position[0], Y: position[1], Z: position[2]}, XYZ, nil
+	default:
+		return Coord{}, XY, fmt.Errorf("%w: a position needs 2 or 3 numbers, got %d", ErrInvalidGeoJSON, len(position))
+	}
+}
+
+func toCoords(positions [][]float64) ([]Coord, Layout, error) {
+	lay := XY
+	coords := make([]Coord, len(positions))
+	for i, position := range positions {
+		c, l, err := toCoord(position)
+		if err != nil {
+			return nil, XY, err
+		}
+		if i > 0 && l != lay {
+			return nil, XY, fmt.Errorf("%w: positions mix 2 and 3 dimensions", ErrInvalidGeoJSON)
+		}
+		coords[i], lay = c, l
+	}
+	return coords, lay, nil
+}
+
+// toLine converts the positions of a LineString, which needs at least 2 of them when it is not empty
+func toLine(positions [][]float64) ([]Coord, Layout, error) {
+	if len(positions) == 1 {
+		return nil, XY, fmt.Errorf("%w: a LineString needs at least 2 positions, got 1", ErrInvalidGeoJSON)
+	}
+	return toCoords(positions)
+}
+
+// toRing converts the positions of a polygon ring, closed and with at least 4 positions as required by RFC 7946
+func toRing(positions [][]float64) ([]Coord, Layout, error) {
+	if len(positions) < 4 {
+		return nil, XY, fmt.Errorf("%w: a polygon ring needs at least 4 positions, got %d", ErrInvalidGeoJSON, len(positions))
+	}
+	coords, lay, err := toCoords(positions)
+	if err != nil {
+		return nil, XY, err
+	}
+	if coords[0] != coords[len(coords)-1] {
+		return nil, XY, fmt.Errorf("%w: a polygon ring must end with its first position", ErrInvalidGeoJSON)
+	}
+	return coords, lay, nil
+}
+
+// toRings converts the rings of a polygon, or the lines of a MultiLineString, which must all have the same dimension
+func toRings(rings [][][]float64, convert func([][]float64) ([]Coord, Layout, error)) ([][]Coord, Layout, error) {
+	lay := XY
+	res := make([][]Coord, len(rings))
+	for i, ring := range rings {
+		coords, l, err := convert(ring)
+		if err != nil {
+			return nil, XY, err
+		}
+		if i > 0 && l != lay {
+			return nil, XY, fmt.Errorf("%w: parts mix 2 and 3 dimensions", ErrInvalidGeoJSON)
+		}
+		res[i], lay = coords, l
+	}
+	return res, lay, nil
+}
+
+func unmarshalGeoJSON(data []byte, depth int) (Geometry, error) {
+	if depth > maxWkbDepth {
+		return nil, fmt.Errorf("%w: geometry collections nested too deeply", ErrInvalidGeoJSON)
+	}
+	var raw geoJSONGeometry
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidGeoJSON, err)
+	}
+	if raw.Type == "GeometryCollection" {
+		gc := &GeometryCollection{}
+		for _, part := range raw.Geometries {
+			g, err := unmarshalGeoJSON(part, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			gc.Geometries = append(gc.Geometries, g)
+		}
+		if len(gc.Geometries) > 0 {
+			gc.Lay = gc.Geometries[0].Layout()
+		}
+		return gc, nil
+	}
+	if len(raw.Coordinates) == 0 {
+		return nil, fmt.Errorf("%w: missing coordinates for type %q", ErrInvalidGeoJSON, raw.Type)
+	}
+	decode := func(v interface{}) error {
+		if err := json.Unmarshal(raw.Coordinates, v); err != nil {
+			return fmt.Errorf("%w: bad coordinates for %s: %v", ErrInvalidGeoJSON, raw.Type, err)
+		}
+		return nil
+	}
+	switch raw.Type {
+	case "Point":
+		var position []float64
+		if err := decode(&position); err != nil {
+			return nil, err
+		}
+		if len(position) == 0 {
+			return &Point{Empty: true}, nil
+		}
+		c, lay, err := toCoord(position)
+		return &Point{Lay: lay, Coord: c}, err
+	case "LineString":
+		var positions [][]float64
+		if err := decode(&positions); err != nil {
+			return nil, err
+		}
+		coords, lay, err := toLine(positions)
+		return &LineString{Lay: lay, Coords: coords}, err
+	case "Polygon":
+		var rings [][][]float64
+		if err := decode(&rings); err != nil {
+			return nil, err
+		}
+		res, lay, err := toRings(rings, toRing)
+		return &Polygon{Lay: lay, Rings: res}, err
+	case "MultiPoint":
+		var positions [][]float64
+		if err := decode(&positions); err != nil {
+			return nil, err
+		}
+		coords, lay, err := toCoords(positions)
+		mp := &MultiPoint{Lay: lay, Points: make([]Point, len(coords))}
+		for i, c := range coords {
+			mp.Points[i] = Point{Lay: lay, Coord: c}
+		}
+		return mp, err
+	case "MultiLineString":
+		var lines [][][]float64
+		if err := decode(&lines); err != nil {
+			return nil, err
+		}
+		res, lay, err := toRings(lines, toLine)
+		ml := &MultiLineString{Lay: lay, LineStrings: make([]LineString, len(res))}
+		for i, coords := range res {
+			ml.LineStrings[i] = LineString{Lay: lay, Coords: coords}
+		}
+		return ml, err
+	case "MultiPolygon":
+		var polygons [][][][]float64
+		if err := decode(&polygons); err != nil {
+			return nil, err
+		}
+		mp := &MultiPolygon{Polygons: make([]Polygon, len(polygons))}
+		for i, polygon := range polygons {
+			rings, lay, err := toRings(polygon, toRing)
+			if err != nil {
+				return nil, err
+			}
+			if i > 0 && lay != mp.Lay {
+				return nil, fmt.Errorf("%w: parts mix 2 and 3 dimensions", ErrInvalidGeoJSON)
+			}
+			mp.Lay = lay
+			mp.Polygons[i] = Polygon{Lay: lay, Rings: rings}
+		}
+		return mp, nil
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrInvalidGeoJSON, raw.Type)
+	}
+}
+
+// UnmarshalGeoJSON parses a RFC 7946 GeoJSON geometry object
+func UnmarshalGeoJSON(data []byte) (Geometry, error) {
+	return unmarshalGeoJSON(data, 0)
+}
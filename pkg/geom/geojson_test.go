@@ -0,0 +1,75 @@
+package geom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGeoJSONRoundTrip(t *testing.T) {
+	for _, lay := range layouts {
+		for name, g := range testGeometries(lay) {
+			t.Run(name+layoutSuffix(lay), func(t *testing.T) {
+				data, err := MarshalGeoJSON(g)
+				if err != nil {
+					t.Fatalf("MarshalGeoJSON failed : %v", err)
+				}
+				got, err := UnmarshalGeoJSON(data)
+				if err != nil {
+					t.Fatalf("UnmarshalGeoJSON(%s) failed : %v", data, err)
+				}
+				again, err := MarshalGeoJSON(got)
+				if err != nil {
+					t.Fatalf("MarshalGeoJSON of the decoded geometry failed : %v", err)
+				}
+				if string(again) != string(data) {
+					t.Errorf("got  %s\nwant %s", again, data)
+				}
+				// GeoJSON has no measure and an empty geometry has no position telling its dimension
+				if !g.IsEmpty() && (lay == XY || lay == XYZ) {
+					sameGeometry(t, got, g)
+				}
+			})
+		}
+	}
+}
+
+func TestMarshalGeoJSONDropsM(t *testing.T) {
+	data, err := MarshalGeoJSON(&Point{Lay: XYZM, Coord: Coord{X: 1, Y: 2, Z: 3, M: 4}})
+	if err != nil {
+		t.Fatalf("MarshalGeoJSON failed : %v", err)
+	}
+	if want := `{"type":"Point","coordinates":[1,2,3]}`; string(data) != want {
+		t.Errorf("MarshalGeoJSON = %s, want %s", data, want)
+	}
+}
+
+func TestUnmarshalGeoJSONErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		geojson string
+	}{
+		{"not json", "POINT(1 2)"},
+		{"unknown type", `{"type":"Circle","coordinates":[0,0]}`},
+		{"missing coordinates", `{"type":"Point"}`},
+		{"position of 1 number", `{"type":"Point","coordinates":[1]}`},
+		{"mixed dimensions", `{"type":"LineString","coordinates":[[0,0],[1,1,1]]}`},
+		{"single position line string", `{"type":"LineString","coordinates":[[0,0]]}`},
+		{"unclosed ring", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[1,1],[0,1]]]}`},
+		{"short ring", `{"type":"Polygon","coordinates":[[[0,0],[1,0],[0,0]]]}`},
+		{"bad ring in a multipolygon", `{"type":"MultiPolygon","coordinates":[[[[0,0],[1,0],[1,1],[0,0]]],[[[0,0],[1,0],[1,1]]]]}`},
+		{"collections nested too deeply",
+			strings.Repeat(`{"type":"GeometryCollection","geometries":[`, maxWkbDepth+1) +
+				`{"type":"Point","coordinates":[1,2]}` + strings.Repeat("]}", maxWkbDepth+1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if g, err := UnmarshalGeoJSON([]byte(tt.geojson)); !errors.Is(err, ErrInvalidGeoJSON) {
+				t.Errorf("UnmarshalGeoJSON = %#v, %v, want an ErrInvalidGeoJSON", g, err)
+			}
+		})
+	}
+	if _, err := MarshalGeoJSON(nestedCollection(maxWkbDepth + 1)); !errors.Is(err, ErrInvalidGeoJSON) {
+		t.Errorf("MarshalGeoJSON of %d nested collections returned %v, want an ErrInvalidGeoJSON", maxWkbDepth+1, err)
+	}
+}
@@ -0,0 +1,212 @@
+// Package geom holds the simple feature geometry types shared by all the geo endpoints
+// and their encodings : WKB, EWKB (PostGIS), WKT and GeoJSON
+package geom
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// GeometryType is the OGC simple feature geometry type code used in WKB
+type GeometryType uint32
+
+const (
+	TypeGeometry           GeometryType = 0
+	TypePoint              GeometryType = 1
+	TypeLineString         GeometryType = 2
+	TypePolygon            GeometryType = 3
+	TypeMultiPoint         GeometryType = 4
+	TypeMultiLineString    GeometryType = 5
+	TypeMultiPolygon       GeometryType = 6
+	TypeGeometryCollection GeometryType = 7
+)
+
+var geometryTypeNames = map[GeometryType]string{
+	TypeGeometry:           "GEOMETRY",
+	TypePoint:              "POINT",
+	TypeLineString:         "LINESTRING",
+	TypePolygon:            "POLYGON",
+	TypeMultiPoint:         "MULTIPOINT",
+	TypeMultiLineString:    "MULTILINESTRING",
+	TypeMultiPolygon:       "MULTIPOLYGON",
+	TypeGeometryCollection: "GEOMETRYCOLLECTION",
+}
+
+func (t GeometryType) String() string {
+	if name, ok := geometryTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("GEOMETRY_TYPE(%d)", uint32(t))
+}
+
+// Layout tells which ordinates are present in the coordinates of a geometry
+type Layout uint8
+
+const (
+	XY Layout = iota
+	XYZ
+	XYM
+	XYZM
+)
+
+// HasZ returns true when the coordinates have an elevation
+func (l Layout) HasZ() bool { return l == XYZ || l == XYZM }
+
+// HasM returns true when the coordinates have a measure
+func (l Layout) HasM() bool { return l == XYM || l == XYZM }
+
+// Coord is one position, Z and M are only meaningful when the Layout of the geometry has them
+type Coord struct {
+	X, Y, Z, M float64
+}
+
+// Geometry is implemented by all the geometry types of this package
+type Geometry interface {
+	Type() GeometryType
+	Layout() Layout
+	IsEmpty() bool
+}
+
+// Point is a single position, an empty point has NaN coordinates in WKB
+type Point struct {
+	Lay   Layout
+	Coord Coord
+	Empty bool
+}
+
+// LineString is a sequence of positions
+type LineString struct {
+	Lay    Layout
+	Coords []Coord
+}
+
+// Polygon is an exterior ring followed by its holes, each ring is closed
+type Polygon struct {
+	Lay   Layout
+	Rings [][]Coord
+}
+
+// MultiPoint is a collection of points
+type MultiPoint struct {
+	Lay    Layout
+	Points []Point
+}
+
+// MultiLineString is a collection of linestrings
+type MultiLineString struct {
+	Lay         Layout
+	LineStrings []LineString
+}
+
+// MultiPolygon is a collection of polygons
+type MultiPolygon struct {
+	Lay      Layout
+	Polygons []Polygon
+}
+
+// GeometryCollection is a heterogeneous collection of geometries
+type GeometryCollection struct {
+	Lay        Layout
+	Geometries []Geometry
+}
+
+func (g *Point) Type() GeometryType              { return TypePoint }
+func (g *LineString) Type() GeometryType         { return TypeLineString }
+func (g *Polygon) Type() GeometryType            { return TypePolygon }
+func (g *MultiPoint) Type() GeometryType         { return TypeMultiPoint }
+func (g *MultiLineString) Type() GeometryType    { return TypeMultiLineString }
+func (g *MultiPolygon) Type() GeometryType       { return TypeMultiPolygon }
+func (g *GeometryCollection) Type() GeometryType { return TypeGeometryCollection }
+
+func (g *Point) Layout() Layout              { return g.Lay }
+func (g *LineString) Layout() Layout         { return g.Lay }
+func (g *Polygon) Layout() Layout            { return g.Lay }
+func (g *MultiPoint) Layout() Layout         { return g.Lay }
+func (g *MultiLineString) Layout() Layout    { return g.Lay }
+func (g *MultiPolygon) Layout() Layout       { return g.Lay }
+func (g *GeometryCollection) Layout() Layout { return g.Lay }
+
+func (g *Point) IsEmpty() bool              { return g.Empty }
+func (g *LineString) IsEmpty() bool         { return len(g.Coords) == 0 }
+func (g *Polygon) IsEmpty() bool            { return len(g.Rings) == 0 }
+func (g *MultiPoint) IsEmpty() bool         { return len(g.Points) == 0 }
+func (g *MultiLineString) IsEmpty() bool    { return len(g.LineStrings) == 0 }
+func (g *MultiPolygon) IsEmpty() bool       { return len(g.Polygons) == 0 }
+func (g *GeometryCollection) IsEmpty() bool { return len(g.Geometries) == 0 }
+
+// isNaNCoord returns true when x and y are NaN, the WKB convention for an empty point
+func isNaNCoord(c Coord) bool {
+	return math.IsNaN(c.X) && math.IsNaN(c.Y)
+}
+
+// ErrInvalidGeometry is returned by Validate
+var ErrInvalidGeometry = errors.New("invalid geometry")
+
+// Validate checks what the encodings need to write a geometry that PostGIS and SpatiaLite accept : finite x and y,
+// line strings of at least 2 positions, closed rings of at least 4 and no empty point in a multi point.
+// An empty geometry is valid, the callers that cannot use one must check IsEmpty.
+func Validate(g Geometry) error {
+	switch g := g.(type) {
+	case *Point:
+		if !g.Empty {
+			return validateCoords([]Coord{g.Coord}, 1, false)
+		}
+	case *LineString:
+		if len(g.Coords) > 0 {
+			return validateCoords(g.Coords, 2, false)
+		}
+	case *Polygon:
+		for _, ring := range g.Rings {
+			if err := validateCoords(ring, 4, true); err != nil {
+				return err
+			}
+		}
+	case *MultiPoint:
+		for i := range g.Points {
+			if g.Points[i].Empty {
+				return fmt.Errorf("%w: a multi point cannot hold an empty point", ErrInvalidGeometry)
+			}
+			if err := Validate(&g.Points[i]); err != nil {
+				return err
+			}
+		}
+	case *MultiLineString:
+		for i := range g.LineStrings {
+			if err := validateCoords(g.LineStrings[i].Coords, 2, false); err != nil {
+				return err
+			}
+		}
+	case *MultiPolygon:
+		for i := range g.Polygons {
+			if err := Validate(&g.Polygons[i]); err != nil {
+				return err
+			}
+		}
+	case *GeometryCollection:
+		for _, member := range g.Geometries {
+			if err := Validate(member); err != nil {
+				return err
+			}
+		}
+	case nil:
+		return fmt.Errorf("%w: no geometry", ErrInvalidGeometry)
+	}
+	return nil
+}
+
+// validateCoords checks that coords has at least minCoords finite positions, and ends with its first one when closed
+func validateCoords(coords []Coord, minCoords int, closed bool) error {
+	if len(coords) < minCoords {
+		return fmt.Errorf("%w: %d positions where at least %d are needed", ErrInvalidGeometry, len(coords), minCoords)
+	}
+	for _, c := range coords {
+		if math.IsNaN(c.X) || math.IsNaN(c.Y) || math.IsInf(c.X, 0) || math.IsInf(c.Y, 0) {
+			return fmt.Errorf("%w: the coordinates must be finite numbers", ErrInvalidGeometry)
+		}
+	}
+	if closed && coords[0] != coords[len(coords)-1] {
+		return fmt.Errorf("%w: a polygon ring must end with its first position", ErrInvalidGeometry)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package geom
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+var layouts = []Layout{XY, XYZ, XYM, XYZM}
+
+// testCoord returns x y with the Z and M values of lay derived from them, so that every ordinate is checked
+func testCoord(lay Layout, x, y float64) Coord {
+	c := Coord{X: x, Y: y}
+	if lay.HasZ() {
+		c.Z = x/1000 + 0.5
+	}
+	if lay.HasM() {
+		c.M = y/1000 - 0.25
+	}
+	return c
+}
+
+// testSquare returns a closed ring of side size with x y as its lower left corner
+func testSquare(lay Layout, x, y, size float64) []Coord {
+	return []Coord{
+		testCoord(lay, x, y), testCoord(lay, x+size, y), testCoord(lay, x+size, y+size),
+		testCoord(lay, x, y+size), testCoord(lay, x, y),
+	}
+}
+
+// testGeometries returns every geometry type in lay, with and without coordinates, named for the subtests
+func testGeometries(lay Layout) map[string]Geometry {
+	polygon := Polygon{Lay: lay, Rings: [][]Coord{
+		testSquare(lay, 2538000, 1152000, 100), testSquare(lay, 2538010.25, 1152010.75, 10),
+	}}
+	line := LineString{Lay: lay, Coords: []Coord{testCoord(lay, 2538000, 1152000), testCoord(lay, 2538100.125, 1152050)}}
+	point := Point{Lay: lay, Coord: testCoord(lay, 2537968.5, 1152088)}
+	return map[string]Geometry{
+		"point":                 &point,
+		"empty point":           &Point{Lay: lay, Empty: true},
+		"linestring":            &line,
+		"empty linestring":      &LineString{Lay: lay},
+		"polygon with a hole":   &polygon,
+		"empty polygon":         &Polygon{Lay: lay},
+		"multipoint":            &MultiPoint{Lay: lay, Points: []Point{point, {Lay: lay, Coord: testCoord(lay, -1, -2)}}},
+		"empty multipoint":      &MultiPoint{Lay: lay},
+		"multilinestring":       &MultiLineString{Lay: lay, LineStrings: []LineString{line, line}},
+		"empty multilinestring": &MultiLineString{Lay: lay},
+		"multipolygon": &MultiPolygon{Lay: lay, Polygons: []Polygon{
+			polygon, {Lay: lay, Rings: [][]Coord{testSquare(lay, 0, 0, 1)}},
+		}},
+		"empty multipolygon": &MultiPolygon{Lay: lay},
+		"geometrycollection": &GeometryCollection{Lay: lay, Geometries: []Geometry{
+			&point, &polygon, &GeometryCollection{Lay: lay, Geometries: []Geometry{&line}},
+		}},
+		"empty geometrycollection": &GeometryCollection{Lay: lay},
+	}
+}
+
+// nestedCollection returns depth geometry collections nested around a point
+func nestedCollection(depth int) Geometry {
+	var g Geometry = &Point{Coord: Coord{X: 1, Y: 2}}
+	for i := 0; i < depth; i++ {
+		g = &GeometryCollection{Geometries: []Geometry{g}}
+	}
+	return g
+}
+
+// nestedCollectionWkt returns the WKT of nestedCollection(depth)
+func nestedCollectionWkt(depth int) string {
+	return strings.Repeat("GEOMETRYCOLLECTION(", depth) + "POINT(1 2)" + strings.Repeat(")", depth)
+}
+
+// sameGeometry compares two geometries by their WKT, which is exact for the float64 and tells apart the layouts
+// and the empty points whose coordinates are NaN once decoded from WKB
+func sameGeometry(t *testing.T, got, want Geometry) {
+	t.Helper()
+	if got == nil || got.Type() != want.Type() || got.Layout() != want.Layout() {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+	if g, w := MarshalWKT(got), MarshalWKT(want); g != w {
+		t.Errorf("got  %s\nwant %s", g, w)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, lay := range layouts {
+		for name, g := range testGeometries(lay) {
+			if err := Validate(g); err != nil {
+				t.Errorf("Validate of the %s %s failed : %v", layoutSuffix(lay), name, err)
+			}
+		}
+	}
+	open := testSquare(XY, 0, 0, 1)[:4]
+	tests := []struct {
+		name string
+		g    Geometry
+	}{
+		{"nil", nil},
+		{"single position line string", &LineString{Coords: open[:1]}},
+		{"unclosed ring", &Polygon{Rings: [][]Coord{open}}},
+		{"short ring", &Polygon{Rings: [][]Coord{{open[0], open[1], open[0]}}}},
+		{"bad ring in a multipolygon", &MultiPolygon{Polygons: []Polygon{{Rings: [][]Coord{open}}}}},
+		{"empty point in a multipoint", &MultiPoint{Points: []Point{{Empty: true}}}},
+		{"bad part in a collection", &GeometryCollection{Geometries: []Geometry{&LineString{Coords: open[:1]}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.g); !errors.Is(err, ErrInvalidGeometry) {
+				t.Errorf("Validate = %v, want an ErrInvalidGeometry", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package geom
+
+import (
+	"fmt"
+	"math"
+)
+
+const (
+	SridWgs84       = 4326
+	SridLv95        = 2056
+	SridWebMercator = 3857
+	// webMercatorRadius is the radius of the sphere used by EPSG:3857
+	webMercatorRadius = 6378137.0
+)
+
+// TransformFunc converts a position from one coordinate reference system to another
+type TransformFunc func(c Coord) Coord
+
+// Lv95ToWgs84 converts swiss LV95 coordinates to WGS84 longitude, latitude with the swisstopo approximate formulas,
+// precise to about one meter in Switzerland, which is enough for display and distances, but not for surveying
+func Lv95ToWgs84(c Coord) Coord {
+	y := (c.X - 2600000) / 1000000
+	x := (c.Y - 1200000) / 1000000
+	lon := 2.6779094 + 4.728982*y + 0.791484*y*x + 0.1306*y*x*x - 0.0436*y*y*y
+	lat := 16.9023892 + 3.238272*x - 0.270978*y*y - 0.002528*x*x - 0.0447*y*y*x - 0.0140*x*x*x
+	return Coord{X: lon * 100 / 36, Y: lat * 100 / 36, Z: c.Z, M: c.M}
+}
+
+// Wgs84ToLv95 converts WGS84 longitude, latitude to swiss LV95 coordinates with the swisstopo approximate formulas
+func Wgs84ToLv95(c Coord) Coord {
+	lat := (c.Y*3600 - 169028.66) / 10000
+	lon := (c.X*3600 - 26782.5) / 10000
+	e := 2600072.37 + 211455.93*lon - 10938.51*lon*lat - 0.36*lon*lat*lat - 44.54*lon*lon*lon
+	n := 1200147.07 + 308807.95*lat + 3745.25*lon*lon + 76.63*lat*lat - 194.56*lon*lon*lat + 119.79*lat*lat*lat
+	return Coord{X: e, Y: n, Z: c.Z, M: c.M}
+}
+
+// Wgs84ToWebMercator converts WGS84 longitude, latitude to EPSG:3857 meters
+func Wgs84ToWebMercator(c Coord) Coord {
+	lat := math.Max(math.Min(c.Y, 85.0511287798), -85.0511287798)
+	return Coord{
+		X: webMercatorRadius * c.X * math.Pi / 180,
+		Y: webMercatorRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360)),
+		Z: c.Z, M: c.M,
+	}
+}
+
+// WebMercatorToWgs84 converts EPSG:3857 meters to WGS84 longitude, latitude
+func WebMercatorToWgs84(c Coord) Coord {
+	return Coord{
+		X: c.X / webMercatorRadius * 180 / math.Pi,
+		Y: (2*math.Atan(math.Exp(c.Y/webMercatorRadius)) - math.Pi/2) * 180 / math.Pi,
+		Z: c.Z, M: c.M,
+	}
+}
+
+// GetTransform returns the function converting positions from srid to targetSrid,
+// only the reference systems used by this project are supported : 2056, 4326 and 3857
+func GetTransform(srid, targetSrid int) (TransformFunc, error) {
+	if srid == targetSrid {
+		return func(c Coord) Coord { return c }, nil
+	}
+	var toWgs84, fromWgs84 TransformFunc
+	switch srid {
+	case SridWgs84:
+		toWgs84 = func(c Coord) Coord { return c }
+	case SridLv95:
+		toWgs84 = Lv95ToWgs84
+	case SridWebMercator:
+		toWgs84 = WebMercatorToWgs84
+	}
+	switch targetSrid {
+	case SridWgs84:
+		fromWgs84 = func(c Coord) Coord { return c }
+	case SridLv95:
+		fromWgs84 = Wgs84ToLv95
+	case SridWebMercator:
+		fromWgs84 = Wgs84ToWebMercator
+	}
+	if toWgs84 == nil || fromWgs84 == nil {
+		return nil, fmt.Errorf("transformation from srid %d to %d is not supported", srid, targetSrid)
+	}
+	return func(c Coord) Coord { return fromWgs84(toWgs84(c)) }, nil
+}
+
+func transformCoords(coords []Coord, f TransformFunc) []Coord {
+	res := make([]Coord, len(coords))
+	for i, c := range coords {
+		res[i] = f(c)
+	}
+	return res
+}
+
+func transformRings(rings [][]Coord, f TransformFunc) [][]Coord {
+	res := make([][]Coord, len(rings))
+	for i, ring := range rings {
+		res[i] = transformCoords(ring, f)
+	}
+	return res
+}
+
+// Transform returns a copy of g with all its positions converted by f
+func Transform(g Geometry, f TransformFunc) Geometry {
+	switch t := g.(type) {
+	case *Point:
+		if t.Empty {
+			return &Point{Lay: t.Lay, Empty: true}
+		}
+		return &Point{Lay: t.Lay, Coord: f(t.Coord)}
+	case *LineString:
+		return &LineString{Lay: t.Lay, Coords: transformCoords(t.Coords, f)}
+	case *Polygon:
+		return &Polygon{Lay: t.Lay, Rings: transformRings(t.Rings, f)}
+	case *MultiPoint:
+		res := &MultiPoint{Lay: t.Lay, Points: make([]Point, len(t.Points))}
+		for i, p := range t.Points {
+			res.Points[i] = *Transform(&p, f).(*Point)
+		}
+		return res
+	case *MultiLineString:
+		res := &MultiLineString{Lay: t.Lay, LineStrings: make([]LineString, len(t.LineStrings))}
+		for i := range t.LineStrings {
+			res.LineStrings[i] = LineString{Lay: t.Lay, Coords: transformCoords(t.LineStrings[i].Coords, f)}
+		}
+		return res
+	case *MultiPolygon:
+		res := &MultiPolygon{Lay: t.Lay, Polygons: make([]Polygon, len(t.Polygons))}
+		for i := range t.Polygons {
+			res.Polygons[i] = Polygon{Lay: t.Lay, Rings: transformRings(t.Polygons[i].Rings, f)}
+		}
+		return res
+	case *GeometryCollection:
+		res := &GeometryCollection{Lay: t.Lay, Geometries: make([]Geometry, len(t.Geometries))}
+		for i, part := range t.Geometries {
+			res.Geometries[i] = Transform(part, f)
+		}
+		return res
+	default:
+		return g
+	}
+}
+
+// TransformEnvelope returns the envelope containing e converted by f, the borders are densified
+// since a straight border in one reference system is curved in another
+func TransformEnvelope(e Envelope, f TransformFunc) Envelope {
+	const steps = 8
+	res := EmptyEnvelope()
+	if e.IsEmpty() {
+		return res
+	}
+	dx, dy := (e.MaxX-e.MinX)/steps, (e.MaxY-e.MinY)/steps
+	for i := 0; i <= steps; i++ {
+		x, y := e.MinX+float64(i)*dx, e.MinY+float64(i)*dy
+		res.ExtendCoord(f(Coord{X: x, Y: e.MinY}))
+		res.ExtendCoord(f(Coord{X: x, Y: e.MaxY}))
+		res.ExtendCoord(f(Coord{X: e.MinX, Y: y}))
+		res.ExtendCoord(f(Coord{X: e.MaxX, Y: y}))
+	}
+	return res
+}
+
+// MetersPerUnit returns the length in meters of one unit of srid along x and y around the position at, given in srid.
+// It is exact enough for the distances of a few kilometers used by the nearest searches.
+// The other projected reference systems are assumed to be in meters like LV95.
+func MetersPerUnit(srid int, at Coord) (float64, float64) {
+	switch srid {
+	case SridWgs84:
+		degree := webMercatorRadius * math.Pi / 180
+		return degree * math.Cos(at.Y*math.Pi/180), degree
+	case SridWebMercator:
+		scale := math.Cos(WebMercatorToWgs84(at).Y * math.Pi / 180)
+		return scale, scale
+	}
+	return 1, 1
+}
@@ -0,0 +1,129 @@
+package geom
+
+import (
+	"bytes"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// blobFormat is a binary geometry format recognized by its magic prefix, like the GeoPackage blobs
+type blobFormat struct {
+	name   string
+	magic  []byte
+	decode func(blob []byte) (Geometry, int, error)
+}
+
+var (
+	blobFormatsMu sync.RWMutex
+	blobFormats   []blobFormat
+)
+
+// RegisterBlobFormat registers a binary format that Value.Scan will decode when a blob starts with magic.
+// decode returns the geometry and its srid. It is used by pkg/geopackage to register the GeoPackage Binary format
+// so that this package does not depend on it, in the same way as image.RegisterFormat.
+func RegisterBlobFormat(name string, magic []byte, decode func(blob []byte) (Geometry, int, error)) {
+	blobFormatsMu.Lock()
+	defer blobFormatsMu.Unlock()
+	blobFormats = append(blobFormats, blobFormat{name: name, magic: magic, decode: decode})
+}
+
+func findBlobFormat(blob []byte) *blobFormat {
+	blobFormatsMu.RLock()
+	defer blobFormatsMu.RUnlock()
+	for i := range blobFormats {
+		if bytes.HasPrefix(blob, blobFormats[i].magic) {
+			return &blobFormats[i]
+		}
+	}
+	return nil
+}
+
+// Value is a geometry with its srid, it can be scanned from a geometry column of both DB backends :
+// PostGIS EWKB (binary or hex text), WKB returned by ST_AsBinary or AsBinary, and GeoPackage blobs.
+// A NULL column gives a nil Geometry. Value is marshalled to json as a GeoJSON geometry.
+type Value struct {
+	Geometry Geometry
+	SRID     int
+}
+
+// DecodeBlob decodes a geometry in any of the supported binary forms
+func DecodeBlob(blob []byte) (Geometry, int, error) {
+	if f := findBlobFormat(blob); f != nil {
+		return f.decode(blob)
+	}
+	if len(blob) > 0 && blob[0] != wkbBigEndian && blob[0] != wkbLittleEndian {
+		// postgres sends geometries in text format as hex encoded EWKB
+		raw, err := hex.DecodeString(string(blob))
+		if err != nil {
+			return nil, 0, fmt.Errorf("%w: neither a known binary format nor hex", ErrInvalidWkb)
+		}
+		blob = raw
+	}
+	return DecodeEwkb(blob)
+}
+
+// Scan implements the sql.Scanner interface
+func (v *Value) Scan(src interface{}) error {
+	var blob []byte
+	switch t := src.(type) {
+	case nil:
+		v.Geometry, v.SRID = nil, 0
+		return nil
+	case []byte:
+		blob = t
+	case string:
+		blob = []byte(t)
+	default:
+		return fmt.Errorf("cannot scan a %T into a geometry", src)
+	}
+	g, srid, err := DecodeBlob(blob)
+	if err != nil {
+		return err
+	}
+	v.Geometry, v.SRID = g, srid
+	return nil
+}
+
+// Value implements the driver.Valuer interface, the geometry is given as EWKB that PostGIS accepts as is
+func (v Value) Value() (driver.Value, error) {
+	if v.Geometry == nil {
+		return nil, nil
+	}
+	return EncodeEwkb(v.Geometry, v.SRID), nil
+}
+
+// MarshalJSON implements json.Marshaler, the geometry is written as GeoJSON or null
+func (v Value) MarshalJSON() ([]byte, error) {
+	if v.Geometry == nil {
+		return []byte("null"), nil
+	}
+	return MarshalGeoJSON(v.Geometry)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, the srid is left untouched since GeoJSON has none
+func (v *Value) UnmarshalJSON(data []byte) error {
+	if string(bytes.TrimSpace(data)) == "null" {
+		v.Geometry = nil
+		return nil
+	}
+	g, err := UnmarshalGeoJSON(data)
+	if err != nil {
+		return err
+	}
+	v.Geometry = g
+	return nil
+}
+
+// String returns the EWKT of the geometry
+func (v Value) String() string {
+	if v.Geometry == nil {
+		return "NULL"
+	}
+	return MarshalEWKT(v.Geometry, v.SRID)
+}
+
+var _ sql.Scanner = (*Value)(nil)
+var _ driver.Valuer = Value{}
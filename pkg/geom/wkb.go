@@ -0,0 +1,396 @@
+package geom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+const (
+	wkbBigEndian    = 0
+	wkbLittleEndian = 1
+	// EWKB flags used by PostGIS, tolerated when decoding
+	ewkbZFlag    = 0x80000000
+	ewkbMFlag    = 0x40000000
+	ewkbSridFlag = 0x20000000
+	// max nesting of geometry collections, protects against malicious blobs
+	maxWkbDepth = 32
+)
+
+var ErrInvalidWkb = errors.New("invalid wkb")
+
+// wkbReader decodes a WKB byte slice, returning ErrInvalidWkb instead of panicking on truncated input
+type wkbReader struct {
+	buf   []byte
+	pos   int
+	order binary.ByteOrder
+	srid  int // srid of the top level geometry when the data is EWKB with the srid flag
+}
+
+func (r *wkbReader) remaining() int {
+	return len(r.buf) - r.pos
+}
+
+func (r *wkbReader) readByte() (byte, error) {
+	if r.remaining() < 1 {
+		return 0, fmt.Errorf("%w: unexpected end of data at offset %d", ErrInvalidWkb, r.pos)
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	if r.remaining() < 4 {
+		return 0, fmt.Errorf("%w: unexpected end of data at offset %d", ErrInvalidWkb, r.pos)
+	}
+	v := r.order.Uint32(r.buf[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *wkbReader) readFloat64() (float64, error) {
+	if r.remaining() < 8 {
+		return 0, fmt.Errorf("%w: unexpected end of data at offset %d", ErrInvalidWkb, r.pos)
+	}
+	v := math.Float64frombits(r.order.Uint64(r.buf[r.pos:]))
+	r.pos += 8
+	return v, nil
+}
+
+// readCount reads a number of elements and checks that the data left can hold at least minSize bytes for each one
+func (r *wkbReader) readCount(minSize int) (int, error) {
+	n, err := r.readUint32()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(n)*uint64(minSize) > uint64(r.remaining()) {
+		return 0, fmt.Errorf("%w: count %d exceeds the %d bytes left", ErrInvalidWkb, n, r.remaining())
+	}
+	return int(n), nil
+}
+
+func (r *wkbReader) readCoord(lay Layout) (Coord, error) {
+	var c Coord
+	var err error
+	if c.X, err = r.readFloat64(); err != nil {
+		return c, err
+	}
+	if c.Y, err = r.readFloat64(); err != nil {
+		return c, err
+	}
+	if lay.HasZ() {
+		if c.Z, err = r.readFloat64(); err != nil {
+			return c, err
+		}
+	}
+	if lay.HasM() {
+		if c.M, err = r.readFloat64(); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+func coordSize(lay Layout) int {
+	switch lay {
+	case XYZ, XYM:
+		return 24
+	case XYZM:
+		return 32
+	default:
+		return 16
+	}
+}
+
+func (r *wkbReader) readCoords(lay Layout) ([]Coord, error) {
+	n, err := r.readCount(coordSize(lay))
+	if err != nil {
+		return nil, err
+	}
+	coords := make([]Coord, n)
+	for i := range coords {
+		if coords[i], err = r.readCoord(lay); err != nil {
+			return nil, err
+		}
+	}
+	return coords, nil
+}
+
+// readHeader reads the byte order and the type of a WKB geometry, supporting ISO (1000, 2000, 3000) and EWKB flags
+func (r *wkbReader) readHeader(depth int) (GeometryType, Layout, error) {
+	orderByte, err := r.readByte()
+	if err != nil {
+		return 0, XY, err
+	}
+	switch orderByte {
+	case wkbBigEndian:
+		r.order = binary.BigEndian
+	case wkbLittleEndian:
+		r.order = binary.LittleEndian
+	default:
+		return 0, XY, fmt.Errorf("%w: bad byte order %d", ErrInvalidWkb, orderByte)
+	}
+	code, err := r.readUint32()
+	if err != nil {
+		return 0, XY, err
+	}
+	hasZ := code&ewkbZFlag != 0
+	hasM := code&ewkbMFlag != 0
+	if code&ewkbSridFlag != 0 {
+		srid, err := r.readUint32()
+		if err != nil {
+			return 0, XY, err
+		}
+		if depth == 0 {
+			r.srid = int(int32(srid))
+		}
+	}
+	code &= 0x0fffffff
+	switch code / 1000 {
+	case 1:
+		hasZ = true
+	case 2:
+		hasM = true
+	case 3:
+		hasZ, hasM = true, true
+	}
+	geomType := GeometryType(code % 1000)
+	if geomType < TypePoint || geomType > TypeGeometryCollection {
+		return 0, XY, fmt.Errorf("%w: unsupported geometry type %d", ErrInvalidWkb, code)
+	}
+	lay := XY
+	switch {
+	case hasZ && hasM:
+		lay = XYZM
+	case hasZ:
+		lay = XYZ
+	case hasM:
+		lay = XYM
+	}
+	return geomType, lay, nil
+}
+
+func (r *wkbReader) readGeometry(depth int) (Geometry, error) {
+	if depth > maxWkbDepth {
+		return nil, fmt.Errorf("%w: geometry collections nested too deeply", ErrInvalidWkb)
+	}
+	geomType, lay, err := r.readHeader(depth)
+	if err != nil {
+		return nil, err
+	}
+	switch geomType {
+	case TypePoint:
+		c, err := r.readCoord(lay)
+		if err != nil {
+			return nil, err
+		}
+		return &Point{Lay: lay, Coord: c, Empty: isNaNCoord(c)}, nil
+	case TypeLineString:
+		coords, err := r.readCoords(lay)
+		if err != nil {
+			return nil, err
+		}
+		return &LineString{Lay: lay, Coords: coords}, nil
+	case TypePolygon:
+		n, err := r.readCount(4)
+		if err != nil {
+			return nil, err
+		}
+		rings := make([][]Coord, n)
+		for i := range rings {
+			if rings[i], err = r.readCoords(lay); err != nil {
+				return nil, err
+			}
+		}
+		return &Polygon{Lay: lay, Rings: rings}, nil
+	}
+
+	// all the other types are collections of wkb geometries
+	n, err := r.readCount(5)
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]Geometry, n)
+	for i := range parts {
+		if parts[i], err = r.readGeometry(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	switch geomType {
+	case TypeMultiPoint:
+		mp := &MultiPoint{Lay: lay, Points: make([]Point, n)}
+		for i, part := range parts {
+			p, ok := part.(*Point)
+			if !ok {
+				return nil, fmt.Errorf("%w: multipoint contains a %s", ErrInvalidWkb, part.Type())
+			}
+			mp.Points[i] = *p
+		}
+		return mp, nil
+	case TypeMultiLineString:
+		ml := &MultiLineString{Lay: lay, LineStrings: make([]LineString, n)}
+		for i, part := range parts {
+			ls, ok := part.(*LineString)
+			if !ok {
+				return nil, fmt.Errorf("%w: multilinestring contains a %s", ErrInvalidWkb, part.Type())
+			}
+			ml.LineStrings[i] = *ls
+		}
+		return ml, nil
+	case TypeMultiPolygon:
+		mp := &MultiPolygon{Lay: lay, Polygons: make([]Polygon, n)}
+		for i, part := range parts {
+			p, ok := part.(*Polygon)
+			if !ok {
+				return nil, fmt.Errorf("%w: multipolygon contains a %s", ErrInvalidWkb, part.Type())
+			}
+			mp.Polygons[i] = *p
+		}
+		return mp, nil
+	default:
+		return &GeometryCollection{Lay: lay, Geometries: parts}, nil
+	}
+}
+
+// DecodeWkb decodes an ISO WKB (or PostGIS EWKB) geometry, it returns the geometry and the number of bytes read
+func DecodeWkb(b []byte) (Geometry, int, error) {
+	r := &wkbReader{buf: b}
+	g, err := r.readGeometry(0)
+	if err != nil {
+		return nil, r.pos, err
+	}
+	return g, r.pos, nil
+}
+
+// DecodeEwkb decodes a PostGIS EWKB geometry, returning its srid or 0 when it has none.
+// Plain ISO WKB is accepted too.
+func DecodeEwkb(b []byte) (Geometry, int, error) {
+	r := &wkbReader{buf: b}
+	g, err := r.readGeometry(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if r.remaining() > 0 {
+		return nil, 0, fmt.Errorf("%w: %d trailing bytes", ErrInvalidWkb, r.remaining())
+	}
+	return g, r.srid, nil
+}
+
+// wkbWriter encodes geometries in little endian WKB, the byte order used by PostGIS and GeoPackage on x86 and arm
+type wkbWriter struct {
+	buf  []byte
+	ewkb bool
+}
+
+func (w *wkbWriter) writeUint32(v uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+func (w *wkbWriter) writeFloat64(v float64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, math.Float64bits(v))
+}
+
+func (w *wkbWriter) writeCoord(c Coord, lay Layout) {
+	w.writeFloat64(c.X)
+	w.writeFloat64(c.Y)
+	if lay.HasZ() {
+		w.writeFloat64(c.Z)
+	}
+	if lay.HasM() {
+		w.writeFloat64(c.M)
+	}
+}
+
+func (w *wkbWriter) writeCoords(coords []Coord, lay Layout) {
+	w.writeUint32(uint32(len(coords)))
+	for _, c := range coords {
+		w.writeCoord(c, lay)
+	}
+}
+
+// writeHeader writes the byte order and the type code, ISO style (1000, 2000, 3000) or EWKB flags
+func (w *wkbWriter) writeHeader(t GeometryType, lay Layout, srid int) {
+	w.buf = append(w.buf, wkbLittleEndian)
+	code := uint32(t)
+	if w.ewkb {
+		if lay.HasZ() {
+			code |= ewkbZFlag
+		}
+		if lay.HasM() {
+			code |= ewkbMFlag
+		}
+		if srid != 0 {
+			code |= ewkbSridFlag
+		}
+		w.writeUint32(code)
+		if srid != 0 {
+			w.writeUint32(uint32(int32(srid)))
+		}
+		return
+	}
+	switch lay {
+	case XYZ:
+		code += 1000
+	case XYM:
+		code += 2000
+	case XYZM:
+		code += 3000
+	}
+	w.writeUint32(code)
+}
+
+func (w *wkbWriter) writeGeometry(g Geometry, srid int) {
+	lay := g.Layout()
+	w.writeHeader(g.Type(), lay, srid)
+	switch t := g.(type) {
+	case *Point:
+		c := t.Coord
+		if t.Empty {
+			c = Coord{X: math.NaN(), Y: math.NaN(), Z: math.NaN(), M: math.NaN()}
+		}
+		w.writeCoord(c, lay)
+	case *LineString:
+		w.writeCoords(t.Coords, lay)
+	case *Polygon:
+		w.writeUint32(uint32(len(t.Rings)))
+		for _, ring := range t.Rings {
+			w.writeCoords(ring, lay)
+		}
+	case *MultiPoint:
+		w.writeUint32(uint32(len(t.Points)))
+		for i := range t.Points {
+			w.writeGeometry(&t.Points[i], 0)
+		}
+	case *MultiLineString:
+		w.writeUint32(uint32(len(t.LineStrings)))
+		for i := range t.LineStrings {
+			w.writeGeometry(&t.LineStrings[i], 0)
+		}
+	case *MultiPolygon:
+		w.writeUint32(uint32(len(t.Polygons)))
+		for i := range t.Polygons {
+			w.writeGeometry(&t.Polygons[i], 0)
+		}
+	case *GeometryCollection:
+		w.writeUint32(uint32(len(t.Geometries)))
+		for _, part := range t.Geometries {
+			w.writeGeometry(part, 0)
+		}
+	}
+}
+
+// EncodeWkb returns g encoded in ISO WKB
+func EncodeWkb(g Geometry) []byte {
+	w := &wkbWriter{}
+	w.writeGeometry(g, 0)
+	return w.buf
+}
+
+// EncodeEwkb returns g encoded in PostGIS EWKB, with the srid flag when srid is not 0
+func EncodeEwkb(g Geometry, srid int) []byte {
+	w := &wkbWriter{ewkb: true}
+	w.writeGeometry(g, srid)
+	return w.buf
+}
@@ -0,0 +1,92 @@
+package geom
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestWkbRoundTrip(t *testing.T) {
+	for _, lay := range layouts {
+		for name, want := range testGeometries(lay) {
+			t.Run(name+layoutSuffix(lay), func(t *testing.T) {
+				b := EncodeWkb(want)
+				got, n, err := DecodeWkb(b)
+				if err != nil {
+					t.Fatalf("DecodeWkb failed : %v", err)
+				}
+				if n != len(b) {
+					t.Errorf("DecodeWkb read %d bytes of %d", n, len(b))
+				}
+				sameGeometry(t, got, want)
+			})
+		}
+	}
+}
+
+func TestEwkbRoundTrip(t *testing.T) {
+	for _, lay := range layouts {
+		for name, want := range testGeometries(lay) {
+			for _, srid := range []int{0, 2056, 4326} {
+				t.Run(fmt.Sprintf("%s%s srid %d", name, layoutSuffix(lay), srid), func(t *testing.T) {
+					got, gotSrid, err := DecodeEwkb(EncodeEwkb(want, srid))
+					if err != nil {
+						t.Fatalf("DecodeEwkb failed : %v", err)
+					}
+					if gotSrid != srid {
+						t.Errorf("DecodeEwkb srid = %d, want %d", gotSrid, srid)
+					}
+					sameGeometry(t, got, want)
+				})
+			}
+		}
+	}
+}
+
+func TestDecodeWkbErrors(t *testing.T) {
+	// every prefix of a valid wkb is rejected instead of panicking or returning a partial geometry
+	for _, lay := range layouts {
+		for name, g := range testGeometries(lay) {
+			b := EncodeEwkb(g, 2056)
+			for i := 0; i < len(b); i++ {
+				if _, _, err := DecodeEwkb(b[:i]); !errors.Is(err, ErrInvalidWkb) {
+					t.Fatalf("DecodeEwkb of the %d first bytes of the %s%s returned %v, want an ErrInvalidWkb", i, name, layoutSuffix(lay), err)
+				}
+			}
+		}
+	}
+
+	hugeLine := []byte{wkbLittleEndian}
+	hugeLine = binary.LittleEndian.AppendUint32(hugeLine, uint32(TypeLineString))
+	hugeLine = binary.LittleEndian.AppendUint32(hugeLine, 1<<30)
+	pointInMultiPolygon := []byte{wkbLittleEndian}
+	pointInMultiPolygon = binary.LittleEndian.AppendUint32(pointInMultiPolygon, uint32(TypeMultiPolygon))
+	pointInMultiPolygon = binary.LittleEndian.AppendUint32(pointInMultiPolygon, 1)
+	pointInMultiPolygon = append(pointInMultiPolygon, EncodeWkb(&Point{Coord: Coord{X: 1, Y: 2}})...)
+	tests := []struct {
+		name string
+		wkb  []byte
+	}{
+		{"empty", nil},
+		{"bad byte order", []byte{2, 1, 0, 0, 0}},
+		{"unknown type", []byte{wkbLittleEndian, 8, 0, 0, 0}},
+		{"count larger than the data", hugeLine},
+		{"point in a multipolygon", pointInMultiPolygon},
+		{"collections nested too deeply", EncodeWkb(nestedCollection(maxWkbDepth + 1))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if g, _, err := DecodeWkb(tt.wkb); !errors.Is(err, ErrInvalidWkb) {
+				t.Errorf("DecodeWkb = %#v, %v, want an ErrInvalidWkb", g, err)
+			}
+		})
+	}
+	if _, _, err := DecodeWkb(EncodeWkb(nestedCollection(maxWkbDepth))); err != nil {
+		t.Errorf("DecodeWkb of %d nested collections failed : %v", maxWkbDepth, err)
+	}
+	trailing := append(EncodeWkb(&Point{Coord: Coord{X: 1, Y: 2}}), 0)
+	if _, _, err := DecodeEwkb(trailing); !errors.Is(err, ErrInvalidWkb) {
+		t.Errorf("DecodeEwkb with a trailing byte returned %v, want an ErrInvalidWkb", err)
+	}
+}
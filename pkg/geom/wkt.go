@@ -0,0 +1,488 @@
+package geom
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidWkt = errors.New("invalid wkt")
+
+// MarshalWKT returns the WKT representation of g, like POINT Z (2537968.5 1152088 450)
+func MarshalWKT(g Geometry) string {
+	var sb strings.Builder
+	writeWkt(&sb, g, true)
+	return sb.String()
+}
+
+// MarshalEWKT returns the PostGIS EWKT representation of g, prefixed by SRID=srid; when srid is not 0
+func MarshalEWKT(g Geometry, srid int) string {
+	if srid == 0 {
+		return MarshalWKT(g)
+	}
+	return fmt.Sprintf("SRID=%d;%s", srid, MarshalWKT(g))
+}
+
+func layoutSuffix(lay Layout) string {
+	switch lay {
+	case XYZ:
+		return " Z"
+	case XYM:
+		return " M"
+	case XYZM:
+		return " ZM"
+	default:
+		return ""
+	}
+}
+
+func writeWktCoord(sb *strings.Builder, c Coord, lay Layout) {
+	sb.WriteString(strconv.FormatFloat(c.X, 'f', -1, 64))
+	sb.WriteByte(' ')
+	sb.WriteString(strconv.FormatFloat(c.Y, 'f', -1, 64))
+	if lay.HasZ() {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatFloat(c.Z, 'f', -1, 64))
+	}
+	if lay.HasM() {
+		sb.WriteByte(' ')
+		sb.WriteString(strconv.FormatFloat(c.M, 'f', -1, 64))
+	}
+}
+
+func writeWktCoords(sb *strings.Builder, coords []Coord, lay Layout) {
+	sb.WriteByte('(')
+	for i, c := range coords {
+		if i > 0 {
+			sb.WriteByte(',')
+		}
+		writeWktCoord(sb, c, lay)
+	}
+	sb.WriteByte(')')
+}
+
+// writeWkt writes g, withTag is false for the parts of a multi geometry which have no type name
+func writeWkt(sb *strings.Builder, g Geometry, withTag bool) {
+	lay := g.Layout()
+	if withTag {
+		sb.WriteString(g.Type().String())
+		sb.WriteString(layoutSuffix(lay))
+		sb.WriteByte(' ')
+	}
+	if g.IsEmpty() {
+		sb.WriteString("EMPTY")
+		return
+	}
+	switch t := g.(type) {
+	case *Point:
+		sb.WriteByte('(')
+		writeWktCoord(sb, t.Coord, lay)
+		sb.WriteByte(')')
+	case *LineString:
+		writeWktCoords(sb, t.Coords, lay)
+	case *Polygon:
+		sb.WriteByte('(')
+		for i, ring := range t.Rings {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeWktCoords(sb, ring, lay)
+		}
+		sb.WriteByte(')')
+	case *MultiPoint:
+		sb.WriteByte('(')
+		for i := range t.Points {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeWkt(sb, &t.Points[i], false)
+		}
+		sb.WriteByte(')')
+	case *MultiLineString:
+		sb.WriteByte('(')
+		for i := range t.LineStrings {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeWkt(sb, &t.LineStrings[i], false)
+		}
+		sb.WriteByte(')')
+	case *MultiPolygon:
+		sb.WriteByte('(')
+		for i := range t.Polygons {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeWkt(sb, &t.Polygons[i], false)
+		}
+		sb.WriteByte(')')
+	case *GeometryCollection:
+		sb.WriteByte('(')
+		for i, part := range t.Geometries {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			writeWkt(sb, part, true)
+		}
+		sb.WriteByte(')')
+	}
+}
+
+// wktParser is a small recursive descent parser over the WKT tokens
+type wktParser struct {
+	tokens []string
+	pos    int
+}
+
+func tokenizeWkt(s string) []string {
+	var tokens []string
+	i := 0
+	for i < len(s) {
+		ch := rune(s[i])
+		switch {
+		case unicode.IsSpace(ch):
+			i++
+		case ch == '(' || ch == ')' || ch == ',':
+			tokens = append(tokens, string(ch))
+			i++
+		default:
+			start := i
+			for i < len(s) && !unicode.IsSpace(rune(s[i])) && s[i] != '(' && s[i] != ')' && s[i] != ',' {
+				i++
+			}
+			tokens = append(tokens, s[start:i])
+		}
+	}
+	return tokens
+}
+
+func (p *wktParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *wktParser) next() string {
+	tok := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return tok
+}
+
+func (p *wktParser) expect(tok string) error {
+	if got := p.next(); got != tok {
+		return fmt.Errorf("%w: expected %q got %q", ErrInvalidWkt, tok, got)
+	}
+	return nil
+}
+
+func (p *wktParser) parseCoord(lay Layout) (Coord, error) {
+	var values []float64
+	for {
+		tok := p.peek()
+		if tok == "," || tok == ")" || tok == "" {
+			break
+		}
+		v, err := strconv.ParseFloat(p.next(), 64)
+		if err != nil {
+			return Coord{}, fmt.Errorf("%w: bad number %q", ErrInvalidWkt, tok)
+		}
+		values = append(values, v)
+	}
+	want := 2
+	if lay.HasZ() {
+		want++
+	}
+	if lay.HasM() {
+		want++
+	}
+	if len(values) != want {
+		return Coord{}, fmt.Errorf("%w: expected %d ordinates got %d", ErrInvalidWkt, want, len(values))
+	}
+	c := Coord{X: values[0], Y: values[1]}
+	switch lay {
+	case XYZ:
+		c.Z = values[2]
+	case XYM:
+		c.M = values[2]
+	case XYZM:
+		c.Z, c.M = values[2], values[3]
+	}
+	return c, nil
+}
+
+func (p *wktParser) parseCoords(lay Layout) ([]Coord, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var coords []Coord
+	for {
+		c, err := p.parseCoord(lay)
+		if err != nil {
+			return nil, err
+		}
+		coords = append(coords, c)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	return coords, p.expect(")")
+}
+
+// parseLine reads the coordinates of a line string, which needs at least 2 of them like in GeoJSON
+func (p *wktParser) parseLine(lay Layout) ([]Coord, error) {
+	coords, err := p.parseCoords(lay)
+	if err != nil {
+		return nil, err
+	}
+	if len(coords) < 2 {
+		return nil, fmt.Errorf("%w: a line string needs at least 2 positions, got %d", ErrInvalidWkt, len(coords))
+	}
+	return coords, nil
+}
+
+// parseRing reads a polygon ring, closed and with at least 4 positions like in GeoJSON
+func (p *wktParser) parseRing(lay Layout) ([]Coord, error) {
+	coords, err := p.parseCoords(lay)
+	if err != nil {
+		return nil, err
+	}
+	if len(coords) < 4 {
+		return nil, fmt.Errorf("%w: a polygon ring needs at least 4 positions, got %d", ErrInvalidWkt, len(coords))
+	}
+	if coords[0] != coords[len(coords)-1] {
+		return nil, fmt.Errorf("%w: a polygon ring must end with its first position", ErrInvalidWkt)
+	}
+	return coords, nil
+}
+
+// parseRings reads the parenthesized list of the rings of a polygon, or of the lines of a multi line string, with parse
+func (p *wktParser) parseRings(lay Layout, parse func(*wktParser, Layout) ([]Coord, error)) ([][]Coord, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var rings [][]Coord
+	for {
+		ring, err := parse(p, lay)
+		if err != nil {
+			return nil, err
+		}
+		rings = append(rings, ring)
+		if p.peek() != "," {
+			break
+		}
+		p.next()
+	}
+	return rings, p.expect(")")
+}
+
+// parseLayout reads the optional Z, M or ZM after the type name, a 3 ordinates coordinate without it is taken as Z
+func (p *wktParser) parseLayout() Layout {
+	switch strings.ToUpper(p.peek()) {
+	case "Z":
+		p.next()
+		return XYZ
+	case "M":
+		p.next()
+		return XYM
+	case "ZM":
+		p.next()
+		return XYZM
+	}
+	return XY
+}
+
+// guessLayout looks ahead to count the ordinates of the first coordinate when no dimension keyword was given
+func (p *wktParser) guessLayout() Layout {
+	i := p.pos
+	for i < len(p.tokens) && p.tokens[i] == "(" {
+		i++
+	}
+	n := 0
+	for i < len(p.tokens) && p.tokens[i] != "," && p.tokens[i] != ")" && p.tokens[i] != "(" {
+		n++
+		i++
+	}
+	switch n {
+	case 3:
+		return XYZ
+	case 4:
+		return XYZM
+	default:
+		return XY
+	}
+}
+
+func (p *wktParser) parseGeometry(depth int) (Geometry, error) {
+	if depth > maxWkbDepth {
+		return nil, fmt.Errorf("%w: geometry collections nested too deeply", ErrInvalidWkt)
+	}
+	name := strings.ToUpper(p.next())
+	lay := p.parseLayout()
+	isEmpty := strings.ToUpper(p.peek()) == "EMPTY"
+	if isEmpty {
+		p.next()
+	} else if lay == XY && name != "GEOMETRYCOLLECTION" {
+		lay = p.guessLayout()
+	}
+	switch name {
+	case "POINT":
+		if isEmpty {
+			return &Point{Lay: lay, Empty: true}, nil
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		c, err := p.parseCoord(lay)
+		if err != nil {
+			return nil, err
+		}
+		return &Point{Lay: lay, Coord: c}, p.expect(")")
+	case "LINESTRING":
+		if isEmpty {
+			return &LineString{Lay: lay}, nil
+		}
+		coords, err := p.parseLine(lay)
+		if err != nil {
+			return nil, err
+		}
+		return &LineString{Lay: lay, Coords: coords}, nil
+	case "POLYGON":
+		if isEmpty {
+			return &Polygon{Lay: lay}, nil
+		}
+		rings, err := p.parseRings(lay, (*wktParser).parseRing)
+		if err != nil {
+			return nil, err
+		}
+		return &Polygon{Lay: lay, Rings: rings}, nil
+	case "MULTIPOINT":
+		mp := &MultiPoint{Lay: lay}
+		if isEmpty {
+			return mp, nil
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			// both MULTIPOINT((1 2),(3 4)) and MULTIPOINT(1 2,3 4) are found in the wild
+			withParens := p.peek() == "("
+			if withParens {
+				p.next()
+			}
+			c, err := p.parseCoord(lay)
+			if err != nil {
+				return nil, err
+			}
+			if withParens {
+				if err := p.expect(")"); err != nil {
+					return nil, err
+				}
+			}
+			mp.Points = append(mp.Points, Point{Lay: lay, Coord: c})
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		return mp, p.expect(")")
+	case "MULTILINESTRING":
+		ml := &MultiLineString{Lay: lay}
+		if isEmpty {
+			return ml, nil
+		}
+		lines, err := p.parseRings(lay, (*wktParser).parseLine)
+		if err != nil {
+			return nil, err
+		}
+		for _, coords := range lines {
+			ml.LineStrings = append(ml.LineStrings, LineString{Lay: lay, Coords: coords})
+		}
+		return ml, nil
+	case "MULTIPOLYGON":
+		mp := &MultiPolygon{Lay: lay}
+		if isEmpty {
+			return mp, nil
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			rings, err := p.parseRings(lay, (*wktParser).parseRing)
+			if err != nil {
+				return nil, err
+			}
+			mp.Polygons = append(mp.Polygons, Polygon{Lay: lay, Rings: rings})
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		return mp, p.expect(")")
+	case "GEOMETRYCOLLECTION":
+		gc := &GeometryCollection{Lay: lay}
+		if isEmpty {
+			return gc, nil
+		}
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		for {
+			part, err := p.parseGeometry(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			gc.Geometries = append(gc.Geometries, part)
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		if len(gc.Geometries) > 0 {
+			gc.Lay = gc.Geometries[0].Layout()
+		}
+		return gc, p.expect(")")
+	default:
+		return nil, fmt.Errorf("%w: unknown geometry type %q", ErrInvalidWkt, name)
+	}
+}
+
+// UnmarshalWKT parses a WKT geometry
+func UnmarshalWKT(s string) (Geometry, error) {
+	g, srid, err := UnmarshalEWKT(s)
+	if err == nil && srid != 0 {
+		return nil, fmt.Errorf("%w: unexpected SRID prefix, use UnmarshalEWKT", ErrInvalidWkt)
+	}
+	return g, err
+}
+
+// UnmarshalEWKT parses a PostGIS EWKT geometry like SRID=2056;POINT(2537968.5 1152088), the srid is 0 when absent
+func UnmarshalEWKT(s string) (Geometry, int, error) {
+	srid := 0
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToUpper(s), "SRID=") {
+		sep := strings.IndexByte(s, ';')
+		if sep < 0 {
+			return nil, 0, fmt.Errorf("%w: missing ; after SRID", ErrInvalidWkt)
+		}
+		var err error
+		if srid, err = strconv.Atoi(s[len("SRID="):sep]); err != nil {
+			return nil, 0, fmt.Errorf("%w: bad SRID %q", ErrInvalidWkt, s[len("SRID="):sep])
+		}
+		s = s[sep+1:]
+	}
+	p := &wktParser{tokens: tokenizeWkt(s)}
+	g, err := p.parseGeometry(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, 0, fmt.Errorf("%w: unexpected %q after the geometry", ErrInvalidWkt, p.peek())
+	}
+	return g, srid, nil
+}
@@ -0,0 +1,87 @@
+package geom
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestWktRoundTrip(t *testing.T) {
+	for _, lay := range layouts {
+		for name, want := range testGeometries(lay) {
+			t.Run(name+layoutSuffix(lay), func(t *testing.T) {
+				got, err := UnmarshalWKT(MarshalWKT(want))
+				if err != nil {
+					t.Fatalf("UnmarshalWKT(%s) failed : %v", MarshalWKT(want), err)
+				}
+				sameGeometry(t, got, want)
+			})
+		}
+	}
+}
+
+func TestEwktRoundTrip(t *testing.T) {
+	want := testGeometries(XYZ)["polygon with a hole"]
+	got, srid, err := UnmarshalEWKT(MarshalEWKT(want, 2056))
+	if err != nil {
+		t.Fatalf("UnmarshalEWKT failed : %v", err)
+	}
+	if srid != 2056 {
+		t.Errorf("UnmarshalEWKT srid = %d, want 2056", srid)
+	}
+	sameGeometry(t, got, want)
+	if _, err := UnmarshalWKT(MarshalEWKT(want, 2056)); !errors.Is(err, ErrInvalidWkt) {
+		t.Errorf("UnmarshalWKT with a SRID prefix returned %v, want an ErrInvalidWkt", err)
+	}
+}
+
+func TestUnmarshalWKT(t *testing.T) {
+	tests := []struct {
+		wkt  string
+		want Geometry
+	}{
+		{"point(1 2)", &Point{Coord: Coord{X: 1, Y: 2}}},
+		{"POINT (1 2 3)", &Point{Lay: XYZ, Coord: Coord{X: 1, Y: 2, Z: 3}}},
+		{"POINT M (1 2 3)", &Point{Lay: XYM, Coord: Coord{X: 1, Y: 2, M: 3}}},
+		{"MULTIPOINT (1 2, 3 4)", &MultiPoint{Points: []Point{{Coord: Coord{X: 1, Y: 2}}, {Coord: Coord{X: 3, Y: 4}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.wkt, func(t *testing.T) {
+			got, err := UnmarshalWKT(tt.wkt)
+			if err != nil {
+				t.Fatalf("UnmarshalWKT failed : %v", err)
+			}
+			sameGeometry(t, got, tt.want)
+		})
+	}
+}
+
+func TestUnmarshalWKTErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		wkt  string
+	}{
+		{"empty", ""},
+		{"unknown type", "CIRCLE(0 0, 1)"},
+		{"missing parenthesis", "POINT(1 2"},
+		{"bad number", "POINT(1 a)"},
+		{"wrong number of ordinates", "POINT Z (1 2)"},
+		{"single position line string", "LINESTRING(0 0)"},
+		{"single position line in a multi line string", "MULTILINESTRING((0 0, 1 1),(2 2))"},
+		{"unclosed ring", "POLYGON((0 0, 1 0, 1 1, 0 1))"},
+		{"short ring", "POLYGON((0 0, 1 0, 0 0))"},
+		{"unclosed hole", "POLYGON((0 0, 10 0, 10 10, 0 10, 0 0),(1 1, 2 1, 2 2, 1 2))"},
+		{"bad ring in a multipolygon", "MULTIPOLYGON(((0 0, 1 0, 1 1, 0 0)),((0 0, 1 0, 1 1)))"},
+		{"bad SRID", "SRID=lv95;POINT(1 2)"},
+		{"collections nested too deeply", nestedCollectionWkt(maxWkbDepth + 1)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if g, _, err := UnmarshalEWKT(tt.wkt); !errors.Is(err, ErrInvalidWkt) {
+				t.Errorf("UnmarshalEWKT(%q) = %#v, %v, want an ErrInvalidWkt", tt.wkt, g, err)
+			}
+		})
+	}
+	if _, err := UnmarshalWKT(nestedCollectionWkt(maxWkbDepth)); err != nil {
+		t.Errorf("UnmarshalWKT of %d nested collections failed : %v", maxWkbDepth, err)
+	}
+}
@@ -0,0 +1,171 @@
+// Package geopackage decodes the GeoPackage Binary geometry format without any need of SpatiaLite
+// see http://www.geopackage.org/spec/#gpb_format
+package geopackage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+const (
+	magic0            = 'G'
+	magic1            = 'P'
+	headerFixedSize   = 8
+	flagBinaryType    = 0x20 // 1 means an extended, non standard, geometry type
+	flagEmpty         = 0x10
+	flagEnvelopeMask  = 0x0e
+	flagEnvelopeShift = 1
+	flagByteOrder     = 0x01 // 1 means little endian for the header srs_id and envelope
+)
+
+// EnvelopeType tells which envelope is stored in the header
+type EnvelopeType uint8
+
+const (
+	EnvelopeNone EnvelopeType = 0
+	EnvelopeXY   EnvelopeType = 1
+	EnvelopeXYZ  EnvelopeType = 2
+	EnvelopeXYM  EnvelopeType = 3
+	EnvelopeXYZM EnvelopeType = 4
+)
+
+// size returns the number of doubles of the envelope, or -1 for an invalid indicator
+func (e EnvelopeType) size() int {
+	switch e {
+	case EnvelopeNone:
+		return 0
+	case EnvelopeXY:
+		return 4
+	case EnvelopeXYZ, EnvelopeXYM:
+		return 6
+	case EnvelopeXYZM:
+		return 8
+	default:
+		return -1
+	}
+}
+
+var (
+	ErrNotGeoPackageBinary = errors.New("not a GeoPackage binary geometry")
+	ErrExtendedGeometry    = errors.New("extended GeoPackage geometry types are not supported")
+)
+
+// Envelope is the bounding box stored in the header, Z and M ranges are only set for the envelope types having them
+type Envelope struct {
+	MinX, MaxX, MinY, MaxY float64
+	MinZ, MaxZ             float64
+	MinM, MaxM             float64
+}
+
+// Header is the GeoPackage Binary header preceding the WKB geometry
+type Header struct {
+	Version      uint8
+	Extended     bool // the geometry uses an extension type, its blob is not a standard WKB
+	Empty        bool
+	EnvelopeType EnvelopeType
+	Envelope     Envelope
+	SrsID        int32
+	Size         int // length of the header in bytes, the WKB starts at this offset
+}
+
+// DecodeHeader decodes the GeoPackage Binary header at the start of blob
+func DecodeHeader(blob []byte) (*Header, error) {
+	if len(blob) < headerFixedSize {
+		return nil, fmt.Errorf("%w: only %d bytes", ErrNotGeoPackageBinary, len(blob))
+	}
+	if blob[0] != magic0 || blob[1] != magic1 {
+		return nil, fmt.Errorf("%w: bad magic %q", ErrNotGeoPackageBinary, blob[:2])
+	}
+	flags := blob[3]
+	h := &Header{
+		Version:      blob[2],
+		Extended:     flags&flagBinaryType != 0,
+		Empty:        flags&flagEmpty != 0,
+		EnvelopeType: EnvelopeType((flags & flagEnvelopeMask) >> flagEnvelopeShift),
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if flags&flagByteOrder != 0 {
+		order = binary.LittleEndian
+	}
+	h.SrsID = int32(order.Uint32(blob[4:8]))
+
+	n := h.EnvelopeType.size()
+	if n < 0 {
+		return nil, fmt.Errorf("%w: invalid envelope indicator %d", ErrNotGeoPackageBinary, h.EnvelopeType)
+	}
+	h.Size = headerFixedSize + n*8
+	if len(blob) < h.Size {
+		return nil, fmt.Errorf("%w: truncated envelope", ErrNotGeoPackageBinary)
+	}
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = math.Float64frombits(order.Uint64(blob[headerFixedSize+i*8:]))
+	}
+	if n >= 4 {
+		h.Envelope.MinX, h.Envelope.MaxX, h.Envelope.MinY, h.Envelope.MaxY = values[0], values[1], values[2], values[3]
+	}
+	switch h.EnvelopeType {
+	case EnvelopeXYZ:
+		h.Envelope.MinZ, h.Envelope.MaxZ = values[4], values[5]
+	case EnvelopeXYM:
+		h.Envelope.MinM, h.Envelope.MaxM = values[4], values[5]
+	case EnvelopeXYZM:
+		h.Envelope.MinZ, h.Envelope.MaxZ = values[4], values[5]
+		h.Envelope.MinM, h.Envelope.MaxM = values[6], values[7]
+	}
+	return h, nil
+}
+
+// Decode decodes a GeoPackage Binary blob, as stored in the geometry column of a features table
+func Decode(blob []byte) (*Header, geom.Geometry, error) {
+	h, err := DecodeHeader(blob)
+	if err != nil {
+		return nil, nil, err
+	}
+	if h.Extended {
+		return h, nil, ErrExtendedGeometry
+	}
+	g, _, err := geom.DecodeWkb(blob[h.Size:])
+	if err != nil {
+		return h, nil, err
+	}
+	return h, g, nil
+}
+
+// Encode returns g as a GeoPackage Binary blob in little endian with an XY envelope, or no envelope for a point
+func Encode(g geom.Geometry, srsID int32) []byte {
+	flags := byte(flagByteOrder)
+	var envelope []float64
+	switch {
+	case g.IsEmpty():
+		flags |= flagEmpty
+	case g.Type() != geom.TypePoint:
+		// the spec recommends no envelope for points, it would only repeat the coordinates
+		e := geom.EnvelopeOf(g)
+		envelope = []float64{e.MinX, e.MaxX, e.MinY, e.MaxY}
+		flags |= byte(EnvelopeXY) << flagEnvelopeShift
+	}
+	wkb := geom.EncodeWkb(g)
+	blob := make([]byte, 0, headerFixedSize+len(envelope)*8+len(wkb))
+	blob = append(blob, magic0, magic1, 0, flags)
+	blob = binary.LittleEndian.AppendUint32(blob, uint32(srsID))
+	for _, v := range envelope {
+		blob = binary.LittleEndian.AppendUint64(blob, math.Float64bits(v))
+	}
+	return append(blob, wkb...)
+}
+
+func init() {
+	// allows geom.Value to scan the geometry columns of a GeoPackage
+	geom.RegisterBlobFormat("gpkg", []byte{magic0, magic1}, func(blob []byte) (geom.Geometry, int, error) {
+		h, g, err := Decode(blob)
+		if err != nil {
+			return nil, 0, err
+		}
+		return g, int(h.SrsID), nil
+	})
+}
@@ -0,0 +1,192 @@
+package geopackage
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+const (
+	// ApplicationID is the value of PRAGMA application_id of a GeoPackage ('GPKG')
+	ApplicationID = 0x47504B47
+	// UserVersion is the value of PRAGMA user_version for the version 1.3 of the spec
+	UserVersion = 10300
+	// RtreeExtension is the name of the spatial index extension in gpkg_extensions
+	RtreeExtension = "gpkg_rtree_index"
+	// WktLv95 is the definition of EPSG:2056 CH1903+ / LV95 stored in gpkg_spatial_ref_sys
+	WktLv95  = `PROJCS["CH1903+ / LV95",GEOGCS["CH1903+",DATUM["CH1903+",SPHEROID["Bessel 1841",6377397.155,299.1528128,AUTHORITY["EPSG","7004"]],TOWGS84[674.374,15.056,405.346,0,0,0,0],AUTHORITY["EPSG","6150"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4150"]],PROJECTION["Hotine_Oblique_Mercator_Azimuth_Center"],PARAMETER["latitude_of_center",46.95240555555556],PARAMETER["longitude_of_center",7.439583333333333],PARAMETER["azimuth",90],PARAMETER["rectified_grid_angle",90],PARAMETER["scale_factor",1],PARAMETER["false_easting",2600000],PARAMETER["false_northing",1200000],UNIT["metre",1,AUTHORITY["EPSG","9001"]],AXIS["Easting",EAST],AXIS["Northing",NORTH],AUTHORITY["EPSG","2056"]]`
+	wktWgs84 = `GEOGCS["WGS 84",DATUM["WGS_1984",SPHEROID["WGS 84",6378137,298.257223563,AUTHORITY["EPSG","7030"]],AUTHORITY["EPSG","6326"]],PRIMEM["Greenwich",0,AUTHORITY["EPSG","8901"]],UNIT["degree",0.0174532925199433,AUTHORITY["EPSG","9122"]],AUTHORITY["EPSG","4326"]]`
+)
+
+// CoreTablesSQL creates the mandatory metadata tables of a GeoPackage 1.3 with the three required srs
+var CoreTablesSQL = []string{
+	fmt.Sprintf("PRAGMA application_id = %d;", ApplicationID),
+	fmt.Sprintf("PRAGMA user_version = %d;", UserVersion),
+	`CREATE TABLE IF NOT EXISTS gpkg_spatial_ref_sys (
+  srs_name TEXT NOT NULL,
+  srs_id INTEGER PRIMARY KEY,
+  organization TEXT NOT NULL,
+  organization_coordsys_id INTEGER NOT NULL,
+  definition TEXT NOT NULL,
+  description TEXT
+);`,
+	`CREATE TABLE IF NOT EXISTS gpkg_contents (
+  table_name TEXT NOT NULL PRIMARY KEY,
+  data_type TEXT NOT NULL,
+  identifier TEXT UNIQUE,
+  description TEXT DEFAULT '',
+  last_change DATETIME NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%fZ','now')),
+  min_x DOUBLE, min_y DOUBLE, max_x DOUBLE, max_y DOUBLE,
+  srs_id INTEGER,
+  CONSTRAINT fk_gc_r_srs_id FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys(srs_id)
+);`,
+	`CREATE TABLE IF NOT EXISTS gpkg_geometry_columns (
+  table_name TEXT NOT NULL,
+  column_name TEXT NOT NULL,
+  geometry_type_name TEXT NOT NULL,
+  srs_id INTEGER NOT NULL,
+  z TINYINT NOT NULL,
+  m TINYINT NOT NULL,
+  CONSTRAINT pk_geom_cols PRIMARY KEY (table_name, column_name),
+  CONSTRAINT uk_gc_table_name UNIQUE (table_name),
+  CONSTRAINT fk_gc_tn FOREIGN KEY (table_name) REFERENCES gpkg_contents(table_name),
+  CONSTRAINT fk_gc_srs FOREIGN KEY (srs_id) REFERENCES gpkg_spatial_ref_sys (srs_id)
+);`,
+	`CREATE TABLE IF NOT EXISTS gpkg_extensions (
+  table_name TEXT,
+  column_name TEXT,
+  extension_name TEXT NOT NULL,
+  definition TEXT NOT NULL,
+  scope TEXT NOT NULL,
+  CONSTRAINT ge_tce UNIQUE (table_name, column_name, extension_name)
+);`,
+	`INSERT OR IGNORE INTO gpkg_spatial_ref_sys VALUES
+  ('Undefined cartesian SRS', -1, 'NONE', -1, 'undefined', 'undefined cartesian coordinate reference system'),
+  ('Undefined geographic SRS', 0, 'NONE', 0, 'undefined', 'undefined geographic coordinate reference system'),
+  ('WGS 84 geodetic', 4326, 'EPSG', 4326, '` + wktWgs84 + `', 'longitude/latitude coordinates in decimal degrees on the WGS 84 spheroid');`,
+	`INSERT OR IGNORE INTO gpkg_spatial_ref_sys VALUES
+  ('CH1903+ / LV95', 2056, 'EPSG', 2056, '` + WktLv95 + `', 'Swiss coordinates, used by swisstopo and the canton of Vaud');`,
+}
+
+// quoteIdentifier quotes name as a sqlite identifier
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// RtreeName returns the name of the spatial index virtual table of a geometry column
+func RtreeName(table, column string) string {
+	return fmt.Sprintf("rtree_%s_%s", table, column)
+}
+
+// CreateRtreeSQL returns the statements creating the spatial index of table.column and registering the extension.
+// The index must then be filled with InsertRtreeSQL, and CreateRtreeTriggersSQL keeps it in sync afterward.
+func CreateRtreeSQL(table, column string) []string {
+	return []string{
+		fmt.Sprintf("CREATE VIRTUAL TABLE %s USING rtree(id, minx, maxx, miny, maxy);", quoteIdentifier(RtreeName(table, column))),
+		fmt.Sprintf(`INSERT INTO gpkg_extensions (table_name, column_name, extension_name, definition, scope)
+VALUES ('%s', '%s', '%s', 'http://www.geopackage.org/spec120/#extension_rtree', 'write-only');`,
+			strings.ReplaceAll(table, "'", "''"), strings.ReplaceAll(column, "'", "''"), RtreeExtension),
+	}
+}
+
+// InsertRtreeSQL returns the statement inserting one envelope in the spatial index, the parameters are id, minx, maxx, miny, maxy
+func InsertRtreeSQL(table, column string) string {
+	return fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?, ?, ?);", quoteIdentifier(RtreeName(table, column)))
+}
+
+// CreateRtreeTriggersSQL returns the triggers defined by the spec to maintain the spatial index of table.column.
+// They use ST_IsEmpty, ST_MinX, ST_MaxX, ST_MinY and ST_MaxY, available with mod_spatialite or with the functions
+// registered by pkg/database when it is missing.
+func CreateRtreeTriggersSQL(table, column, pk string) []string {
+	t, c, i := quoteIdentifier(table), quoteIdentifier(column), quoteIdentifier(pk)
+	rtree := quoteIdentifier(RtreeName(table, column))
+	name := func(suffix string) string {
+		return quoteIdentifier(RtreeName(table, column) + "_" + suffix)
+	}
+	return []string{
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER INSERT ON %[2]s WHEN (new.%[3]s NOT NULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  INSERT OR REPLACE INTO %[4]s VALUES (NEW.%[5]s, ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s), ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s));
+END;`, name("insert"), t, c, rtree, i),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER UPDATE ON %[2]s WHEN OLD.%[5]s = NEW.%[5]s AND (NEW.%[3]s NOTNULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  INSERT OR REPLACE INTO %[4]s VALUES (NEW.%[5]s, ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s), ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s));
+END;`, name("update1"), t, c, rtree, i),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER UPDATE ON %[2]s WHEN OLD.%[5]s = NEW.%[5]s AND (NEW.%[3]s ISNULL OR ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[4]s WHERE id = OLD.%[5]s;
+END;`, name("update2"), t, c, rtree, i),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER UPDATE ON %[2]s WHEN OLD.%[5]s != NEW.%[5]s AND (NEW.%[3]s NOTNULL AND NOT ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[4]s WHERE id = OLD.%[5]s;
+  INSERT OR REPLACE INTO %[4]s VALUES (NEW.%[5]s, ST_MinX(NEW.%[3]s), ST_MaxX(NEW.%[3]s), ST_MinY(NEW.%[3]s), ST_MaxY(NEW.%[3]s));
+END;`, name("update3"), t, c, rtree, i),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER UPDATE ON %[2]s WHEN OLD.%[5]s != NEW.%[5]s AND (NEW.%[3]s ISNULL OR ST_IsEmpty(NEW.%[3]s))
+BEGIN
+  DELETE FROM %[4]s WHERE id IN (OLD.%[5]s, NEW.%[5]s);
+END;`, name("update4"), t, c, rtree, i),
+		fmt.Sprintf(`CREATE TRIGGER %[1]s AFTER DELETE ON %[2]s WHEN old.%[3]s NOT NULL
+BEGIN
+  DELETE FROM %[4]s WHERE id = OLD.%[5]s;
+END;`, name("delete"), t, c, rtree, i),
+	}
+}
+
+// EnvelopeFunc returns the value of one of the functions used by the rtree triggers for a GeoPackage blob,
+// name is one of ST_MinX, ST_MaxX, ST_MinY, ST_MaxY. ok is false for a NULL, empty or invalid geometry.
+func EnvelopeFunc(name string, blob []byte) (value float64, ok bool) {
+	h, g, err := Decode(blob)
+	if err != nil || g == nil || g.IsEmpty() {
+		return 0, false
+	}
+	e := h.Envelope
+	if h.EnvelopeType == EnvelopeNone {
+		ge := geom.EnvelopeOf(g)
+		e = Envelope{MinX: ge.MinX, MaxX: ge.MaxX, MinY: ge.MinY, MaxY: ge.MaxY}
+	}
+	switch strings.ToLower(name) {
+	case "st_minx":
+		return e.MinX, true
+	case "st_maxx":
+		return e.MaxX, true
+	case "st_miny":
+		return e.MinY, true
+	case "st_maxy":
+		return e.MaxY, true
+	default:
+		return 0, false
+	}
+}
+
+// EnvelopeIntersectsFunc returns true when the envelope of a GeoPackage blob intersects minX, minY, maxX, maxY,
+// it lets a query filter by bbox the tables without rtree, the envelope being read from the header when it has one
+func EnvelopeIntersectsFunc(blob []byte, minX, minY, maxX, maxY float64) bool {
+	h, err := DecodeHeader(blob)
+	if err != nil || h.Empty {
+		return false
+	}
+	e := h.Envelope
+	if h.EnvelopeType == EnvelopeNone {
+		_, g, err := Decode(blob)
+		if err != nil || g == nil || g.IsEmpty() {
+			return false
+		}
+		ge := geom.EnvelopeOf(g)
+		e = Envelope{MinX: ge.MinX, MaxX: ge.MaxX, MinY: ge.MinY, MaxY: ge.MaxY}
+	}
+	return e.MaxX >= minX && e.MinX <= maxX && e.MaxY >= minY && e.MinY <= maxY
+}
+
+// IsEmptyFunc implements ST_IsEmpty for a GeoPackage blob
+func IsEmptyFunc(blob []byte) bool {
+	h, err := DecodeHeader(blob)
+	if err != nil {
+		return true
+	}
+	if h.Empty {
+		return true
+	}
+	_, g, err := Decode(blob)
+	return err != nil || g == nil || g.IsEmpty()
+}
@@ -0,0 +1,222 @@
+package go_http_server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/rs/xid"
+)
+
+const (
+	HeaderRequestId    = "X-Request-Id"
+	headerForwardedFor = "X-Forwarded-For"
+	maxRequestIdLength = 128
+)
+
+type contextKey int
+
+const requestInfoKey contextKey = iota
+
+// requestInfo is shared by RequestTracing with the inner handlers through the request context
+type requestInfo struct {
+	id       string
+	clientIP string
+	route    string // the pattern of the matched route, set by the route handler
+	proxied  bool   // the request comes from one of the trusted proxies, its X-Forwarded-* headers can be used
+}
+
+func getRequestInfo(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// withRequestInfo returns the request info of r, adding one to its context when no middleware did it before
+func withRequestInfo(r *http.Request) (*requestInfo, *http.Request) {
+	if info := getRequestInfo(r.Context()); info != nil {
+		return info, r
+	}
+	info := &requestInfo{clientIP: remoteIP(r)}
+	return info, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info))
+}
+
+// GetRequestId returns the id given to the request by RequestTracing, or an empty string without it
+func GetRequestId(ctx context.Context) string {
+	if info := getRequestInfo(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// GetClientIP returns the ip of the client found by RequestTracing, or the ip of the remote address without it
+func GetClientIP(r *http.Request) string {
+	if info := getRequestInfo(r.Context()); info != nil {
+		return info.clientIP
+	}
+	return remoteIP(r)
+}
+
+// FromTrustedProxy tells if the request was received from one of the TRUSTED_PROXIES given to RequestTracing,
+// only then the X-Forwarded-* headers were set by the proxy and not by the client
+func FromTrustedProxy(r *http.Request) bool {
+	if info := getRequestInfo(r.Context()); info != nil {
+		return info.proxied
+	}
+	return false
+}
+
+// withRoute records the pattern of the route in the request info, the access log cannot get it from the mux
+func withRoute(pattern string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := getRequestInfo(r.Context()); info != nil {
+			info.route = pattern
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// GetTrustedProxiesFromEnv returns the ip addresses or CIDR ranges of the env variable TRUSTED_PROXIES,
+// like "10.0.0.0/8,192.168.1.10", the X-Forwarded-For header is only used when the request comes from one of them
+func GetTrustedProxiesFromEnv() ([]netip.Prefix, error) {
+	var proxies []netip.Prefix
+	val, exist := os.LookupEnv("TRUSTED_PROXIES")
+	if !exist {
+		return proxies, nil
+	}
+	for _, item := range strings.Split(val, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES should be a list of ip addresses or CIDR ranges, got %q", item)
+			}
+			proxies = append(proxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES should be a list of ip addresses or CIDR ranges, got %q", item)
+		}
+		proxies = append(proxies, prefix.Masked())
+	}
+	return proxies, nil
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrusted(ip string, trusted []netip.Prefix) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP walks X-Forwarded-For from the right while the hops are trusted proxies, the first untrusted one
+// is the client, the left part of the header can be forged by anyone and is ignored
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	ip := remoteIP(r)
+	if !isTrusted(ip, trusted) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values(headerForwardedFor), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		ip = hop
+		if !isTrusted(hop, trusted) {
+			break
+		}
+	}
+	return ip
+}
+
+// validRequestId accepts the ids given by a proxy or a client when they are short and printable
+func validRequestId(id string) bool {
+	if id == "" || len(id) > maxRequestIdLength {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.:", c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder keeps the status and the size of the response for the access log
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the Flush and deadlines of the original ResponseWriter
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// RequestTracing gives each request an id, the X-Request-Id received when valid or a new one, available to the
+// handlers with GetRequestId and echoed in the response, then writes one access log line when the request is done
+func RequestTracing(l golog.MyLogger, trustedProxies []netip.Prefix) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			info := &requestInfo{id: r.Header.Get(HeaderRequestId), clientIP: clientIP(r, trustedProxies),
+				proxied: isTrusted(remoteIP(r), trustedProxies)}
+			if !validRequestId(info.id) {
+				info.id = xid.New().String()
+			}
+			w.Header().Set(HeaderRequestId, info.id)
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK // nothing was written
+				}
+				l.Info("access request_id=%s method=%s route=%q path=%q status=%d bytes=%d duration_ms=%.3f client_ip=%s",
+					info.id, r.Method, info.route, r.URL.Path, rec.status, rec.bytes,
+					float64(time.Since(start).Microseconds())/1000, info.clientIP)
+			}()
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package go_http_server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCheckTimeout = 2 * time.Second // max time given to each registered check
+	checkStatusOk       = "ok"
+	checkStatusFail     = "fail"
+)
+
+// CheckFunc is a readiness and health check of one component, it returns nil when the component is usable
+type CheckFunc func(ctx context.Context) error
+
+type registeredCheck struct {
+	name  string
+	check CheckFunc
+}
+
+// checkRegistry holds the checks run by the /readiness and /health handlers
+type checkRegistry struct {
+	mu     sync.RWMutex
+	checks []registeredCheck
+}
+
+// ComponentStatus is the result of one check in the JSON returned by /readiness and /health
+type ComponentStatus struct {
+	Status   string `json:"status"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ChecksResult is the JSON returned by /readiness and /health
+type ChecksResult struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+// checksSchema describes a ChecksResult in /openapi.json
+var checksSchema = &Schema{
+	Type:     "object",
+	Required: []string{"status", "components"},
+	Properties: map[string]*Schema{
+		"status": {Type: "string", Enum: []string{checkStatusOk, checkStatusFail}},
+		"components": {Type: "object", AdditionalProperties: &Schema{
+			Type:     "object",
+			Required: []string{"status", "duration"},
+			Properties: map[string]*Schema{
+				"status":   {Type: "string", Enum: []string{checkStatusOk, checkStatusFail}},
+				"duration": {Type: "string"},
+				"error":    {Type: "string"},
+			},
+		}},
+	},
+}
+
+// AddChecker registers a check that must succeed for /readiness and /health to answer 200.
+// It can be called before or after Run, a check registered twice with the same name replaces the first one.
+func (s *HttpServer) AddChecker(name string, check CheckFunc) {
+	s.checks.mu.Lock()
+	defer s.checks.mu.Unlock()
+	for i, c := range s.checks.checks {
+		if c.name == name {
+			s.checks.checks[i].check = check
+			return
+		}
+	}
+	s.checks.checks = append(s.checks.checks, registeredCheck{name: name, check: check})
+}
+
+// runChecks runs concurrently the registered checks, each one limited to defaultCheckTimeout.
+// A check still running after its timeout is reported as failed without being waited for.
+func (s *HttpServer) runChecks(ctx context.Context) (ChecksResult, bool) {
+	s.checks.mu.RLock()
+	checks := make([]registeredCheck, len(s.checks.checks))
+	copy(checks, s.checks.checks)
+	s.checks.mu.RUnlock()
+
+	result := ChecksResult{Status: checkStatusOk, Components: make(map[string]ComponentStatus, len(checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range checks {
+		wg.Add(1)
+		go func(c registeredCheck) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, defaultCheckTimeout)
+			defer cancel()
+			start := time.Now()
+			done := make(chan error, 1)
+			go func() {
+				done <- c.check(checkCtx)
+			}()
+			var err error
+			select {
+			case err = <-done:
+			case <-checkCtx.Done():
+				err = fmt.Errorf("check did not answer in time: %w", checkCtx.Err())
+			}
+			status := ComponentStatus{Status: checkStatusOk, Duration: time.Since(start).String()}
+			if err != nil {
+				status.Status = checkStatusFail
+				status.Error = err.Error()
+			}
+			mu.Lock()
+			result.Components[c.name] = status
+			mu.Unlock()
+		}(c)
+	}
+	wg.Wait()
+	healthy := true
+	for name, status := range result.Components {
+		if status.Status != checkStatusOk {
+			healthy = false
+			s.logger.Warn("check %s failed : %s", name, status.Error)
+		}
+	}
+	if !healthy {
+		result.Status = checkStatusFail
+	}
+	return result, healthy
+}
+
+// checksResponse writes the result of the checks with status 200, or 503 if one of them failed
+func (s *HttpServer) checksResponse(w http.ResponseWriter, r *http.Request) {
+	result, healthy := s.runChecks(r.Context())
+	if healthy {
+		s.jsonResponse(w, r, result)
+		return
+	}
+	s.jsonResponseWithStatus(w, r, http.StatusServiceUnavailable, result)
+}
@@ -0,0 +1,186 @@
+package go_http_server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultCorsMethods = "GET,HEAD,POST"
+	defaultCorsHeaders = "Authorization,Content-Type,X-Request-Id,X-Api-Key"
+	defaultCorsExposed = "X-Request-Id,RateLimit-Policy,RateLimit-Limit,RateLimit-Remaining,RateLimit-Reset,Retry-After,Content-Crs,ETag"
+	defaultCorsMaxAge  = 600 // seconds a browser can reuse a preflight answer
+)
+
+// CorsConfig lists what the front-ends served from other origins are allowed to do
+type CorsConfig struct {
+	// AllowedOrigins like http://localhost:3000, https://*.example.org for all its subdomains or * for any origin
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int
+}
+
+// GetCorsConfigFromEnv returns the CorsConfig of the env variables CORS_ALLOWED_ORIGINS, CORS_ALLOWED_METHODS,
+// CORS_ALLOWED_HEADERS, CORS_EXPOSED_HEADERS, CORS_ALLOW_CREDENTIALS and CORS_MAX_AGE,
+// without allowed origins the cross-origin requests are not allowed
+func GetCorsConfigFromEnv() (*CorsConfig, error) {
+	getList := func(name, defaultValue string) []string {
+		val, exist := os.LookupEnv(name)
+		if !exist {
+			val = defaultValue
+		}
+		var list []string
+		for _, item := range strings.Split(val, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		return list
+	}
+	c := &CorsConfig{
+		AllowedOrigins: getList("CORS_ALLOWED_ORIGINS", ""),
+		AllowedMethods: getList("CORS_ALLOWED_METHODS", defaultCorsMethods),
+		AllowedHeaders: getList("CORS_ALLOWED_HEADERS", defaultCorsHeaders),
+		ExposedHeaders: getList("CORS_EXPOSED_HEADERS", defaultCorsExposed),
+		MaxAge:         defaultCorsMaxAge,
+	}
+	if val, exist := os.LookupEnv("CORS_ALLOW_CREDENTIALS"); exist {
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, fmt.Errorf("env CORS_ALLOW_CREDENTIALS should contain true or false, got '%s'", val)
+		}
+		c.AllowCredentials = b
+	}
+	if val, exist := os.LookupEnv("CORS_MAX_AGE"); exist {
+		maxAge, err := strconv.Atoi(val)
+		if err != nil || maxAge < 0 {
+			return nil, fmt.Errorf("env CORS_MAX_AGE should be a positive number of seconds, got '%s'", val)
+		}
+		c.MaxAge = maxAge
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Validate refuses the credentials for any origin, browsers do not accept them with Access-Control-Allow-Origin: *
+func (c *CorsConfig) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" && c.AllowCredentials {
+			return errors.New("CORS credentials cannot be allowed for any origin *, list the allowed origins")
+		}
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("CORS allowed origin %q should be like https://host[:port] or *", origin)
+		}
+	}
+	return nil
+}
+
+// originAllowed returns true when origin matches one of the allowed origins, case insensitively
+func (c *CorsConfig) originAllowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, allowed := range c.AllowedOrigins {
+		allowed = strings.ToLower(strings.TrimSuffix(allowed, "/"))
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+		// https://*.example.org allows https://a.example.org but not https://example.org
+		if scheme, domain, found := strings.Cut(allowed, "://*."); found {
+			if rest, ok := strings.CutPrefix(origin, scheme+"://"); ok && strings.HasSuffix(rest, "."+domain) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if item == "*" || strings.EqualFold(item, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cors answers the preflight requests of the allowed origins and adds the CORS headers to their requests,
+// the requests of other origins are served without CORS headers so the browsers refuse the responses
+func Cors(c *CorsConfig) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(c.AllowedOrigins) == 0 {
+			return next
+		}
+		allowedMethods := strings.Join(c.AllowedMethods, ", ")
+		allowedHeaders := strings.Join(c.AllowedHeaders, ", ")
+		exposedHeaders := strings.Join(c.ExposedHeaders, ", ")
+		anyOrigin := containsFold(c.AllowedOrigins, "*") && !c.AllowCredentials
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if !anyOrigin {
+				h.Add("Vary", "Origin")
+			}
+			if preflight {
+				h.Add("Vary", "Access-Control-Request-Method")
+				h.Add("Vary", "Access-Control-Request-Headers")
+			}
+			if origin == "" || !c.originAllowed(origin) {
+				if preflight {
+					http.Error(w, "CORS origin not allowed", http.StatusForbidden)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if anyOrigin {
+				h.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				h.Set("Access-Control-Allow-Origin", origin)
+			}
+			if c.AllowCredentials {
+				h.Set("Access-Control-Allow-Credentials", "true")
+			}
+			if !preflight {
+				if exposedHeaders != "" {
+					h.Set("Access-Control-Expose-Headers", exposedHeaders)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			if !containsFold(c.AllowedMethods, r.Header.Get("Access-Control-Request-Method")) {
+				http.Error(w, "CORS method not allowed", http.StatusForbidden)
+				return
+			}
+			for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+				if header = strings.TrimSpace(header); header != "" && !containsFold(c.AllowedHeaders, header) {
+					http.Error(w, fmt.Sprintf("CORS header %s not allowed", header), http.StatusForbidden)
+					return
+				}
+			}
+			// with credentials the browsers take * literally, so the wildcards are answered with the requested values
+			if containsFold(c.AllowedMethods, "*") {
+				h.Set("Access-Control-Allow-Methods", r.Header.Get("Access-Control-Request-Method"))
+			} else {
+				h.Set("Access-Control-Allow-Methods", allowedMethods)
+			}
+			if containsFold(c.AllowedHeaders, "*") {
+				if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+					h.Set("Access-Control-Allow-Headers", requested)
+				}
+			} else {
+				h.Set("Access-Control-Allow-Headers", allowedHeaders)
+			}
+			h.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
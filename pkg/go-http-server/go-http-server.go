@@ -1,27 +1,25 @@
 package go_http_server
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"errors"
 	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const (
 	defaultProtocol        = "http"
+	tlsProtocol            = "https"
 	secondsShutDownTimeout = 5 * time.Second  // maximum number of second to wait before closing server
 	defaultReadTimeout     = 10 * time.Second // max time to read request from the client
 	defaultWriteTimeout    = 10 * time.Second // max time to write response to the client
 	defaultIdleTimeout     = 2 * time.Minute  // max time for connections using TCP Keep-Alive
 	initCallMsg            = "INITIAL CALL TO %s()"
-	formatTraceRequest     = "TRACE: [%s] %s  path:'%s', RemoteAddrIP: [%s], msg: %s, val: %v"
 	formatErrRequest       = "ERROR: Http method not allowed [%s] %s  path:'%s', RemoteAddrIP: [%s]\n"
 	charsetUTF8            = "charset=UTF-8"
 	MIMEAppJSON            = "application/json"
@@ -34,8 +32,24 @@ type HttpServer struct {
 	listenAddr string
 	logger     golog.MyLogger
 	srvMux     *http.ServeMux
-	startTime  time.Time
-	httpServer *http.Server
+	// middlewares wrap the whole mux, see Use
+	middlewares []Middleware
+	// infoMiddlewares protect /info, see ProtectInfo
+	infoMiddlewares []Middleware
+	// registeredRoutes are published in /openapi.json with their description, see Route.Describe
+	registeredRoutes   []*Route
+	openAPIDescription string
+	securitySchemes    map[string]SecurityScheme
+	startTime          time.Time
+	httpServer         *http.Server
+	checks             checkRegistry
+	// tls serves https instead of http when it is set, see EnableTLS
+	tls          *TLSConfig
+	certReloader *certReloader
+	// shutdownConfig, shutdownHooks and draining drive the end of Run
+	shutdownConfig ShutdownConfig
+	shutdownHooks  []registeredHook
+	draining       atomic.Bool
 }
 
 // NewHttpServer creates a new HttpServer instance
@@ -48,10 +62,11 @@ func NewHttpServer(listenAddr string, l golog.MyLogger) *HttpServer {
 	}
 	srvMux := http.NewServeMux()
 	return &HttpServer{
-		listenAddr: listenAddr,
-		logger:     l,
-		srvMux:     srvMux,
-		startTime:  time.Date(1964, time.December, 21, 0, 0, 0, 0, time.UTC),
+		listenAddr:     listenAddr,
+		logger:         l,
+		srvMux:         srvMux,
+		startTime:      time.Date(1964, time.December, 21, 0, 0, 0, 0, time.UTC),
+		shutdownConfig: ShutdownConfig{GracePeriod: defaultShutdownGracePeriod},
 		httpServer: &http.Server{
 			Addr:         listenAddr,
 			Handler:      srvMux,
@@ -63,76 +78,54 @@ func NewHttpServer(listenAddr string, l golog.MyLogger) *HttpServer {
 	}
 }
 
-// waitForShutdownToExit will wait for interrupt signal SIGINT or SIGTERM and gracefully shutdown the server after secondsToWait seconds.
-func waitForShutdownToExit(srv *http.Server, secondsToWait time.Duration) {
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-
-	// Block until a signal is received.
-	// wait for SIGINT (interrupt) 	: ctrl + C keypress, or in a shell : kill -SIGINT processId
-	sig := <-interruptChan
-	srv.ErrorLog.Printf("INFO: 'SIGINT %d interrupt signal received, about to shut down server after max %v seconds...'\n", sig, secondsToWait.Seconds())
-
-	// create a deadline to wait for.
-	ctx, cancel := context.WithTimeout(context.Background(), secondsToWait)
-	defer cancel()
-	// gracefully shuts down the server without interrupting any active connections
-	// as long as the actives connections last less than shutDownTimeout
-	// https://pkg.go.dev/net/http#Server.Shutdown
-	if err := srv.Shutdown(ctx); err != nil {
-		srv.ErrorLog.Printf("💥💥 ERROR: 'Problem doing Shutdown %v'\n", err)
-	}
-	<-ctx.Done()
-	srv.ErrorLog.Println("INFO: 'Server gracefully stopped, will exit'")
-	os.Exit(0)
+func (s *HttpServer) jsonResponse(w http.ResponseWriter, r *http.Request, result interface{}) {
+	s.jsonResponseWithStatus(w, r, http.StatusOK, result)
 }
 
-func (s *HttpServer) jsonResponse(w http.ResponseWriter, result interface{}) {
-	body, err := json.Marshal(result)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		s.logger.Error("'JSON marshal failed. Error: %v'", err)
-		return
-	}
-	var prettyOutput bytes.Buffer
-	err = json.Indent(&prettyOutput, body, "", "  ")
-	if err != nil {
-		s.logger.Error("'JSON Indent failed. Error: %v'", err)
-		return
-	}
-	w.Header().Set(HeaderContentType, MIMEAppJSONCharsetUTF8)
-	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(http.StatusOK)
-	_, err = w.Write(prettyOutput.Bytes())
-	if err != nil {
-		s.logger.Error("'w.Write failed. Error: %v'", err)
-		return
+// jsonResponseWithStatus renders result in the format negotiated with the request, compact JSON by default
+func (s *HttpServer) jsonResponseWithStatus(w http.ResponseWriter, r *http.Request, statusCode int, result interface{}) {
+	if err := Render(w, r, statusCode, result, JSONFormats...); err != nil {
+		s.logger.Error("'Render failed. Error: %v'", err)
 	}
 }
 
 // routes initializes all the default handlers paths of this web server, it is called inside the StartServer constructor
 func (s *HttpServer) routes() {
 	// Adding the default handlers to the server mux
-	s.srvMux.Handle("/readiness", s.getReadinessHandler())
-	s.srvMux.Handle("/health", s.getHealthHandler())
-	s.srvMux.Handle("/info", s.getInfoHandler("/info"))
+	checksResponses := map[string]Response{
+		"200": {Description: "all the components are usable", Content: RenderedContent(checksSchema, JSONFormats...)},
+		"503": {Description: "at least one component failed its check", Content: RenderedContent(checksSchema, JSONFormats...)},
+	}
+	s.Handle("GET /readiness", s.getReadinessHandler()).Describe(Operation{Tags: []string{"server"}, OperationID: "getReadiness",
+		Summary: "Readiness of the server and of its components", Parameters: []Parameter{FormatParameter(JSONFormats...)}, Responses: checksResponses})
+	s.Handle("GET /health", s.getHealthHandler()).Describe(Operation{Tags: []string{"server"}, OperationID: "getHealth",
+		Summary: "Health of the server and of its components", Parameters: []Parameter{FormatParameter(JSONFormats...)}, Responses: checksResponses})
+	info := Operation{Tags: []string{"server"}, OperationID: "getInfo",
+		Summary: "Runtime information about the server, its host and its environment variables",
+		Parameters: []Parameter{
+			{Name: "name", In: "query", Description: "a value echoed in the response", Schema: &Schema{Type: "string"}},
+			FormatParameter(JSONFormats...),
+		},
+		Responses: map[string]Response{"200": {Description: "the runtime information", Content: RenderedContent(&Schema{Type: "object"}, JSONFormats...)}},
+	}
+	if len(s.infoMiddlewares) > 0 {
+		info.Security = s.anySecurity()
+		info.Responses["401"] = Response{Description: "missing or invalid credentials"}
+	}
+	s.Handle("GET /info", s.getInfoHandler("/info"), s.infoMiddlewares...).Describe(info)
+	s.Handle("GET "+OpenAPIPath, s.getOpenAPIHandler()).Describe(Operation{Tags: []string{"server"}, OperationID: "getOpenAPI",
+		Summary: "This OpenAPI document", Parameters: []Parameter{FormatParameter(FormatJSON, FormatPrettyJSON)},
+		Responses: map[string]Response{"200": {Description: "the OpenAPI 3.1 document of the api",
+			Content: map[string]MediaType{MIMEAppJSON: {Schema: &Schema{Type: "object"}}}}}})
+	s.Handle("GET "+OpenAPIViewPath, s.getOpenAPIViewHandler()).Describe(Operation{Tags: []string{"server"}, OperationID: "getOpenAPIView",
+		Summary: "Interactive documentation of this api", Responses: map[string]Response{"200": {Description: "the Swagger UI page",
+			Content: map[string]MediaType{MIMETextHTML: {}}}}})
 }
 
-// StartServer will start the http server in his own goroutine
+// StartServer runs the server until the interrupt signal SIGINT or SIGTERM, see Run
 func (s *HttpServer) StartServer() error {
-	s.routes() // Adding the default handlers to the server mux
-	// Starting the web server in his own goroutine
-	go func() {
-		s.logger.Info("starting http server listening at %s://localhost%s/", defaultProtocol, s.listenAddr)
-		s.startTime = time.Now()
-		err := s.httpServer.ListenAndServe()
-		if err != nil && !errors.Is(http.ErrServerClosed, err) {
-			s.logger.Fatal("💥💥 error could not listen on tcp port %q. error: %s", s.listenAddr, err)
-		}
-	}()
-	s.logger.Debug("Server listening on : %s PID:[%d]", s.httpServer.Addr, os.Getpid())
-
-	// Graceful Shutdown on SIGINT (interrupt)
-	waitForShutdownToExit(s.httpServer, secondsShutDownTimeout)
-	return nil
+	// wait for SIGINT (interrupt) 	: ctrl + C keypress, or in a shell : kill -SIGINT processId
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return s.Run(ctx)
 }
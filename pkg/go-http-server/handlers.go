@@ -34,9 +34,14 @@ func (s *HttpServer) getReadinessHandler() http.HandlerFunc {
 	handlerName := "getReadinessHandler"
 	s.logger.Info(initCallMsg, handlerName)
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.logger.Info(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr)
 		if r.Method == http.MethodGet {
-			w.WriteHeader(http.StatusOK)
+			if s.Draining() {
+				// the pod must leave the endpoints of its services before its listeners close
+				s.jsonResponseWithStatus(w, r, http.StatusServiceUnavailable, ChecksResult{Status: checkStatusFail,
+					Components: map[string]ComponentStatus{"server": {Status: checkStatusFail, Duration: "0s", Error: "the server is shutting down"}}})
+				return
+			}
+			s.checksResponse(w, r)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
@@ -46,9 +51,8 @@ func (s *HttpServer) getHealthHandler() http.HandlerFunc {
 	handlerName := "getHealthHandler"
 	s.logger.Info(initCallMsg, handlerName)
 	return func(w http.ResponseWriter, r *http.Request) {
-		s.logger.Info(formatTraceRequest, handlerName, r.Method, r.URL.Path, r.RemoteAddr)
 		if r.Method == http.MethodGet {
-			w.WriteHeader(http.StatusOK)
+			s.checksResponse(w, r)
 		} else {
 			w.WriteHeader(http.StatusMethodNotAllowed)
 		}
@@ -133,11 +137,12 @@ func (s *HttpServer) getInfoHandler(urlPath string) http.HandlerFunc {
 		Headers:             map[string][]string{},
 	}
 	return func(w http.ResponseWriter, r *http.Request) {
-		remoteIp := r.RemoteAddr // ip address of the original request or the last proxy
+		remoteIp := GetClientIP(r) // ip address of the client, or of the last proxy when it is not trusted
 		requestedUrlPath := r.URL.Path
-		guid := xid.New()
-		s.logger.Info("INFO: 'Request ID: %s'\n", guid.String())
-		s.logger.Info(formatTraceRequest, handlerName, r.Method, requestedUrlPath, remoteIp)
+		requestId := GetRequestId(r.Context())
+		if requestId == "" {
+			requestId = xid.New().String()
+		}
 		switch r.Method {
 		case http.MethodGet:
 			if len(strings.TrimSpace(requestedUrlPath)) == 0 || requestedUrlPath == urlPath {
@@ -155,15 +160,14 @@ func (s *HttpServer) getInfoHandler(urlPath string) http.HandlerFunc {
 					s.logger.Error("💥💥 'GetOsUptime() returned an error : %+#v'", err)
 				}
 				data.UptimeOs = uptimeOS
-				data.RequestId = guid.String()
-				s.jsonResponse(w, data)
+				data.RequestId = requestId
+				s.jsonResponse(w, r, data)
 				/*n, err := fmt.Fprintf(w, getHtmlPage(defaultMessage))
 				if err != nil {
 					s.logger.Printf("💥💥 ERROR: [%s] was unable to Fprintf. path:'%s', from IP: [%s], send_bytes:%d'\n", handlerName, requestedUrlPath, remoteIp, n)
 					http.Error(w, "Internal server error. myDefaultHandler was unable to Fprintf", http.StatusInternalServerError)
 					return
 				}*/
-				s.logger.Info("SUCCESS: [%s] path:'%s', from IP: [%s]\n", handlerName, requestedUrlPath, remoteIp)
 			} else {
 				w.WriteHeader(http.StatusNotFound)
 				n, err := fmt.Fprintf(w, getHtmlMsg(version.APP, defaultNotFound))
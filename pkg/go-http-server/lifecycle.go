@@ -0,0 +1,172 @@
+package go_http_server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const defaultShutdownGracePeriod = secondsShutDownTimeout
+
+// ShutdownHook releases a resource when the server stops, like the database pool, within the deadline of ctx
+type ShutdownHook func(ctx context.Context) error
+
+type registeredHook struct {
+	name string
+	hook ShutdownHook
+}
+
+// ShutdownConfig tells how the server stops when the context given to Run is done
+type ShutdownConfig struct {
+	// GracePeriod is the max time given to the active requests, then again to the shutdown hooks
+	GracePeriod time.Duration
+	// DrainDelay keeps serving with a failing /readiness before closing the listeners,
+	// to let kubernetes remove the pod from the endpoints of its services
+	DrainDelay time.Duration
+}
+
+// GetShutdownConfigFromEnv returns the ShutdownConfig of the env variables SHUTDOWN_GRACE_PERIOD and SHUTDOWN_DRAIN_DELAY,
+// given as durations like 30s
+func GetShutdownConfigFromEnv() (ShutdownConfig, error) {
+	c := ShutdownConfig{GracePeriod: defaultShutdownGracePeriod}
+	for name, d := range map[string]*time.Duration{"SHUTDOWN_GRACE_PERIOD": &c.GracePeriod, "SHUTDOWN_DRAIN_DELAY": &c.DrainDelay} {
+		val, exist := os.LookupEnv(name)
+		if !exist || val == "" {
+			continue
+		}
+		duration, err := time.ParseDuration(val)
+		if err != nil || duration < 0 {
+			return c, fmt.Errorf("env %s should be a duration like 30s, got '%s'", name, val)
+		}
+		*d = duration
+	}
+	if c.GracePeriod == 0 {
+		return c, errors.New("env SHUTDOWN_GRACE_PERIOD should be greater than 0")
+	}
+	return c, nil
+}
+
+// SetShutdownConfig replaces the default grace period of 5 seconds without drain delay, it must be called before Run
+func (s *HttpServer) SetShutdownConfig(c ShutdownConfig) {
+	s.shutdownConfig = c
+}
+
+// OnShutdown registers a hook run when the server stops, after the active requests are done.
+// The hooks run in the reverse order of their registration, like deferred calls.
+func (s *HttpServer) OnShutdown(name string, hook ShutdownHook) {
+	s.shutdownHooks = append(s.shutdownHooks, registeredHook{name: name, hook: hook})
+}
+
+// Draining is true as soon as the server started to stop, /readiness fails from then on
+func (s *HttpServer) Draining() bool {
+	return s.draining.Load()
+}
+
+// Run serves until ctx is done or a listener fails, then it drains the active requests and runs the shutdown hooks.
+// It returns the errors of the listeners, of the shutdown and of the hooks, or nil after a clean stop.
+func (s *HttpServer) Run(ctx context.Context) error {
+	s.routes() // Adding the default handlers to the server mux
+	s.httpServer.Handler = Chain(s.srvMux, s.middlewares...)
+	protocol := defaultProtocol
+	servers := []*http.Server{s.httpServer}
+	if s.tls != nil {
+		protocol = tlsProtocol
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.certReloader.watch(watchCtx, s.tls.ReloadInterval)
+		if s.tls.RedirectAddr != "" {
+			servers = append(servers, &http.Server{
+				Addr:         s.tls.RedirectAddr,
+				Handler:      redirectToHTTPS(s.listenAddr),
+				ErrorLog:     s.httpServer.ErrorLog,
+				ReadTimeout:  defaultReadTimeout,
+				WriteTimeout: defaultWriteTimeout,
+				IdleTimeout:  defaultIdleTimeout,
+			})
+		}
+	}
+	// listening before serving returns at once the errors like a port already in use
+	listeners := make([]net.Listener, 0, len(servers))
+	for _, srv := range servers {
+		ln, err := net.Listen("tcp", srv.Addr)
+		if err != nil {
+			for _, opened := range listeners {
+				_ = opened.Close()
+			}
+			return errors.Join(fmt.Errorf("could not listen on tcp port %q : %w", srv.Addr, err), s.runShutdownHooks())
+		}
+		listeners = append(listeners, ln)
+	}
+	s.startTime = time.Now()
+	serveErrors := make(chan error, len(servers))
+	for i, srv := range servers {
+		go func(srv *http.Server, ln net.Listener, redirect bool) {
+			var err error
+			switch {
+			case redirect:
+				s.logger.Info("starting http server redirecting to https listening at %s://localhost%s/", defaultProtocol, srv.Addr)
+				err = srv.Serve(ln)
+			case s.tls != nil:
+				s.logger.Info("starting %s server listening at %s://localhost%s/", protocol, protocol, srv.Addr)
+				// the certificate comes from the GetCertificate of the TLSConfig, to be reloaded without restarting
+				err = srv.ServeTLS(ln, "", "")
+			default:
+				s.logger.Info("starting %s server listening at %s://localhost%s/", protocol, protocol, srv.Addr)
+				err = srv.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serveErrors <- fmt.Errorf("server listening on %q failed : %w", srv.Addr, err)
+			}
+		}(srv, listeners[i], i > 0)
+	}
+	s.logger.Debug("Server listening on : %s PID:[%d]", s.httpServer.Addr, os.Getpid())
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+		s.logger.Info("shutdown requested, draining the server for max %v", s.shutdownConfig.GracePeriod)
+	case serveErr = <-serveErrors:
+		s.logger.Error("💥💥 %v, shutting down the server", serveErr)
+	}
+	return errors.Join(serveErr, s.shutdown(servers), s.runShutdownHooks())
+}
+
+// shutdown fails /readiness, waits the drain delay then gracefully shuts down the servers without interrupting
+// the active connections as long as they last less than the grace period, https://pkg.go.dev/net/http#Server.Shutdown
+func (s *HttpServer) shutdown(servers []*http.Server) error {
+	s.draining.Store(true)
+	if s.shutdownConfig.DrainDelay > 0 {
+		time.Sleep(s.shutdownConfig.DrainDelay)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownConfig.GracePeriod)
+	defer cancel()
+	var errs []error
+	for _, srv := range servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown of the server listening on %q failed : %w", srv.Addr, err))
+		}
+	}
+	if len(errs) == 0 {
+		s.logger.Info("server gracefully stopped")
+	}
+	return errors.Join(errs...)
+}
+
+// runShutdownHooks runs the hooks from the last registered one, they share a deadline of one grace period
+func (s *HttpServer) runShutdownHooks() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.shutdownConfig.GracePeriod)
+	defer cancel()
+	var errs []error
+	for i := len(s.shutdownHooks) - 1; i >= 0; i-- {
+		h := s.shutdownHooks[i]
+		s.logger.Debug("running the shutdown hook %s", h.name)
+		if err := h.hook(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %s failed : %w", h.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,54 @@
+package go_http_server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/metrics"
+)
+
+const unmatchedRoute = "unmatched" // route label of the requests without a route, so the scanners do not add series
+
+// knownMethods are the methods kept in the labels, the others are counted together
+var knownMethods = map[string]bool{http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true}
+
+var (
+	httpRequests = metrics.Default.NewCounterVec("http_requests_total",
+		"Number of HTTP requests by method, route pattern and status code.", "method", "route", "status")
+	httpDuration = metrics.Default.NewHistogramVec("http_request_duration_seconds",
+		"Latency of the HTTP requests by method, route pattern and status code.", metrics.DefaultBuckets, "method", "route", "status")
+	httpResponseBytes = metrics.Default.NewCounterVec("http_response_size_bytes_total",
+		"Number of bytes written in the HTTP responses by route pattern.", "route")
+)
+
+// Metrics counts the requests and observes their latency by route in the metrics served on /metrics,
+// the route is the pattern of the matched route so the number of series stays bounded
+func Metrics() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			info, r := withRequestInfo(r)
+			rec := &statusRecorder{ResponseWriter: w}
+			defer func() {
+				if rec.status == 0 {
+					rec.status = http.StatusOK
+				}
+				route := info.route
+				if route == "" {
+					route = unmatchedRoute
+				}
+				status := strconv.Itoa(rec.status)
+				method := r.Method
+				if !knownMethods[method] {
+					method = "other"
+				}
+				httpRequests.Inc(method, route, status)
+				httpDuration.Observe(time.Since(start).Seconds(), method, route, status)
+				httpResponseBytes.Add(float64(rec.bytes), route)
+			}()
+			next.ServeHTTP(rec, r)
+		})
+	}
+}
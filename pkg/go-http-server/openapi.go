@@ -0,0 +1,408 @@
+package go_http_server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/version"
+)
+
+const (
+	openAPIVersion   = "3.1.0"
+	OpenAPIPath      = "/openapi.json"
+	OpenAPIViewPath  = "/docs"
+	codeInvalidParam = "InvalidParameterValue"
+	swaggerUIVersion = "5"
+)
+
+// OpenAPI is the document served on /openapi.json, only the parts of OpenAPI 3.1 used to describe the routes
+// of the server, see https://spec.openapis.org/oas/v3.1.0
+type OpenAPI struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       OpenAPIInfo         `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components OpenAPIComponents   `json:"components"`
+}
+
+// OpenAPIInfo is the title and the version of the api
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPIComponents holds the schemas and the security schemes referenced by the operations
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema        `json:"schemas,omitempty"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// PathItem maps the lower case http methods of a path to their operations
+type PathItem map[string]*Operation
+
+// Operation describes what a route expects and returns, its query parameters are checked before the handler runs
+type Operation struct {
+	Tags        []string              `json:"tags,omitempty"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses,omitempty"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a path, query or header parameter of an Operation, a query parameter with an object schema
+// stands for any other query parameter, like the property filters of the OGC items
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Style       string  `json:"style,omitempty"`
+	Explode     *bool   `json:"explode,omitempty"`
+	Schema      *Schema `json:"schema,omitempty"`
+}
+
+// RequestBody describes the accepted bodies by media type
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response describes one status of an Operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType gives the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+// Schema is a JSON schema, the query parameters are checked against its type, enum, bounds and number of items
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Default              any                `json:"default,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinItems             int                `json:"minItems,omitempty"`
+	MaxItems             int                `json:"maxItems,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// SecurityScheme describes how the protected routes authenticate their clients, like a bearer token
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	Description  string `json:"description,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Float64 returns a pointer to v, for the Minimum and Maximum of a Schema
+func Float64(v float64) *float64 {
+	return &v
+}
+
+// exceptionSchema is the body of the errors returned by the validation and the api handlers
+var exceptionSchema = &Schema{
+	Type:     "object",
+	Required: []string{"code", "description"},
+	Properties: map[string]*Schema{
+		"code":        {Type: "string", Description: "like InvalidParameterValue or NotFound"},
+		"description": {Type: "string"},
+	},
+}
+
+// ParameterError is the body of the 400 Bad Request answered to a request with invalid query parameters
+type ParameterError struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// Route is a pattern registered on the server, its Operation is published in /openapi.json
+type Route struct {
+	method    string
+	path      string
+	operation *Operation
+}
+
+// newRoute splits a "[METHOD ][HOST]/PATH" pattern, the wildcards {name...} become {name} and {$} is removed
+func newRoute(pattern string) *Route {
+	method, rest, found := strings.Cut(pattern, " ")
+	if !found {
+		method, rest = "", pattern
+	}
+	rest = strings.TrimLeft(rest, " ")
+	if i := strings.Index(rest, "/"); i > 0 {
+		rest = rest[i:] // the host is not part of an OpenAPI path
+	}
+	path := strings.NewReplacer("...}", "}", "{$}", "").Replace(rest)
+	return &Route{method: strings.ToLower(method), path: path}
+}
+
+// Describe documents the route in /openapi.json and enables the validation of its query parameters,
+// it must be called before Run
+func (rt *Route) Describe(op Operation) *Route {
+	rt.operation = &op
+	return rt
+}
+
+// pathParameters returns the names of the wildcards of the path of rt
+func (rt *Route) pathParameters() []string {
+	var names []string
+	for _, segment := range strings.Split(rt.path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			names = append(names, segment[1:len(segment)-1])
+		}
+	}
+	return names
+}
+
+// validateQuery checks the query parameters against the Operation of rt, the routes without one are not checked
+func (rt *Route) validateQuery(values url.Values) error {
+	op := rt.operation
+	known := map[string]*Parameter{}
+	anyOther := false
+	for i := range op.Parameters {
+		p := &op.Parameters[i]
+		if p.In != "query" {
+			continue
+		}
+		if p.Schema != nil && p.Schema.Type == "object" {
+			anyOther = true
+			continue
+		}
+		known[p.Name] = p
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, ok := known[name]
+		if !ok {
+			if anyOther {
+				continue
+			}
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		if len(values[name]) > 1 {
+			return fmt.Errorf("%s should be given only once", name)
+		}
+		if err := p.Schema.check(name, values.Get(name)); err != nil {
+			return err
+		}
+	}
+	for _, p := range op.Parameters {
+		if p.In == "query" && p.Required && !values.Has(p.Name) {
+			return fmt.Errorf("%s is required", p.Name)
+		}
+	}
+	return nil
+}
+
+// check returns an error when value does not match the schema of the parameter name
+func (sc *Schema) check(name, value string) error {
+	if sc == nil {
+		return nil
+	}
+	switch sc.Type {
+	case "integer", "number":
+		if sc.Type == "integer" {
+			if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+				return fmt.Errorf("%s should be an integer, got %q", name, value)
+			}
+		}
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return fmt.Errorf("%s should be a number, got %q", name, value)
+		}
+		if sc.Minimum != nil && n < *sc.Minimum {
+			return fmt.Errorf("%s should be at least %v, got %q", name, *sc.Minimum, value)
+		}
+		if sc.Maximum != nil && n > *sc.Maximum {
+			return fmt.Errorf("%s should be at most %v, got %q", name, *sc.Maximum, value)
+		}
+	case "boolean":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("%s should be true or false, got %q", name, value)
+		}
+	case "array":
+		// the arrays are given like bbox=1,2,3,4, the form style without explode
+		items := strings.Split(value, ",")
+		if sc.MinItems > 0 && len(items) < sc.MinItems || sc.MaxItems > 0 && len(items) > sc.MaxItems {
+			return fmt.Errorf("%s should have between %d and %d comma separated values, got %q", name, sc.MinItems, sc.MaxItems, value)
+		}
+		for _, item := range items {
+			if err := sc.Items.check(name, strings.TrimSpace(item)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(sc.Enum) > 0 {
+		for _, allowed := range sc.Enum {
+			if value == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%s should be one of %s, got %q", name, strings.Join(sc.Enum, ", "), value)
+	}
+	return nil
+}
+
+// validateQuery answers 400 Bad Request to the requests whose query parameters do not match the Operation of rt
+func (s *HttpServer) validateQuery(rt *Route, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rt.operation == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		values, err := url.ParseQuery(r.URL.RawQuery)
+		if err == nil {
+			err = rt.validateQuery(values)
+		}
+		if err != nil {
+			if errWrite := writeError(w, r, http.StatusBadRequest, codeInvalidParam, err.Error()); errWrite != nil {
+				s.logger.Error("'writeError failed. Error: %v'", errWrite)
+			}
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// SetOpenAPIDescription sets the description of the api at the top of /openapi.json
+func (s *HttpServer) SetOpenAPIDescription(description string) {
+	s.openAPIDescription = description
+}
+
+// AddSecurityScheme declares a way to authenticate, referenced by name in the Security of the operations
+func (s *HttpServer) AddSecurityScheme(name string, scheme SecurityScheme) {
+	if s.securitySchemes == nil {
+		s.securitySchemes = map[string]SecurityScheme{}
+	}
+	s.securitySchemes[name] = scheme
+}
+
+// anySecurity returns a security requirement satisfied by any of the declared security schemes
+func (s *HttpServer) anySecurity() []map[string][]string {
+	var security []map[string][]string
+	names := make([]string, 0, len(s.securitySchemes))
+	for name := range s.securitySchemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		security = append(security, map[string][]string{name: {}})
+	}
+	return security
+}
+
+// OpenAPI returns the document describing all the routes registered on the server
+func (s *HttpServer) OpenAPI() *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI: openAPIVersion,
+		Info:    OpenAPIInfo{Title: version.APP, Version: version.VERSION, Description: s.openAPIDescription},
+		Paths:   map[string]PathItem{},
+		Components: OpenAPIComponents{
+			Schemas:         map[string]*Schema{"Exception": exceptionSchema},
+			SecuritySchemes: s.securitySchemes,
+		},
+	}
+	for _, rt := range s.registeredRoutes {
+		op := Operation{}
+		if rt.operation != nil {
+			op = *rt.operation
+		}
+		op.Parameters = append([]Parameter(nil), op.Parameters...)
+		for _, name := range rt.pathParameters() {
+			if !hasParameter(op.Parameters, name, "path") {
+				op.Parameters = append(op.Parameters, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+			}
+		}
+		for i, p := range op.Parameters {
+			if p.In == "query" && p.Schema != nil && p.Schema.Type == "array" && p.Explode == nil {
+				explode := false
+				op.Parameters[i].Style, op.Parameters[i].Explode = "form", &explode
+			}
+		}
+		responses := map[string]Response{}
+		for status, response := range op.Responses {
+			responses[status] = response
+		}
+		if len(responses) == 0 {
+			responses["200"] = Response{Description: "OK"}
+		}
+		// the described routes refuse the unknown or invalid query parameters
+		if _, found := responses["400"]; rt.operation != nil && !found {
+			responses["400"] = Response{Description: "invalid query parameters",
+				Content: map[string]MediaType{MIMEAppJSON: {Schema: &Schema{Ref: "#/components/schemas/Exception"}}}}
+		}
+		op.Responses = responses
+		method := rt.method
+		if method == "" {
+			method = "get" // a pattern without method matches all of them, it is published as the usual GET
+		}
+		if doc.Paths[rt.path] == nil {
+			doc.Paths[rt.path] = PathItem{}
+		}
+		doc.Paths[rt.path][method] = &op
+	}
+	return doc
+}
+
+func hasParameter(parameters []Parameter, name, in string) bool {
+	for _, p := range parameters {
+		if p.Name == name && p.In == in {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *HttpServer) getOpenAPIHandler() http.HandlerFunc {
+	handlerName := "getOpenAPIHandler"
+	s.logger.Info(initCallMsg, handlerName)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := Render(w, r, http.StatusOK, s.OpenAPI(), FormatJSON, FormatPrettyJSON); err != nil {
+			s.logger.Error("'Render failed. Error: %v'", err)
+		}
+	}
+}
+
+// getOpenAPIViewHandler serves Swagger UI showing the document of /openapi.json
+func (s *HttpServer) getOpenAPIViewHandler() http.HandlerFunc {
+	handlerName := "getOpenAPIViewHandler"
+	s.logger.Info(initCallMsg, handlerName)
+	dist := "https://unpkg.com/swagger-ui-dist@" + swaggerUIVersion
+	page := fmt.Sprintf(`<!DOCTYPE html><html lang="en"><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1">
+<title>%s API</title><link rel="stylesheet" href="%s/swagger-ui.css"/></head>
+<body><div id="swagger-ui"></div><script src="%s/swagger-ui-bundle.js" crossorigin></script>
+<script>window.onload = () => { window.ui = SwaggerUIBundle({url: "%s", dom_id: "#swagger-ui"}); };</script></body></html>`,
+		version.APP, dist, dist, OpenAPIPath)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HeaderContentType, "text/html; "+charsetUTF8)
+		_, err := w.Write([]byte(page))
+		if err != nil {
+			s.logger.Error("'w.Write failed. Error: %v'", err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package go_http_server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HeaderAPIKey          = "X-Api-Key"
+	defaultEvictionPeriod = time.Minute
+)
+
+// RateLimit is a token bucket, a client can send Burst requests at once then Rate requests per second
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// Enabled is false for a zero rate, the requests are then never limited
+func (rl RateLimit) Enabled() bool {
+	return rl.Rate > 0 && rl.Burst > 0
+}
+
+// GetRateLimitFromEnv returns the rate limit of a group of routes given by the env variables
+// RATE_LIMIT_<name>_RPS (requests per second, 0 disables the limit) and RATE_LIMIT_<name>_BURST
+func GetRateLimitFromEnv(name string, defaultLimit RateLimit) (RateLimit, error) {
+	limit := defaultLimit
+	prefix := "RATE_LIMIT_" + strings.ToUpper(name)
+	if val, exist := os.LookupEnv(prefix + "_RPS"); exist {
+		rate, err := strconv.ParseFloat(val, 64)
+		if err != nil || rate < 0 || math.IsInf(rate, 0) {
+			return limit, fmt.Errorf("%s_RPS should be a positive number of requests per second, got %q", prefix, val)
+		}
+		limit.Rate = rate
+	}
+	if val, exist := os.LookupEnv(prefix + "_BURST"); exist {
+		burst, err := strconv.Atoi(val)
+		if err != nil || burst < 0 {
+			return limit, fmt.Errorf("%s_BURST should be a positive number of requests, got %q", prefix, val)
+		}
+		limit.Burst = burst
+	}
+	return limit, nil
+}
+
+// GetAPIKeysFromEnv returns the comma separated keys of the env variable API_KEYS, a client sending one of them
+// in the X-Api-Key header has its own quotas instead of sharing the ones of its ip address
+func GetAPIKeysFromEnv() map[string]bool {
+	keys := map[string]bool{}
+	for _, key := range strings.Split(os.Getenv("API_KEYS"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys[key] = true
+		}
+	}
+	return keys
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter keeps in memory one token bucket by client, the buckets full again are evicted periodically
+type RateLimiter struct {
+	name      string
+	limit     RateLimit
+	apiKeys   map[string]bool
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for the routes of name, the clients are identified by their ip address,
+// or by their key when they send one of apiKeys
+func NewRateLimiter(name string, limit RateLimit, apiKeys map[string]bool) *RateLimiter {
+	return &RateLimiter{name: name, limit: limit, apiKeys: apiKeys, buckets: map[string]*bucket{}, lastSweep: time.Now()}
+}
+
+// clientKey identifies the client of r, an unknown api key is ignored so it cannot be used to get fresh quotas
+func (rl *RateLimiter) clientKey(r *http.Request) string {
+	if key := r.Header.Get(HeaderAPIKey); key != "" && rl.apiKeys[key] {
+		return "key:" + key
+	}
+	return "ip:" + GetClientIP(r)
+}
+
+// fullAfter is the time needed by an empty bucket to be full again
+func (rl *RateLimiter) fullAfter() time.Duration {
+	return time.Duration(float64(rl.limit.Burst) / rl.limit.Rate * float64(time.Second))
+}
+
+// allow takes one token from the bucket of key, it returns the remaining tokens and, when refused,
+// the time to wait for the next token
+func (rl *RateLimiter) allow(key string, now time.Time) (bool, int, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if now.Sub(rl.lastSweep) >= defaultEvictionPeriod {
+		rl.evict(now)
+	}
+	b, found := rl.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(rl.limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(rl.limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rl.limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, 0, time.Duration((1 - b.tokens) / rl.limit.Rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, int(b.tokens), 0
+}
+
+// evict forgets the buckets that are full again, they behave like a new one
+func (rl *RateLimiter) evict(now time.Time) {
+	fullAfter := rl.fullAfter()
+	for key, b := range rl.buckets {
+		if now.Sub(b.last) >= fullAfter {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.lastSweep = now
+}
+
+// Middleware answers 429 Too Many Requests to the clients without tokens left, every response tells the
+// quota with the RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers
+func (rl *RateLimiter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		if !rl.limit.Enabled() {
+			return next
+		}
+		policy := fmt.Sprintf("%d;w=%d;name=%q", rl.limit.Burst, int(math.Ceil(rl.fullAfter().Seconds())), rl.name)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ok, remaining, retryAfter := rl.allow(rl.clientKey(r), time.Now())
+			h := w.Header()
+			h.Set("RateLimit-Policy", policy)
+			h.Set("RateLimit-Limit", strconv.Itoa(rl.limit.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(remaining))
+			// seconds until the bucket has one token again, or is full again when there are some left
+			reset := retryAfter
+			if ok {
+				reset = time.Duration(float64(rl.limit.Burst-remaining) / rl.limit.Rate * float64(time.Second))
+			}
+			h.Set("RateLimit-Reset", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			if !ok {
+				h.Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				s := http.StatusTooManyRequests
+				http.Error(w, fmt.Sprintf("%d %s : the quota of %s requests is exhausted", s, http.StatusText(s), rl.name), s)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package go_http_server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatJSON       = "json"
+	FormatPrettyJSON = "pretty" // indented JSON, only with ?f=pretty
+	FormatGeoJSON    = "geojson"
+	FormatCSV        = "csv"
+	FormatHTML       = "html"
+	MIMEGeoJSON      = "application/geo+json"
+	MIMETextCSV      = "text/csv"
+	MIMETextHTML     = "text/html"
+	minCompressSize  = minGzipSize // smaller bodies are not worth compressing
+)
+
+// JSONFormats are the formats of the JSON results, compact JSON by default
+var JSONFormats = []string{FormatJSON, FormatPrettyJSON, FormatCSV, FormatHTML}
+
+// GeoJSONFormats are the formats of the GeoJSON results, GeoJSON by default
+var GeoJSONFormats = []string{FormatGeoJSON, FormatJSON, FormatPrettyJSON, FormatCSV, FormatHTML}
+
+// formatMediaTypes are the media types of the formats in the Accept header, pretty JSON is only asked with ?f=pretty
+var formatMediaTypes = map[string]string{
+	FormatJSON:    MIMEAppJSON,
+	FormatGeoJSON: MIMEGeoJSON,
+	FormatCSV:     MIMETextCSV,
+	FormatHTML:    MIMETextHTML,
+}
+
+// FormatParameter describes the f query parameter of the routes rendered among formats, for their Operation
+func FormatParameter(formats ...string) Parameter {
+	return Parameter{Name: "f", In: "query", Schema: &Schema{Type: "string", Enum: formats, Default: formats[0]},
+		Description: "the format of the response, instead of the one negotiated with the Accept header"}
+}
+
+// RenderedContent describes the bodies of a route rendered among formats, the JSON ones match schema
+func RenderedContent(schema *Schema, formats ...string) map[string]MediaType {
+	content := map[string]MediaType{}
+	for _, format := range formats {
+		switch mediaType := formatMediaTypes[format]; format {
+		case FormatJSON, FormatGeoJSON:
+			content[mediaType] = MediaType{Schema: schema}
+		case FormatCSV, FormatHTML:
+			content[mediaType] = MediaType{}
+		}
+	}
+	return content
+}
+
+// Table is implemented by the results with a natural tabular form, like the features with their properties,
+// the other results are flattened from their JSON for the csv and html formats
+type Table interface {
+	TableHeader() []string
+	TableRows() [][]string
+}
+
+// NegotiationError is returned by Negotiate when none of the formats is acceptable for the request
+type NegotiationError struct {
+	Status      int // 400 Bad Request for an unknown ?f=, 406 Not Acceptable for the Accept header
+	Description string
+}
+
+func (e *NegotiationError) Error() string {
+	return e.Description
+}
+
+// Negotiate returns the format of the response among formats, the f query parameter wins over the Accept header
+// and the first format is the default one
+func Negotiate(r *http.Request, formats ...string) (string, error) {
+	if f := r.URL.Query().Get("f"); f != "" {
+		for _, format := range formats {
+			if strings.EqualFold(f, format) {
+				return format, nil
+			}
+		}
+		return "", &NegotiationError{Status: http.StatusBadRequest,
+			Description: fmt.Sprintf("f should be one of %s, got %q", strings.Join(formats, ", "), f)}
+	}
+	accept := r.Header.Get("Accept")
+	if strings.TrimSpace(accept) == "" {
+		return formats[0], nil
+	}
+	best, bestQ := "", 0.0
+	for _, format := range formats {
+		mediaType, found := formatMediaTypes[format]
+		if !found {
+			continue
+		}
+		// the earlier formats win the ties, the default one with */*
+		if q := acceptQuality(accept, mediaType); q > bestQ {
+			best, bestQ = format, q
+		}
+	}
+	if best == "" {
+		var offered []string
+		for _, format := range formats {
+			if mediaType, found := formatMediaTypes[format]; found {
+				offered = append(offered, mediaType)
+			}
+		}
+		return "", &NegotiationError{Status: http.StatusNotAcceptable,
+			Description: fmt.Sprintf("the Accept header %q matches none of %s", accept, strings.Join(offered, ", "))}
+	}
+	return best, nil
+}
+
+// acceptQuality returns the q value given by the most specific media range of the Accept header matching mediaType
+func acceptQuality(accept, mediaType string) float64 {
+	mainType, _, _ := strings.Cut(mediaType, "/")
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		accepted, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		s := -1
+		switch {
+		case accepted == mediaType:
+			s = 2
+		case accepted == mainType+"/*":
+			s = 1
+		case accepted == "*/*":
+			s = 0
+		}
+		if s <= specificity {
+			continue
+		}
+		specificity, q = s, 1
+		if v, found := params["q"]; found {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return q
+}
+
+// Render writes data with status in the format negotiated with the request among formats, the first one being
+// the default, the large bodies are compressed with gzip or deflate when the client accepts them
+func Render(w http.ResponseWriter, r *http.Request, status int, data any, formats ...string) error {
+	if len(formats) == 0 {
+		formats = JSONFormats
+	}
+	w.Header().Add("Vary", "Accept")
+	format, err := Negotiate(r, formats...)
+	if err != nil {
+		errStatus, code := http.StatusBadRequest, codeInvalidParam
+		var ne *NegotiationError
+		if errors.As(err, &ne) && ne.Status == http.StatusNotAcceptable {
+			errStatus, code = ne.Status, "NotAcceptable"
+		}
+		return writeError(w, r, errStatus, code, err.Error())
+	}
+	var body []byte
+	contentType := MIMEAppJSONCharsetUTF8
+	switch format {
+	case FormatGeoJSON:
+		contentType = MIMEGeoJSON
+		body, err = marshalJSON(data, false)
+	case FormatPrettyJSON:
+		if formats[0] == FormatGeoJSON {
+			contentType = MIMEGeoJSON
+		}
+		body, err = marshalJSON(data, true)
+	case FormatCSV:
+		contentType = MIMETextCSV + "; " + charsetUTF8
+		body, err = marshalCSV(data)
+	case FormatHTML:
+		contentType = MIMETextHTML + "; " + charsetUTF8
+		body, err = marshalHTML(data, r.URL.Path)
+	default:
+		body, err = marshalJSON(data, false)
+	}
+	if err != nil {
+		return fmt.Errorf("render %s failed : %w", format, err)
+	}
+	return writeBody(w, r, status, contentType, body)
+}
+
+// writeError answers the errors in JSON whatever the negotiated format, like the errors of the api handlers
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, description string) error {
+	body, err := marshalJSON(ParameterError{Code: code, Description: description}, false)
+	if err != nil {
+		return err
+	}
+	return writeBody(w, r, status, MIMEAppJSONCharsetUTF8, body)
+}
+
+// writeBody writes body compressed with the best content coding accepted by the client, when it is large enough
+func writeBody(w http.ResponseWriter, r *http.Request, status int, contentType string, body []byte) error {
+	h := w.Header()
+	h.Set(HeaderContentType, contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	if len(body) >= minCompressSize {
+		h.Add("Vary", "Accept-Encoding")
+		accepted := acceptedCodings(r.Header.Get("Accept-Encoding"))
+		var buf bytes.Buffer
+		var err error
+		switch {
+		case accepted["gzip"]:
+			zw := gzip.NewWriter(&buf)
+			if _, err = zw.Write(body); err == nil {
+				err = zw.Close()
+			}
+			h.Set("Content-Encoding", "gzip")
+		case accepted["deflate"]:
+			// the deflate content coding is the zlib format, not the raw deflate stream
+			zw := zlib.NewWriter(&buf)
+			if _, err = zw.Write(body); err == nil {
+				err = zw.Close()
+			}
+			h.Set("Content-Encoding", "deflate")
+		}
+		if err != nil {
+			return err
+		}
+		if buf.Len() > 0 {
+			body = buf.Bytes()
+		}
+	}
+	h.Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+func marshalJSON(data any, pretty bool) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalCSV(data any) ([]byte, error) {
+	header, rows, err := toTable(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	cw := csv.NewWriter(&buf)
+	if err = cw.Write(header); err != nil {
+		return nil, err
+	}
+	if err = cw.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func marshalHTML(data any, title string) ([]byte, error) {
+	header, rows, err := toTable(data)
+	if err != nil {
+		return nil, err
+	}
+	var sb strings.Builder
+	sb.WriteString(getHtmlHeader(html.EscapeString(title)))
+	fmt.Fprintf(&sb, "\n<body><div class=\"container\"><h3>%s</h3><table class=\"u-full-width\"><thead><tr>", html.EscapeString(title))
+	for _, name := range header {
+		fmt.Fprintf(&sb, "<th>%s</th>", html.EscapeString(name))
+	}
+	sb.WriteString("</tr></thead><tbody>\n")
+	for _, row := range rows {
+		sb.WriteString("<tr>")
+		for _, value := range row {
+			fmt.Fprintf(&sb, "<td>%s</td>", html.EscapeString(value))
+		}
+		sb.WriteString("</tr>\n")
+	}
+	sb.WriteString("</tbody></table></div></body></html>")
+	return []byte(sb.String()), nil
+}
+
+// toTable returns the header and the rows of data, from its Table implementation or its JSON :
+// an array of objects has one row per object, an object one row per member and a scalar a single row
+func toTable(data any) ([]string, [][]string, error) {
+	if t, ok := data.(Table); ok {
+		return t.TableHeader(), t.TableRows(), nil
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err = dec.Decode(&v); err != nil {
+		return nil, nil, err
+	}
+	switch value := v.(type) {
+	case []any:
+		var objects []map[string]any
+		for _, item := range value {
+			object, isObject := item.(map[string]any)
+			if !isObject {
+				objects = nil
+				break
+			}
+			objects = append(objects, object)
+		}
+		if objects == nil {
+			rows := make([][]string, 0, len(value))
+			for _, item := range value {
+				rows = append(rows, []string{cellValue(item)})
+			}
+			return []string{"value"}, rows, nil
+		}
+		seen := map[string]bool{}
+		var header []string
+		for _, object := range objects {
+			for name := range object {
+				if !seen[name] {
+					seen[name] = true
+					header = append(header, name)
+				}
+			}
+		}
+		sort.Strings(header)
+		rows := make([][]string, 0, len(objects))
+		for _, object := range objects {
+			row := make([]string, len(header))
+			for i, name := range header {
+				row[i] = cellValue(object[name])
+			}
+			rows = append(rows, row)
+		}
+		return header, rows, nil
+	case map[string]any:
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		rows := make([][]string, 0, len(names))
+		for _, name := range names {
+			rows = append(rows, []string{name, cellValue(value[name])})
+		}
+		return []string{"name", "value"}, rows, nil
+	default:
+		return []string{"value"}, [][]string{{cellValue(value)}}, nil
+	}
+}
+
+// cellValue formats a JSON value in a cell, the arrays and the objects are written as compact JSON
+func cellValue(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		b, _ := json.Marshal(value)
+		return string(b)
+	}
+}
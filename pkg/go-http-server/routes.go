@@ -0,0 +1,98 @@
+package go_http_server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Middleware wraps a handler with a behavior shared by several routes, like logging or authentication
+type Middleware func(http.Handler) http.Handler
+
+// Chain applies the middlewares to h, the first one is the outermost and sees the request first
+func Chain(h http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// RouteGroup registers routes under a common path prefix with their own middlewares,
+// they run after the middlewares of the server and of the parent groups
+type RouteGroup struct {
+	server      *HttpServer
+	prefix      string
+	middlewares []Middleware
+}
+
+// Use appends middlewares applied to every request received by the server, including the requests
+// without a matching route, it must be called before Run
+func (s *HttpServer) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+// ProtectInfo applies middlewares, like an authentication, to /info which shows all the environment variables,
+// it must be called before Run
+func (s *HttpServer) ProtectInfo(middlewares ...Middleware) {
+	s.infoMiddlewares = append(s.infoMiddlewares, middlewares...)
+}
+
+// Handle registers the handler for the given pattern (Go 1.22 syntax like "GET /api/datasets/{id}") on the server mux,
+// the middlewares only apply to this route. the returned Route can be documented with Describe
+func (s *HttpServer) Handle(pattern string, handler http.Handler, middlewares ...Middleware) *Route {
+	route := newRoute(pattern)
+	s.registeredRoutes = append(s.registeredRoutes, route)
+	// the query is checked after the middlewares, an unauthenticated request gets a 401 before a 400
+	s.srvMux.Handle(pattern, withRoute(pattern, Chain(s.validateQuery(route, handler), middlewares...)))
+	return route
+}
+
+// HandleFunc registers the handler function for the given pattern, like Handle
+func (s *HttpServer) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...Middleware) *Route {
+	return s.Handle(pattern, handler, middlewares...)
+}
+
+// Group returns a RouteGroup whose patterns are prefixed by prefix, like "/api/v1"
+func (s *HttpServer) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	return &RouteGroup{server: s, prefix: strings.TrimSuffix(prefix, "/"), middlewares: middlewares}
+}
+
+// Use appends middlewares to the routes registered on g after this call, and on its sub groups
+func (g *RouteGroup) Use(middlewares ...Middleware) {
+	g.middlewares = append(g.middlewares, middlewares...)
+}
+
+// Group returns a sub group of g, its prefix and middlewares come after the ones of g
+func (g *RouteGroup) Group(prefix string, middlewares ...Middleware) *RouteGroup {
+	all := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	all = append(append(all, g.middlewares...), middlewares...)
+	return &RouteGroup{server: g.server, prefix: g.prefix + strings.TrimSuffix(prefix, "/"), middlewares: all}
+}
+
+// Handle registers the handler for the pattern prefixed by the group, "GET /search" in the group "/api/v1"
+// becomes "GET /api/v1/search" and "GET /" becomes "GET /api/v1/" matching all the paths of the group
+func (g *RouteGroup) Handle(pattern string, handler http.Handler, middlewares ...Middleware) *Route {
+	all := make([]Middleware, 0, len(g.middlewares)+len(middlewares))
+	all = append(append(all, g.middlewares...), middlewares...)
+	return g.server.Handle(prefixPattern(g.prefix, pattern), handler, all...)
+}
+
+// HandleFunc registers the handler function for the pattern prefixed by the group, like Handle
+func (g *RouteGroup) HandleFunc(pattern string, handler http.HandlerFunc, middlewares ...Middleware) *Route {
+	return g.Handle(pattern, handler, middlewares...)
+}
+
+// prefixPattern inserts prefix at the start of the path of a "[METHOD ][HOST]/PATH" pattern
+func prefixPattern(prefix, pattern string) string {
+	method, rest, found := strings.Cut(pattern, " ")
+	if !found {
+		method, rest = "", pattern
+	} else {
+		method += " "
+		rest = strings.TrimLeft(rest, " ")
+	}
+	host, path := "", rest
+	if i := strings.Index(rest, "/"); i >= 0 {
+		host, path = rest[:i], rest[i:]
+	}
+	return method + host + prefix + path
+}
@@ -0,0 +1,214 @@
+package go_http_server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const (
+	indexFile             = "index.html"
+	cacheControlImmutable = "public, max-age=31536000, immutable"
+	cacheControlRevalid   = "no-cache" // the browser must check the ETag before using its copy
+	minGzipSize           = 1024       // smaller files are not worth compressing
+)
+
+// hashedAsset matches the files named with a content hash by vite, like assets/index-BQx3Lk2E.js
+var hashedAsset = regexp.MustCompile(`(^|/)assets/.+-[0-9A-Za-z_-]{8,}\.[0-9A-Za-z]+$`)
+
+// compressibleTypes are the media types worth compressing, the images and fonts are already compressed
+var compressibleTypes = []string{"text/", "application/javascript", "application/json", "application/manifest+json", "image/svg+xml"}
+
+// staticVariant is one representation of a file, identity, gzip or br
+type staticVariant struct {
+	content []byte
+	etag    string
+}
+
+type staticFile struct {
+	name         string
+	contentType  string
+	cacheControl string
+	variants     map[string]*staticVariant // by content coding, "" is the identity
+}
+
+// StaticFiles serves a built single page application from a file system, typically embedded,
+// every file is read at startup with its strong ETag and its gzip and brotli variants
+type StaticFiles struct {
+	files   map[string]*staticFile
+	modTime time.Time
+	log     golog.MyLogger
+}
+
+// NewStaticFiles reads all the files of fsys, the name.gz and name.br files are served as the variants of name,
+// a gzip variant is computed for the compressible files without one
+func NewStaticFiles(fsys fs.FS, l golog.MyLogger) (*StaticFiles, error) {
+	s := &StaticFiles{files: map[string]*staticFile{}, modTime: time.Now(), log: l}
+	compressed := map[string][]byte{}
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		if strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".br") {
+			compressed[name] = content
+			return nil
+		}
+		f := &staticFile{name: name, contentType: mime.TypeByExtension(path.Ext(name)), cacheControl: cacheControlRevalid}
+		if f.contentType == "" {
+			f.contentType = http.DetectContentType(content)
+		}
+		if hashedAsset.MatchString(name) {
+			f.cacheControl = cacheControlImmutable
+		}
+		f.variants = map[string]*staticVariant{"": {content: content, etag: strongETag(content, "")}}
+		s.files[name] = f
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, found := s.files[indexFile]; !found {
+		return nil, fmt.Errorf("static files have no %s", indexFile)
+	}
+	for name, content := range compressed {
+		base, coding := name[:len(name)-3], "gzip"
+		if strings.HasSuffix(name, ".br") {
+			coding = "br"
+		}
+		if f, found := s.files[base]; found {
+			f.variants[coding] = &staticVariant{content: content, etag: strongETag(f.variants[""].content, coding)}
+		} else {
+			// a compressed file without its original is served as is
+			s.files[name] = &staticFile{name: name, contentType: http.DetectContentType(content), cacheControl: cacheControlRevalid,
+				variants: map[string]*staticVariant{"": {content: content, etag: strongETag(content, "")}}}
+		}
+	}
+	for _, f := range s.files {
+		identity := f.variants[""].content
+		if _, found := f.variants["gzip"]; found || len(identity) < minGzipSize || !isCompressible(f.contentType) {
+			continue
+		}
+		var buf bytes.Buffer
+		zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+		if _, err := zw.Write(identity); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		if buf.Len() < len(identity) {
+			f.variants["gzip"] = &staticVariant{content: buf.Bytes(), etag: strongETag(identity, "gzip")}
+		}
+	}
+	l.Info("static files : %d files ready to be served", len(s.files))
+	return s, nil
+}
+
+// strongETag derives the ETag of a representation from the content of the file, each content coding has its own
+func strongETag(content []byte, coding string) string {
+	sum := sha256.Sum256(content)
+	etag := hex.EncodeToString(sum[:16])
+	if coding != "" {
+		etag += "-" + coding
+	}
+	return `"` + etag + `"`
+}
+
+func isCompressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptedCodings returns the content codings of the Accept-Encoding header that are not refused with q=0
+func acceptedCodings(header string) map[string]bool {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if q, hasQ := strings.CutPrefix(strings.TrimSpace(params), "q="); hasQ {
+			if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+				continue
+			}
+		}
+		accepted[strings.ToLower(strings.TrimSpace(coding))] = true
+	}
+	return accepted
+}
+
+// lookup returns the file of the url path, index.html for a directory and, when the browser navigates to
+// a route of the application, the history fallback index.html
+func (s *StaticFiles) lookup(r *http.Request) *staticFile {
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = indexFile
+	}
+	if f, found := s.files[name]; found {
+		return f
+	}
+	if f, found := s.files[path.Join(name, indexFile)]; found {
+		return f
+	}
+	// a missing asset stays a 404, only the page navigations get the application
+	if path.Ext(name) == "" && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return s.files[indexFile]
+	}
+	return nil
+}
+
+// ServeHTTP serves the files with their best variant for the Accept-Encoding of the request,
+// http.ServeContent answers the conditional requests on the ETag and the ranges
+func (s *StaticFiles) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, httpErrMethodNotAllow, http.StatusMethodNotAllowed)
+		return
+	}
+	f := s.lookup(r)
+	if f == nil {
+		w.Header().Set(HeaderContentType, "text/html; "+charsetUTF8)
+		w.WriteHeader(http.StatusNotFound)
+		if _, err := fmt.Fprint(w, getHtmlMsg("404", defaultNotFound)); err != nil {
+			s.log.Error("StaticFiles failed to write not found : %v", err)
+		}
+		return
+	}
+	variant, coding := f.variants[""], ""
+	if len(f.variants) > 1 {
+		w.Header().Add("Vary", "Accept-Encoding")
+		accepted := acceptedCodings(r.Header.Get("Accept-Encoding"))
+		for _, c := range []string{"br", "gzip"} {
+			if v, found := f.variants[c]; found && accepted[c] {
+				variant, coding = v, c
+				break
+			}
+		}
+	}
+	h := w.Header()
+	h.Set(HeaderContentType, f.contentType)
+	h.Set("Cache-Control", f.cacheControl)
+	h.Set("ETag", variant.etag)
+	h.Set("X-Content-Type-Options", "nosniff")
+	if coding != "" {
+		h.Set("Content-Encoding", coding)
+	}
+	http.ServeContent(w, r, f.name, s.modTime, bytes.NewReader(variant.content))
+}
@@ -0,0 +1,193 @@
+package go_http_server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+)
+
+const defaultTLSReloadInterval = 10 * time.Second // how often the certificate files are checked for a change
+
+// tlsVersions are the accepted values of TLS_MIN_VERSION
+var tlsVersions = map[string]uint16{"1.2": tls.VersionTLS12, "1.3": tls.VersionTLS13}
+
+// TLSConfig enables HTTPS with a certificate and a key read from files, like the ones mounted from a secret
+// managed by cert-manager, they are reloaded when the files change
+type TLSConfig struct {
+	CertFile       string
+	KeyFile        string
+	MinVersion     uint16
+	ReloadInterval time.Duration
+	// RedirectAddr is the address of an optional plain http listener redirecting to https, like ":8080"
+	RedirectAddr string
+}
+
+// GetTLSConfigFromEnv returns the TLSConfig of the env variables TLS_CERT_FILE, TLS_KEY_FILE, TLS_MIN_VERSION (1.2 or 1.3),
+// TLS_RELOAD_INTERVAL and HTTP_REDIRECT_PORT, or nil without certificate to serve plain http
+func GetTLSConfigFromEnv() (*TLSConfig, error) {
+	certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE")
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE should be given together")
+	}
+	c := &TLSConfig{CertFile: certFile, KeyFile: keyFile, MinVersion: tls.VersionTLS12, ReloadInterval: defaultTLSReloadInterval}
+	if val, exist := os.LookupEnv("TLS_MIN_VERSION"); exist {
+		version, found := tlsVersions[strings.TrimSpace(val)]
+		if !found {
+			return nil, fmt.Errorf("env TLS_MIN_VERSION should be 1.2 or 1.3, got '%s'", val)
+		}
+		c.MinVersion = version
+	}
+	if val, exist := os.LookupEnv("TLS_RELOAD_INTERVAL"); exist {
+		interval, err := time.ParseDuration(val)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("env TLS_RELOAD_INTERVAL should be a positive duration like 30s, got '%s'", val)
+		}
+		c.ReloadInterval = interval
+	}
+	if val, exist := os.LookupEnv("HTTP_REDIRECT_PORT"); exist && val != "" {
+		port, err := strconv.Atoi(val)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, fmt.Errorf("env HTTP_REDIRECT_PORT should be a tcp port, got '%s'", val)
+		}
+		c.RedirectAddr = fmt.Sprintf(":%d", port)
+	}
+	return c, nil
+}
+
+// certReloader gives the last valid certificate to the new tls handshakes, the established connections
+// keep the one they negotiated
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   golog.MyLogger
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	stamp    string // modification times and sizes of the files of cert
+}
+
+func newCertReloader(certFile, keyFile string, l golog.MyLogger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: l}
+	if _, err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// filesStamp changes when one of the files is replaced, os.Stat follows the symbolic links that kubernetes
+// swaps when it updates a mounted secret
+func (cr *certReloader) filesStamp() (string, error) {
+	var sb strings.Builder
+	for _, name := range []string{cr.certFile, cr.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&sb, "%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return sb.String(), nil
+}
+
+// reload loads the certificate when its files changed, it returns true when a new one is used
+func (cr *certReloader) reload() (bool, error) {
+	stamp, err := cr.filesStamp()
+	if err != nil {
+		return false, err
+	}
+	cr.mu.RLock()
+	unchanged := stamp == cr.stamp
+	cr.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	// the certificate and the key are not written at the same time, an invalid pair is retried at the next check
+	cert, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("loading the tls certificate %s failed : %w", cr.certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return false, fmt.Errorf("parsing the tls certificate %s failed : %w", cr.certFile, err)
+		}
+	}
+	cr.mu.Lock()
+	cr.cert, cr.stamp = &cert, stamp
+	cr.mu.Unlock()
+	return true, nil
+}
+
+// GetCertificate is the tls.Config callback giving the current certificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// watch checks the files every interval until ctx is done, a failed reload keeps the previous certificate
+func (cr *certReloader) watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reloaded, err := cr.reload()
+			if err != nil {
+				cr.logger.Error("💥💥 tls certificate not reloaded, keeping the previous one : %v", err)
+				continue
+			}
+			if reloaded {
+				cr.mu.RLock()
+				leaf := cr.cert.Leaf
+				cr.mu.RUnlock()
+				cr.logger.Info("tls certificate reloaded from %s for %v, valid until %s", cr.certFile, leaf.DNSNames, leaf.NotAfter.Format(time.RFC3339))
+			}
+		}
+	}
+}
+
+// EnableTLS serves https with the certificate of c instead of plain http, it must be called before Run
+// and fails when the certificate cannot be loaded
+func (s *HttpServer) EnableTLS(c *TLSConfig) error {
+	cr, err := newCertReloader(c.CertFile, c.KeyFile, s.logger)
+	if err != nil {
+		return err
+	}
+	s.tls, s.certReloader = c, cr
+	s.httpServer.TLSConfig = &tls.Config{MinVersion: c.MinVersion, GetCertificate: cr.GetCertificate}
+	return nil
+}
+
+// redirectToHTTPS answers the plain http requests with a redirect to the same url on the https listener
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host = net.JoinHostPort(host, httpsPort)
+		}
+		// 308 keeps the method and the body of the other requests, the browsers follow 301 for the pages
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
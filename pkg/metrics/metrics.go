@@ -0,0 +1,312 @@
+// Package metrics exposes counters, histograms and scrape time collectors in the Prometheus text format,
+// see https://prometheus.io/docs/instrumenting/exposition_formats/
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds in seconds of the latency histograms, from 5 ms to 10 s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry served on /metrics, the packages register their metrics in it at init time
+var Default = NewRegistry()
+
+// metric is a family of series written by the registry at each scrape
+type metric interface {
+	write(w *Writer)
+}
+
+// Registry holds the metrics and the collectors of a program
+type Registry struct {
+	mu         sync.RWMutex
+	names      map[string]bool
+	metrics    []metric
+	collectors []func(w *Writer)
+}
+
+// NewRegistry returns an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{names: map[string]bool{}}
+}
+
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s is already registered", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// AddCollector registers a function called at each scrape to write the metrics read from another component,
+// like the stats of a connection pool
+func (r *Registry) AddCollector(collect func(w *Writer)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collectors = append(r.collectors, collect)
+}
+
+// Handler serves the metrics of r in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		mw := &Writer{}
+		r.mu.RLock()
+		for _, m := range r.metrics {
+			m.write(mw)
+		}
+		for _, collect := range r.collectors {
+			collect(mw)
+		}
+		r.mu.RUnlock()
+		w.Header().Set("Content-Type", ContentType)
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write(mw.buf.Bytes())
+	})
+}
+
+// Writer writes the series of the metrics in the text format
+type Writer struct {
+	buf bytes.Buffer
+}
+
+// header writes the HELP and TYPE lines of a family
+func (w *Writer) header(name, help, kind string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help), name, kind)
+}
+
+func (w *Writer) sample(name string, labels []string, values []string, value float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, `%s="%s"`, label, escapeLabel(values[i]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(formatValue(value))
+	w.buf.WriteByte('\n')
+}
+
+// Gauge writes a gauge family of one series, labels alternates the names and the values of the labels
+func (w *Writer) Gauge(name, help string, value float64, labels ...string) {
+	w.header(name, help, "gauge")
+	w.labeledSample(name, value, labels)
+}
+
+// Counter writes a counter family of one series, labels alternates the names and the values of the labels
+func (w *Writer) Counter(name, help string, value float64, labels ...string) {
+	w.header(name, help, "counter")
+	w.labeledSample(name, value, labels)
+}
+
+// Series is one labeled value of a family written by GaugeSeries or CounterSeries
+type Series struct {
+	Labels []string // alternates the names and the values of the labels
+	Value  float64
+}
+
+// GaugeSeries writes a gauge family of several series
+func (w *Writer) GaugeSeries(name, help string, series []Series) {
+	w.header(name, help, "gauge")
+	for _, s := range series {
+		w.labeledSample(name, s.Value, s.Labels)
+	}
+}
+
+// CounterSeries writes a counter family of several series
+func (w *Writer) CounterSeries(name, help string, series []Series) {
+	w.header(name, help, "counter")
+	for _, s := range series {
+		w.labeledSample(name, s.Value, s.Labels)
+	}
+}
+
+func (w *Writer) labeledSample(name string, value float64, labels []string) {
+	names := make([]string, 0, len(labels)/2)
+	values := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		names = append(names, labels[i])
+		values = append(values, labels[i+1])
+	}
+	w.sample(name, names, values, value)
+}
+
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// vec holds the series of a family by their label values
+type vec[T any] struct {
+	name   string
+	help   string
+	labels []string
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+	create func() *T
+}
+
+func (v *vec[T]) with(values []string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	v.mu.RLock()
+	s, found := v.series[key]
+	v.mu.RUnlock()
+	if found {
+		return s
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, found = v.series[key]; !found {
+		s = v.create()
+		v.series[key] = s
+		v.values[key] = append([]string(nil), values...)
+	}
+	return s
+}
+
+// sortedKeys returns the keys of the series in a stable order, the scrapes are easier to compare
+func (v *vec[T]) sortedKeys() []string {
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// CounterVec is a counter family partitioned by labels
+type CounterVec struct {
+	vec[atomicFloat]
+}
+
+// NewCounterVec registers in r a counter family with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec[atomicFloat]{name: name, help: help, labels: labels,
+		series: map[string]*atomicFloat{}, values: map[string][]string{}, create: func() *atomicFloat { return &atomicFloat{} }}}
+	r.register(name, c)
+	return c
+}
+
+// Add increments by delta the counter of the label values
+func (c *CounterVec) Add(delta float64, values ...string) {
+	c.with(values).add(delta)
+}
+
+// Inc increments by one the counter of the label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *CounterVec) write(w *Writer) {
+	w.header(c.name, c.help, "counter")
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, key := range c.sortedKeys() {
+		w.sample(c.name, c.labels, c.values[key], c.series[key].load())
+	}
+}
+
+// HistogramVec is a histogram family partitioned by labels
+type HistogramVec struct {
+	vec[histogram]
+	buckets []float64
+}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64 // by bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers in r a histogram family with the given upper bounds and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	h := &HistogramVec{buckets: buckets}
+	h.vec = vec[histogram]{name: name, help: help, labels: labels,
+		series: map[string]*histogram{}, values: map[string][]string{},
+		create: func() *histogram { return &histogram{counts: make([]uint64, len(buckets))} }}
+	r.register(name, h)
+	return h
+}
+
+// Observe adds value to the histogram of the label values
+func (h *HistogramVec) Observe(value float64, values ...string) {
+	s := h.with(values)
+	i := sort.SearchFloat64s(h.buckets, value) // first bucket with an upper bound >= value
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) write(w *Writer) {
+	w.header(h.name, h.help, "histogram")
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	labels := append(append([]string(nil), h.labels...), "le")
+	for _, key := range h.sortedKeys() {
+		s, values := h.series[key], h.values[key]
+		s.mu.Lock()
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			w.sample(h.name+"_bucket", labels, append(append([]string(nil), values...), formatValue(bound)), float64(cumulative))
+		}
+		w.sample(h.name+"_bucket", labels, append(append([]string(nil), values...), "+Inf"), float64(s.count))
+		w.sample(h.name+"_sum", h.labels, values, s.sum)
+		w.sample(h.name+"_count", h.labels, values, float64(s.count))
+		s.mu.Unlock()
+	}
+}
+
+// atomicFloat is a float64 updated without lock
+type atomicFloat struct {
+	bits atomic.Uint64
+}
+
+func (f *atomicFloat) add(delta float64) {
+	for {
+		old := f.bits.Load()
+		if f.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (f *atomicFloat) load() float64 {
+	return math.Float64frombits(f.bits.Load())
+}
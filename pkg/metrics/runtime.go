@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+var startTime = time.Now()
+
+func init() {
+	Default.AddCollector(CollectRuntime)
+}
+
+// CollectRuntime writes the usual go_ and process_ metrics of the Go runtime
+func CollectRuntime(w *Writer) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	w.Gauge("go_info", "Information about the Go environment.", 1, "version", runtime.Version())
+	w.Gauge("go_goroutines", "Number of goroutines that currently exist.", float64(runtime.NumGoroutine()))
+	w.Gauge("go_threads", "Number of OS threads created.", float64(threadCount()))
+	w.Gauge("go_memstats_alloc_bytes", "Number of bytes allocated and still in use.", float64(m.Alloc))
+	w.Counter("go_memstats_alloc_bytes_total", "Total number of bytes allocated, even if freed.", float64(m.TotalAlloc))
+	w.Gauge("go_memstats_sys_bytes", "Number of bytes obtained from system.", float64(m.Sys))
+	w.Gauge("go_memstats_heap_alloc_bytes", "Number of heap bytes allocated and still in use.", float64(m.HeapAlloc))
+	w.Gauge("go_memstats_heap_inuse_bytes", "Number of heap bytes that are in use.", float64(m.HeapInuse))
+	w.Gauge("go_memstats_heap_objects", "Number of allocated objects.", float64(m.HeapObjects))
+	w.Counter("go_memstats_mallocs_total", "Total number of mallocs.", float64(m.Mallocs))
+	w.Counter("go_memstats_frees_total", "Total number of frees.", float64(m.Frees))
+	w.Gauge("go_memstats_next_gc_bytes", "Number of heap bytes when next garbage collection will take place.", float64(m.NextGC))
+	w.Counter("go_gc_cycles_total", "Number of completed GC cycles.", float64(m.NumGC))
+	w.Counter("go_gc_pause_seconds_total", "Total time the world was stopped by the garbage collector.", float64(m.PauseTotalNs)/1e9)
+	w.Gauge("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", float64(startTime.UnixNano())/1e9)
+	w.Gauge("process_uptime_seconds", "Time since the start of the process in seconds.", time.Since(startTime).Seconds())
+}
+
+func threadCount() int {
+	n, _ := runtime.ThreadCreateProfile(nil)
+	return n
+}
@@ -0,0 +1,106 @@
+package tiles
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+const (
+	defaultCacheMaxMB = 64
+	// cacheEntryOverhead is added to the size of each tile, so the many empty tiles also count in the budget
+	cacheEntryOverhead = 128
+)
+
+// CacheStats gives the usage of the tile cache since the start of the program
+type CacheStats struct {
+	Entries  int    `json:"entries"`
+	Bytes    int64  `json:"bytes"`
+	MaxBytes int64  `json:"max_bytes"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+}
+
+type cacheEntry struct {
+	key  string
+	tile []byte
+}
+
+// Cache is an in-memory least recently used cache of tiles limited in bytes, safe for concurrent use
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	ll       *list.List
+	items    map[string]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+// NewCache returns a cache keeping at most maxBytes of tiles, 0 disables the cache
+func NewCache(maxBytes int64) *Cache {
+	return &Cache{maxBytes: maxBytes, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+// GetCacheMaxBytesFromEnv returns the size of the tile cache given in megabytes by the env variable TILES_CACHE_MAX_MB
+func GetCacheMaxBytesFromEnv() (int64, error) {
+	maxMB := defaultCacheMaxMB
+	if val, exist := os.LookupEnv("TILES_CACHE_MAX_MB"); exist {
+		var err error
+		maxMB, err = strconv.Atoi(val)
+		if err != nil || maxMB < 0 {
+			return 0, fmt.Errorf("TILES_CACHE_MAX_MB should be a positive number of megabytes, got %q", val)
+		}
+	}
+	return int64(maxMB) * 1024 * 1024, nil
+}
+
+// Get returns the cached tile for key, an empty tile is a valid entry
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		c.hits++
+		return el.Value.(*cacheEntry).tile, true
+	}
+	c.misses++
+	return nil, false
+}
+
+// Add stores tile for key, evicting the least recently used tiles when the cache is full
+func (c *Cache) Add(key string, tile []byte) {
+	entrySize := int64(len(tile) + len(key) + cacheEntryOverhead)
+	if entrySize > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		entry := el.Value.(*cacheEntry)
+		c.size += int64(len(tile) - len(entry.tile))
+		entry.tile = tile
+	} else {
+		c.items[key] = c.ll.PushFront(&cacheEntry{key: key, tile: tile})
+		c.size += entrySize
+	}
+	for c.size > c.maxBytes {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		entry := c.ll.Remove(oldest).(*cacheEntry)
+		delete(c.items, entry.key)
+		c.size -= int64(len(entry.tile) + len(entry.key) + cacheEntryOverhead)
+	}
+}
+
+// Stats returns the current usage of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Entries: c.ll.Len(), Bytes: c.size, MaxBytes: c.maxBytes, Hits: c.hits, Misses: c.misses}
+}
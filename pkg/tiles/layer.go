@@ -0,0 +1,248 @@
+package tiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/dataset"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geopackage"
+)
+
+// AttributeLevel gives columns that are only added to the tiles when the map is zoomed enough
+type AttributeLevel struct {
+	// MaxResolution is the largest ground resolution in meters per pixel where the columns are included, 0 means always
+	MaxResolution float64  `json:"max_resolution"`
+	Columns       []string `json:"columns"`
+}
+
+// Layer is a geometry table served as vector tiles
+type Layer struct {
+	Name           string `json:"name"`
+	Table          string `json:"table"`
+	GeometryColumn string `json:"geometry_column"`
+	SrsID          int    `json:"srs_id"`
+	// MaxResolution is the largest ground resolution in meters per pixel where the layer has features, 0 means always
+	MaxResolution float64          `json:"max_resolution"`
+	Attributes    []AttributeLevel `json:"attributes"`
+}
+
+// DefaultLayers are the layers served by goCloudGeoSearchServer, the addresses only appear at the street level
+var DefaultLayers = []Layer{
+	{
+		Name: "adresses", Table: "adresses", GeometryColumn: "geom", SrsID: geom.SridLv95, MaxResolution: 5,
+		Attributes: []AttributeLevel{
+			{MaxResolution: 5, Columns: []string{"no_entree"}},
+			{MaxResolution: 1, Columns: []string{"voie_txt", "codepost_4", "nom_com_of"}},
+		},
+	},
+	{
+		Name: "communes", Table: "communes", GeometryColumn: "geom", SrsID: geom.SridLv95,
+		Attributes: []AttributeLevel{
+			{Columns: []string{"name"}},
+		},
+	},
+}
+
+// preparedLayer is a layer checked against the database, with the named queries for each attribute level
+type preparedLayer struct {
+	Layer
+	// levels are sorted from the largest resolution, the columns at a resolution are a prefix of the levels
+	levels []AttributeLevel
+	// queries[tms id][number of active levels] is the name of the query, on sqlite the same query serves all tms
+	queries  map[string][]string
+	hasRtree bool
+}
+
+// activeLevels returns how many attribute levels are included at the ground resolution res
+func (l *preparedLayer) activeLevels(res float64) int {
+	n := 0
+	for _, level := range l.levels {
+		if level.MaxResolution == 0 || res <= level.MaxResolution {
+			n++
+		}
+	}
+	return n
+}
+
+// columns returns the columns of the first n levels
+func (l *preparedLayer) columns(n int) []string {
+	var res []string
+	for _, level := range l.levels[:n] {
+		res = append(res, level.Columns...)
+	}
+	return res
+}
+
+// groundResolution returns the size of a pixel in meters at zoom z around the tile envelope
+func groundResolution(tms *TileMatrixSet, z int, env geom.Envelope) float64 {
+	res := tms.Resolutions[z]
+	if tms.SrsID == geom.SridWebMercator {
+		lat := geom.WebMercatorToWgs84(geom.Coord{Y: (env.MinY + env.MaxY) / 2}).Y
+		res *= math.Cos(lat * math.Pi / 180)
+	}
+	return res
+}
+
+func quoteLiteral(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// postgresTileSql returns the query building the whole tile with ST_AsMVT, the parameters are the tile envelope
+// min_x, min_y, max_x, max_y in the tms srs and the size of the buffer in the same units
+func postgresTileSql(l *preparedLayer, tms *TileMatrixSet, columns []string) string {
+	var cols strings.Builder
+	for _, c := range columns {
+		cols.WriteString(", t.")
+		cols.WriteString(dataset.QuoteIdentifier(c))
+	}
+	g := "t." + dataset.QuoteIdentifier(l.GeometryColumn)
+	return fmt.Sprintf(`WITH bounds AS (SELECT ST_MakeEnvelope($1::float8, $2::float8, $3::float8, $4::float8, %[1]d) AS geom)
+SELECT ST_AsMVT(tile, %[2]s, %[3]d, 'mvt_geom') FROM (
+  SELECT ST_AsMVTGeom(ST_Transform(%[4]s, %[1]d), bounds.geom, %[3]d, %[5]d, true) AS mvt_geom%[6]s
+  FROM %[7]s t, bounds
+  WHERE %[4]s && ST_Transform(ST_Expand(bounds.geom, $5::float8), %[8]d)
+) AS tile WHERE tile.mvt_geom IS NOT NULL;`,
+		tms.SrsID, quoteLiteral(l.Name), DefaultExtent, g, DefaultBuffer, cols.String(),
+		dataset.QuoteIdentifier(l.Table), l.SrsID)
+}
+
+// sqliteTileSql returns the query selecting the geometries and columns of the features, using the GeoPackage rtree
+// when it exists, the parameters are then the envelope min_x, max_x, min_y, max_y in the layer srs
+func sqliteTileSql(l *preparedLayer, columns []string) string {
+	sqlText := "SELECT t." + dataset.QuoteIdentifier(l.GeometryColumn)
+	for _, c := range columns {
+		sqlText += ", t." + dataset.QuoteIdentifier(c)
+	}
+	sqlText += " FROM " + dataset.QuoteIdentifier(l.Table) + " t"
+	if l.hasRtree {
+		sqlText += fmt.Sprintf(" WHERE t.rowid IN (SELECT id FROM %s WHERE maxx >= ? AND minx <= ? AND maxy >= ? AND miny <= ?)",
+			dataset.QuoteIdentifier(geopackage.RtreeName(l.Table, l.GeometryColumn)))
+	}
+	return sqlText + ";"
+}
+
+// prepareLayer checks that the table and columns of l exist in db and registers its named queries
+func prepareLayer(ctx context.Context, db database.DB, l Layer) (*preparedLayer, error) {
+	schema := ""
+	if db.Dialect() == database.DialectPostgres {
+		schema = "public"
+	}
+	d := &dataset.Dataset{Schema: schema, TableName: l.Table}
+	if err := dataset.DescribeColumns(ctx, db, d); err != nil {
+		return nil, err
+	}
+	if len(d.Columns) == 0 {
+		return nil, fmt.Errorf("%w: %s", dataset.ErrDatasetNotFound, l.Table)
+	}
+	exist := map[string]bool{}
+	for _, c := range d.Columns {
+		exist[c.Name] = true
+	}
+	if !exist[l.GeometryColumn] {
+		return nil, fmt.Errorf("table %s has no geometry column %s", l.Table, l.GeometryColumn)
+	}
+	for _, level := range l.Attributes {
+		for _, c := range level.Columns {
+			if !exist[c] {
+				return nil, fmt.Errorf("table %s has no column %s", l.Table, c)
+			}
+		}
+	}
+	if l.SrsID == 0 {
+		l.SrsID = geom.SridLv95
+	}
+
+	p := &preparedLayer{Layer: l, levels: append([]AttributeLevel(nil), l.Attributes...), queries: map[string][]string{}}
+	sort.SliceStable(p.levels, func(i, j int) bool {
+		a, b := p.levels[i].MaxResolution, p.levels[j].MaxResolution
+		return (a == 0 && b != 0) || (a != 0 && b != 0 && a > b)
+	})
+	if db.Dialect() == database.DialectSqlite {
+		p.hasRtree = db.DoesTableExist("", geopackage.RtreeName(l.Table, l.GeometryColumn))
+	}
+	for id, tms := range TileMatrixSets {
+		for n := 0; n <= len(p.levels); n++ {
+			name := fmt.Sprintf("tiles_%s_%s_%d", l.Name, id, n)
+			var sqlText string
+			if db.Dialect() == database.DialectPostgres {
+				sqlText = postgresTileSql(p, tms, p.columns(n))
+			} else {
+				sqlText = sqliteTileSql(p, p.columns(n))
+			}
+			if err := database.RegisterNamedQuery(name, map[database.Dialect]string{db.Dialect(): sqlText}); err != nil {
+				return nil, fmt.Errorf("%w: %w", errLayerQuery, err)
+			}
+			p.queries[id] = append(p.queries[id], name)
+		}
+	}
+	return p, nil
+}
+
+// postgresTile returns the tile built by PostGIS
+func (l *preparedLayer) postgresTile(ctx context.Context, db database.DB, query string, env geom.Envelope) ([]byte, error) {
+	buffer := (env.MaxX - env.MinX) * DefaultBuffer / DefaultExtent
+	var tile []byte
+	err := database.NamedQueryRow(ctx, db, query, []interface{}{&tile}, env.MinX, env.MinY, env.MaxX, env.MaxY, buffer)
+	if err != nil && !errors.Is(err, database.ErrNoRecordFound) {
+		return nil, err
+	}
+	return tile, nil
+}
+
+// sqliteTile reads the features of the GeoPackage in the tile and encodes them in go
+func (l *preparedLayer) sqliteTile(ctx context.Context, db database.DB, query string, columns []string, tms *TileMatrixSet, env geom.Envelope) ([]byte, error) {
+	toTile, err := geom.GetTransform(l.SrsID, tms.SrsID)
+	if err != nil {
+		return nil, err
+	}
+	toLayer, err := geom.GetTransform(tms.SrsID, l.SrsID)
+	if err != nil {
+		return nil, err
+	}
+	buffer := (env.MaxX - env.MinX) * DefaultBuffer / DefaultExtent
+	buffered := geom.Envelope{MinX: env.MinX - buffer, MinY: env.MinY - buffer, MaxX: env.MaxX + buffer, MaxY: env.MaxY + buffer}
+	search := geom.TransformEnvelope(buffered, toLayer)
+
+	var args []interface{}
+	if l.hasRtree {
+		args = []interface{}{search.MinX, search.MaxX, search.MinY, search.MaxY}
+	}
+	rows, err := db.QueryNamed(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var features []Feature
+	for rows.Next() {
+		var g geom.Value
+		values := make([]interface{}, len(columns))
+		dest := []interface{}{&g}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if g.Geometry == nil || !geom.EnvelopeOf(g.Geometry).Intersects(search) {
+			continue
+		}
+		f := Feature{Geometry: geom.Transform(g.Geometry, toTile), Properties: map[string]interface{}{}}
+		for i, c := range columns {
+			if b, isBytes := values[i].([]byte); isBytes {
+				values[i] = string(b)
+			}
+			f.Properties[c] = values[i]
+		}
+		features = append(features, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return EncodeLayer(l.Name, features, env, DefaultExtent, DefaultBuffer), nil
+}
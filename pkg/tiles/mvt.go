@@ -0,0 +1,459 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+// encoding of the Mapbox Vector Tile 2.1 protobuf messages, see https://github.com/mapbox/vector-tile-spec
+
+const (
+	DefaultExtent = 4096 // number of units along a side of a tile
+	DefaultBuffer = 64   // units drawn outside the tile so the lines and polygons borders join between tiles
+
+	mvtVersion = 2
+
+	wireVarint = 0
+	wire64bit  = 1
+	wireBytes  = 2
+	wire32bit  = 5
+
+	geomTypePoint      = 1
+	geomTypeLineString = 2
+	geomTypePolygon    = 3
+
+	cmdMoveTo    = 1
+	cmdLineTo    = 2
+	cmdClosePath = 7
+)
+
+// Feature is a geometry in the srs of the tile matrix set with its attributes, ID 0 means no id
+type Feature struct {
+	ID         uint64
+	Geometry   geom.Geometry
+	Properties map[string]interface{}
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendKey(b []byte, field, wireType int) []byte {
+	return appendVarint(b, uint64(field<<3|wireType))
+}
+
+func appendBytes(b []byte, field int, data []byte) []byte {
+	b = appendKey(b, field, wireBytes)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendPacked(b []byte, field int, values []uint32) []byte {
+	var packed []byte
+	for _, v := range values {
+		packed = appendVarint(packed, uint64(v))
+	}
+	return appendBytes(b, field, packed)
+}
+
+func zigzag(v int32) uint32 {
+	return uint32((v << 1) ^ (v >> 31))
+}
+
+// encodeValue returns the Tile.Value message of v, ok is false for the types that cannot be stored
+func encodeValue(v interface{}) (msg []byte, ok bool) {
+	switch t := v.(type) {
+	case string:
+		return appendBytes(nil, 1, []byte(t)), true
+	case float32:
+		b := appendKey(nil, 2, wire32bit)
+		bits := math.Float32bits(t)
+		return append(b, byte(bits), byte(bits>>8), byte(bits>>16), byte(bits>>24)), true
+	case float64:
+		b := appendKey(nil, 3, wire64bit)
+		bits := math.Float64bits(t)
+		for i := 0; i < 8; i++ {
+			b = append(b, byte(bits>>(8*i)))
+		}
+		return b, true
+	case int:
+		return encodeValue(int64(t))
+	case int16:
+		return encodeValue(int64(t))
+	case int32:
+		return encodeValue(int64(t))
+	case int64:
+		if t < 0 {
+			return appendVarint(appendKey(nil, 6, wireVarint), uint64((t<<1)^(t>>63))), true
+		}
+		return appendVarint(appendKey(nil, 5, wireVarint), uint64(t)), true
+	case bool:
+		n := uint64(0)
+		if t {
+			n = 1
+		}
+		return appendVarint(appendKey(nil, 7, wireVarint), n), true
+	case nil, []byte:
+		return nil, false
+	default:
+		return appendBytes(nil, 1, []byte(fmt.Sprint(t))), true
+	}
+}
+
+// tileTransform converts srs coordinates to tile units, y going down
+type tileTransform struct {
+	env    geom.Envelope
+	extent float64
+}
+
+func (t tileTransform) apply(c geom.Coord) (float64, float64) {
+	return (c.X - t.env.MinX) / (t.env.MaxX - t.env.MinX) * t.extent,
+		(t.env.MaxY - c.Y) / (t.env.MaxY - t.env.MinY) * t.extent
+}
+
+type point struct{ x, y float64 }
+
+type clipBox struct{ min, max float64 }
+
+func (c clipBox) inside(p point) bool {
+	return p.x >= c.min && p.x <= c.max && p.y >= c.min && p.y <= c.max
+}
+
+// clipLine clips a polyline with the Liang-Barsky algorithm, a line leaving and entering again gives several parts
+func (c clipBox) clipLine(line []point) [][]point {
+	var parts [][]point
+	var current []point
+	for i := 0; i+1 < len(line); i++ {
+		a, b := line[i], line[i+1]
+		t0, t1 := 0.0, 1.0
+		dx, dy := b.x-a.x, b.y-a.y
+		visible := true
+		for _, edge := range [4][2]float64{{-dx, a.x - c.min}, {dx, c.max - a.x}, {-dy, a.y - c.min}, {dy, c.max - a.y}} {
+			p, q := edge[0], edge[1]
+			if p == 0 {
+				if q < 0 {
+					visible = false
+					break
+				}
+				continue
+			}
+			r := q / p
+			if p < 0 {
+				if r > t1 {
+					visible = false
+					break
+				}
+				t0 = math.Max(t0, r)
+			} else {
+				if r < t0 {
+					visible = false
+					break
+				}
+				t1 = math.Min(t1, r)
+			}
+		}
+		if !visible {
+			if current != nil {
+				parts, current = append(parts, current), nil
+			}
+			continue
+		}
+		start := point{a.x + t0*dx, a.y + t0*dy}
+		end := point{a.x + t1*dx, a.y + t1*dy}
+		if current == nil {
+			current = []point{start}
+		}
+		current = append(current, end)
+		if t1 < 1 {
+			parts, current = append(parts, current), nil
+		}
+	}
+	if current != nil {
+		parts = append(parts, current)
+	}
+	return parts
+}
+
+// clipRing clips a polygon ring with the Sutherland-Hodgman algorithm
+func (c clipBox) clipRing(ring []point) []point {
+	type edge struct {
+		inside    func(p point) bool
+		intersect func(a, b point) point
+	}
+	at := func(a, b point, t float64) point { return point{a.x + t*(b.x-a.x), a.y + t*(b.y-a.y)} }
+	edges := []edge{
+		{func(p point) bool { return p.x >= c.min }, func(a, b point) point { return at(a, b, (c.min-a.x)/(b.x-a.x)) }},
+		{func(p point) bool { return p.x <= c.max }, func(a, b point) point { return at(a, b, (c.max-a.x)/(b.x-a.x)) }},
+		{func(p point) bool { return p.y >= c.min }, func(a, b point) point { return at(a, b, (c.min-a.y)/(b.y-a.y)) }},
+		{func(p point) bool { return p.y <= c.max }, func(a, b point) point { return at(a, b, (c.max-a.y)/(b.y-a.y)) }},
+	}
+	res := ring
+	for _, e := range edges {
+		if len(res) == 0 {
+			return nil
+		}
+		input := res
+		res = nil
+		prev := input[len(input)-1]
+		for _, p := range input {
+			if e.inside(p) {
+				if !e.inside(prev) {
+					res = append(res, e.intersect(prev, p))
+				}
+				res = append(res, p)
+			} else if e.inside(prev) {
+				res = append(res, e.intersect(prev, p))
+			}
+			prev = p
+		}
+	}
+	return res
+}
+
+type intPoint struct{ x, y int32 }
+
+// quantize rounds the points to tile units and removes the consecutive duplicates
+func quantize(points []point) []intPoint {
+	res := make([]intPoint, 0, len(points))
+	for _, p := range points {
+		q := intPoint{int32(math.Round(p.x)), int32(math.Round(p.y))}
+		if len(res) == 0 || res[len(res)-1] != q {
+			res = append(res, q)
+		}
+	}
+	return res
+}
+
+// ringArea returns twice the signed area with the surveyor's formula, positive for an exterior ring in tile units
+func ringArea(ring []intPoint) int64 {
+	var area int64
+	for i := range ring {
+		j := (i + 1) % len(ring)
+		area += int64(ring[i].x)*int64(ring[j].y) - int64(ring[j].x)*int64(ring[i].y)
+	}
+	return area
+}
+
+// geometryEncoder writes the commands of the Feature.geometry field with the cursor kept between the parts
+type geometryEncoder struct {
+	commands []uint32
+	cursor   intPoint
+}
+
+func (e *geometryEncoder) moveTo(points []intPoint) {
+	e.commands = append(e.commands, uint32(cmdMoveTo|len(points)<<3))
+	for _, p := range points {
+		e.commands = append(e.commands, zigzag(p.x-e.cursor.x), zigzag(p.y-e.cursor.y))
+		e.cursor = p
+	}
+}
+
+func (e *geometryEncoder) lineTo(points []intPoint) {
+	e.commands = append(e.commands, uint32(cmdLineTo|len(points)<<3))
+	for _, p := range points {
+		e.commands = append(e.commands, zigzag(p.x-e.cursor.x), zigzag(p.y-e.cursor.y))
+		e.cursor = p
+	}
+}
+
+func (e *geometryEncoder) closePath() {
+	e.commands = append(e.commands, uint32(cmdClosePath|1<<3))
+}
+
+// featureEncoder converts geometries to tile commands
+type featureEncoder struct {
+	transform tileTransform
+	clip      clipBox
+}
+
+func (f *featureEncoder) toPoints(coords []geom.Coord) []point {
+	res := make([]point, len(coords))
+	for i, c := range coords {
+		res[i].x, res[i].y = f.transform.apply(c)
+	}
+	return res
+}
+
+func (f *featureEncoder) encodePoints(e *geometryEncoder, coords []geom.Coord) {
+	var kept []intPoint
+	for _, p := range f.toPoints(coords) {
+		if f.clip.inside(p) {
+			kept = append(kept, intPoint{int32(math.Round(p.x)), int32(math.Round(p.y))})
+		}
+	}
+	if len(kept) > 0 {
+		e.moveTo(kept)
+	}
+}
+
+func (f *featureEncoder) encodeLine(e *geometryEncoder, coords []geom.Coord) {
+	for _, part := range f.clip.clipLine(f.toPoints(coords)) {
+		line := quantize(part)
+		if len(line) < 2 {
+			continue
+		}
+		e.moveTo(line[:1])
+		e.lineTo(line[1:])
+	}
+}
+
+func (f *featureEncoder) encodePolygon(e *geometryEncoder, rings [][]geom.Coord) {
+	for i, ring := range rings {
+		points := f.toPoints(ring)
+		// the closing point is implicit in the tile format
+		if len(points) > 1 && points[0] == points[len(points)-1] {
+			points = points[:len(points)-1]
+		}
+		q := quantize(f.clip.clipRing(points))
+		if len(q) > 1 && q[0] == q[len(q)-1] {
+			q = q[:len(q)-1]
+		}
+		area := int64(0)
+		if len(q) >= 3 {
+			area = ringArea(q)
+		}
+		if area == 0 {
+			if i == 0 {
+				// without its exterior ring the holes have no meaning
+				return
+			}
+			continue
+		}
+		// exterior rings must have a positive area and interior rings a negative one
+		if (i == 0) != (area > 0) {
+			for l, r := 0, len(q)-1; l < r; l, r = l+1, r-1 {
+				q[l], q[r] = q[r], q[l]
+			}
+		}
+		e.moveTo(q[:1])
+		e.lineTo(q[1:])
+		e.closePath()
+	}
+}
+
+// encode returns the type and geometry commands of g, an empty list of commands when nothing is left in the tile
+func (f *featureEncoder) encode(g geom.Geometry) (geomType int, commands []uint32) {
+	e := &geometryEncoder{}
+	switch t := g.(type) {
+	case *geom.Point:
+		if !t.Empty {
+			f.encodePoints(e, []geom.Coord{t.Coord})
+		}
+		return geomTypePoint, e.commands
+	case *geom.MultiPoint:
+		coords := make([]geom.Coord, 0, len(t.Points))
+		for _, p := range t.Points {
+			if !p.Empty {
+				coords = append(coords, p.Coord)
+			}
+		}
+		f.encodePoints(e, coords)
+		return geomTypePoint, e.commands
+	case *geom.LineString:
+		f.encodeLine(e, t.Coords)
+		return geomTypeLineString, e.commands
+	case *geom.MultiLineString:
+		for i := range t.LineStrings {
+			f.encodeLine(e, t.LineStrings[i].Coords)
+		}
+		return geomTypeLineString, e.commands
+	case *geom.Polygon:
+		f.encodePolygon(e, t.Rings)
+		return geomTypePolygon, e.commands
+	case *geom.MultiPolygon:
+		for i := range t.Polygons {
+			f.encodePolygon(e, t.Polygons[i].Rings)
+		}
+		return geomTypePolygon, e.commands
+	}
+	return 0, nil
+}
+
+// EncodeLayer returns a tile with one layer named name holding the features that intersect the tile envelope.
+// The geometries are clipped to the tile plus buffer units, a GeometryCollection gives one tile feature per part.
+// It returns nil when no feature is left in the tile.
+func EncodeLayer(name string, features []Feature, tile geom.Envelope, extent, buffer int) []byte {
+	fe := &featureEncoder{
+		transform: tileTransform{env: tile, extent: float64(extent)},
+		clip:      clipBox{min: -float64(buffer), max: float64(extent + buffer)},
+	}
+	var keys []string
+	keyIndex := map[string]uint32{}
+	var values [][]byte
+	valueIndex := map[string]uint32{}
+
+	count := 0
+	var layer []byte
+	layer = appendVarint(appendKey(layer, 15, wireVarint), mvtVersion)
+	layer = appendBytes(layer, 1, []byte(name))
+	for _, feature := range features {
+		if feature.Geometry == nil {
+			continue
+		}
+		parts := []geom.Geometry{feature.Geometry}
+		if gc, isCollection := feature.Geometry.(*geom.GeometryCollection); isCollection {
+			parts = gc.Geometries
+		}
+		// sorted names give the same tile bytes for the same data, which keeps the ETag stable
+		names := make([]string, 0, len(feature.Properties))
+		for k := range feature.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		var tags []uint32
+		for _, k := range names {
+			msg, ok := encodeValue(feature.Properties[k])
+			if !ok {
+				continue
+			}
+			ki, exist := keyIndex[k]
+			if !exist {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+			vi, exist := valueIndex[string(msg)]
+			if !exist {
+				vi = uint32(len(values))
+				valueIndex[string(msg)] = vi
+				values = append(values, msg)
+			}
+			tags = append(tags, ki, vi)
+		}
+		for _, part := range parts {
+			geomType, commands := fe.encode(part)
+			if len(commands) == 0 {
+				continue
+			}
+			var msg []byte
+			if feature.ID != 0 {
+				msg = appendVarint(appendKey(msg, 1, wireVarint), feature.ID)
+			}
+			if len(tags) > 0 {
+				msg = appendPacked(msg, 2, tags)
+			}
+			msg = appendVarint(appendKey(msg, 3, wireVarint), uint64(geomType))
+			msg = appendPacked(msg, 4, commands)
+			layer = appendBytes(layer, 2, msg)
+			count++
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+	for _, k := range keys {
+		layer = appendBytes(layer, 3, []byte(k))
+	}
+	for _, v := range values {
+		layer = appendBytes(layer, 4, v)
+	}
+	layer = appendVarint(appendKey(layer, 5, wireVarint), uint64(extent))
+	return appendBytes(nil, 3, layer)
+}
@@ -0,0 +1,206 @@
+package tiles
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-common-libs/pkg/golog"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/database"
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/metrics"
+)
+
+const (
+	MIMEMapboxVectorTile = "application/vnd.mapbox-vector-tile"
+	tileExtension        = ".mvt"
+	tileCacheControl     = "public, max-age=3600"
+)
+
+var ErrLayerNotFound = errors.New("tile layer not found")
+
+// errLayerQuery is returned by prepareLayer when the queries of the layer cannot be registered
+var errLayerQuery = errors.New("tile layer query cannot be registered")
+
+// Server generates the vector tiles of its layers from db and keeps them in a cache
+type Server struct {
+	db     database.DB
+	log    golog.MyLogger
+	layers map[string]*preparedLayer
+	names  []string // the served layers, in the order they were given
+	cache  *Cache
+}
+
+// NewServer prepares the layers found in db, the missing tables or columns are logged and their layer skipped.
+// It fails when the queries of a layer conflict with the ones registered by another server with the same layer names.
+func NewServer(ctx context.Context, db database.DB, layers []Layer, cacheMaxBytes int64, l golog.MyLogger) (*Server, error) {
+	s := &Server{db: db, log: l, layers: map[string]*preparedLayer{}, cache: NewCache(cacheMaxBytes)}
+	for _, layer := range layers {
+		if _, exist := s.layers[layer.Name]; exist {
+			return nil, fmt.Errorf("tile layer %s is defined twice", layer.Name)
+		}
+		p, err := prepareLayer(ctx, db, layer)
+		if errors.Is(err, errLayerQuery) {
+			return nil, fmt.Errorf("tile layer %s cannot be served : %w", layer.Name, err)
+		}
+		if err != nil {
+			l.Warn("tile layer %s will not be served : %v", layer.Name, err)
+			continue
+		}
+		if db.Dialect() == database.DialectSqlite && !p.hasRtree {
+			l.Warn("tile layer %s has no rtree spatial index, every tile will read the whole table", layer.Name)
+		}
+		s.layers[layer.Name] = p
+		s.names = append(s.names, layer.Name)
+	}
+	return s, nil
+}
+
+// LayerNames returns the names of the layers served, without the ones skipped by NewServer
+func (s *Server) LayerNames() []string {
+	return slices.Clone(s.names)
+}
+
+// CacheStats returns the usage of the tile cache
+func (s *Server) CacheStats() CacheStats {
+	return s.cache.Stats()
+}
+
+// GetTile returns the tile z/x/y of layer in the tile matrix set tmsID, an empty tile has no bytes
+func (s *Server) GetTile(ctx context.Context, tmsID, layer string, z, x, y int) ([]byte, error) {
+	tms, ok := TileMatrixSets[tmsID]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown tile matrix set %s", ErrTileOutOfRange, tmsID)
+	}
+	l, ok := s.layers[layer]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrLayerNotFound, layer)
+	}
+	env, err := tms.TileEnvelope(z, x, y)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s/%s/%d/%d/%d", tmsID, layer, z, x, y)
+	if tile, found := s.cache.Get(key); found {
+		return tile, nil
+	}
+
+	var tile []byte
+	res := groundResolution(tms, z, env)
+	if l.MaxResolution == 0 || res <= l.MaxResolution {
+		n := l.activeLevels(res)
+		query := l.queries[tmsID][n]
+		if s.db.Dialect() == database.DialectPostgres {
+			tile, err = l.postgresTile(ctx, s.db, query, env)
+		} else {
+			tile, err = l.sqliteTile(ctx, s.db, query, l.columns(n), tms, env)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.cache.Add(key, tile)
+	return tile, nil
+}
+
+// GetTileHandler serves GET /tiles/{layer}/{z}/{x}/{y}.mvt on the LV95 tile matrix set
+// and GET /tiles/{tms}/{layer}/{z}/{x}/{y}.mvt on any of the TileMatrixSets
+func (s *Server) GetTileHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmsID := r.PathValue("tms")
+		if tmsID == "" {
+			tmsID = TileMatrixSetLv95
+		}
+		yValue, hasExtension := strings.CutSuffix(r.PathValue("y"), tileExtension)
+		if !hasExtension {
+			http.NotFound(w, r)
+			return
+		}
+		var coords [3]int
+		for i, value := range []string{r.PathValue("z"), r.PathValue("x"), yValue} {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("invalid tile coordinate %q", value), http.StatusBadRequest)
+				return
+			}
+			coords[i] = n
+		}
+		tile, err := s.GetTile(r.Context(), tmsID, r.PathValue("layer"), coords[0], coords[1], coords[2])
+		if err != nil {
+			if errors.Is(err, ErrLayerNotFound) || errors.Is(err, ErrTileOutOfRange) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			s.log.Error("GetTileHandler failed to get tile %s : %v", r.URL.Path, err)
+			http.Error(w, "error generating tile", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", tileCacheControl)
+		if len(tile) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		// the tiles are encoded in a stable order, the same data gives the same ETag
+		sum := sha256.Sum256(tile)
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if match := r.Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", MIMEMapboxVectorTile)
+		w.Header().Set("Content-Length", strconv.Itoa(len(tile)))
+		if _, err := w.Write(tile); err != nil {
+			s.log.Error("GetTileHandler failed to write response : %v", err)
+		}
+	}
+}
+
+// tilesInfo describes the available layers and tiling schemes for the map clients
+type tilesInfo struct {
+	Layers         []Layer          `json:"layers"`
+	TileMatrixSets []*TileMatrixSet `json:"tile_matrix_sets"`
+	Urls           []string         `json:"urls"`
+	Cache          CacheStats       `json:"cache"`
+}
+
+// GetTilesInfoHandler serves GET /tiles with the layers, tile matrix sets and url templates
+func (s *Server) GetTilesInfoHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		info := tilesInfo{
+			Urls:  []string{"/tiles/{layer}/{z}/{x}/{y}" + tileExtension, "/tiles/{tms}/{layer}/{z}/{x}/{y}" + tileExtension},
+			Cache: s.cache.Stats(),
+		}
+		for _, l := range s.layers {
+			info.Layers = append(info.Layers, l.Layer)
+		}
+		sort.Slice(info.Layers, func(i, j int) bool { return info.Layers[i].Name < info.Layers[j].Name })
+		for _, tms := range TileMatrixSets {
+			info.TileMatrixSets = append(info.TileMatrixSets, tms)
+		}
+		sort.Slice(info.TileMatrixSets, func(i, j int) bool { return info.TileMatrixSets[i].ID < info.TileMatrixSets[j].ID })
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(info); err != nil {
+			s.log.Error("GetTilesInfoHandler failed to write response : %v", err)
+		}
+	}
+}
+
+// CollectMetrics writes the usage of the tile cache in the metrics
+func (s *Server) CollectMetrics(w *metrics.Writer) {
+	stats := s.cache.Stats()
+	w.Gauge("tiles_cache_entries", "Number of tiles in the cache.", float64(stats.Entries))
+	w.Gauge("tiles_cache_bytes", "Size of the tiles in the cache, with the overhead of each entry.", float64(stats.Bytes))
+	w.Gauge("tiles_cache_max_bytes", "Maximum size of the tile cache, 0 when it is disabled.", float64(stats.MaxBytes))
+	w.Counter("tiles_cache_hits_total", "Number of tiles found in the cache.", float64(stats.Hits))
+	w.Counter("tiles_cache_misses_total", "Number of tiles not found in the cache.", float64(stats.Misses))
+}
@@ -0,0 +1,100 @@
+// Package tiles serves Mapbox Vector Tiles of the geometry tables, generated by ST_AsMVT on PostGIS
+// and by the go encoder of this package on the GeoPackage backend.
+package tiles
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/lao-tseu-is-alive/go-cloud-k8s-geo-search/pkg/geom"
+)
+
+const (
+	TileMatrixSetLv95        = "LV95"
+	TileMatrixSetWebMercator = "WebMercatorQuad"
+	defaultTileSize          = 256
+)
+
+var ErrTileOutOfRange = errors.New("tile is outside of the tile matrix set")
+
+// TileMatrixSet is a tiling scheme : a top left origin and the resolution of each zoom level, tiles are numbered
+// from the origin, x to the east and y to the south, like the XYZ urls of OpenLayers and the WMTS TileMatrix
+type TileMatrixSet struct {
+	ID          string        `json:"id"`
+	Title       string        `json:"title"`
+	SrsID       int           `json:"srs_id"`
+	TileSize    int           `json:"tile_size"`
+	OriginX     float64       `json:"origin_x"`
+	OriginY     float64       `json:"origin_y"`
+	Extent      geom.Envelope `json:"-"`
+	Resolutions []float64     `json:"resolutions"` // size of a pixel in srs units for each zoom level
+}
+
+// Lv95 is the tile matrix set of the swiss federal WMTS (EPSG:2056), also used by the Lausanne base maps
+var Lv95 = &TileMatrixSet{
+	ID:       TileMatrixSetLv95,
+	Title:    "CH1903+ / LV95 swisstopo tiling",
+	SrsID:    geom.SridLv95,
+	TileSize: defaultTileSize,
+	OriginX:  2420000,
+	OriginY:  1350000,
+	Extent:   geom.Envelope{MinX: 2420000, MinY: 1030000, MaxX: 2900000, MaxY: 1350000},
+	Resolutions: []float64{4000, 3750, 3500, 3250, 3000, 2750, 2500, 2250, 2000, 1750, 1500, 1250, 1000, 750, 650,
+		500, 250, 100, 50, 20, 10, 5, 2.5, 2, 1.5, 1, 0.5, 0.25, 0.1},
+}
+
+// WebMercator is the OGC WebMercatorQuad tile matrix set (EPSG:3857) used by most web maps
+var WebMercator = newWebMercator(24)
+
+func newWebMercator(maxZoom int) *TileMatrixSet {
+	const halfWorld = 20037508.342789244
+	t := &TileMatrixSet{
+		ID:       TileMatrixSetWebMercator,
+		Title:    "Google Maps Compatible for the World",
+		SrsID:    geom.SridWebMercator,
+		TileSize: defaultTileSize,
+		OriginX:  -halfWorld,
+		OriginY:  halfWorld,
+		Extent:   geom.Envelope{MinX: -halfWorld, MinY: -halfWorld, MaxX: halfWorld, MaxY: halfWorld},
+	}
+	for z := 0; z <= maxZoom; z++ {
+		t.Resolutions = append(t.Resolutions, 2*halfWorld/defaultTileSize/math.Pow(2, float64(z)))
+	}
+	return t
+}
+
+// TileMatrixSets are the tiling schemes available for the tiles, by id
+var TileMatrixSets = map[string]*TileMatrixSet{
+	Lv95.ID:        Lv95,
+	WebMercator.ID: WebMercator,
+}
+
+// MaxZoom returns the last zoom level
+func (t *TileMatrixSet) MaxZoom() int {
+	return len(t.Resolutions) - 1
+}
+
+// MatrixSize returns the number of columns and rows at zoom level z
+func (t *TileMatrixSet) MatrixSize(z int) (width, height int) {
+	span := t.Resolutions[z] * float64(t.TileSize)
+	return int(math.Ceil((t.Extent.MaxX-t.OriginX)/span - 1e-9)), int(math.Ceil((t.OriginY-t.Extent.MinY)/span - 1e-9))
+}
+
+// TileEnvelope returns the envelope in srs units of the tile z/x/y
+func (t *TileMatrixSet) TileEnvelope(z, x, y int) (geom.Envelope, error) {
+	if z < 0 || z > t.MaxZoom() {
+		return geom.Envelope{}, fmt.Errorf("%w: zoom %d not in [0, %d]", ErrTileOutOfRange, z, t.MaxZoom())
+	}
+	width, height := t.MatrixSize(z)
+	if x < 0 || x >= width || y < 0 || y >= height {
+		return geom.Envelope{}, fmt.Errorf("%w: tile %d/%d/%d not in a %dx%d matrix", ErrTileOutOfRange, z, x, y, width, height)
+	}
+	span := t.Resolutions[z] * float64(t.TileSize)
+	return geom.Envelope{
+		MinX: t.OriginX + float64(x)*span,
+		MaxX: t.OriginX + float64(x+1)*span,
+		MinY: t.OriginY - float64(y+1)*span,
+		MaxY: t.OriginY - float64(y)*span,
+	}, nil
+}
@@ -0,0 +1,19 @@
+#!/bin/bash
+## compressFrontDist.sh
+## version : 1.0.0
+## script to write the gzip and brotli variants of the front-end build, served by goCloudGeoSearchServer
+## when the browser accepts them, run it after npm run build in cmd/goCloudGeoSearchServer/goCloudGeoSearchFront
+set -e
+DIST_DIR=${1:-cmd/goCloudGeoSearchServer/goCloudGeoSearchFront/dist}
+if [ ! -d "${DIST_DIR}" ]; then
+  echo "## 💥💥 directory ${DIST_DIR} does not exist, build the front-end first"
+  exit 1
+fi
+find "${DIST_DIR}" -type f \( -name '*.html' -o -name '*.js' -o -name '*.css' -o -name '*.svg' -o -name '*.json' \) -size +1k |
+  while read -r f; do
+    gzip -k -f -9 "${f}"
+    if command -v brotli >/dev/null; then
+      brotli -k -f -q 11 "${f}"
+    fi
+  done
+echo "## compressed the files of ${DIST_DIR}"